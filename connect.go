@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/dansun78/go-remote-term/pkg/client"
+	"github.com/gorilla/websocket"
+	"golang.org/x/term"
+)
+
+// Longest pause between attempts to reconnect a dropped connection
+const maxReconnectDelay = 10 * time.Second
+
+// errDisconnected is returned while reconnecting once the user asked to disconnect
+var errDisconnected = errors.New("disconnected")
+
+// runConnect implements the connect subcommand: it attaches the local terminal to a
+// session on a remote server, reconnecting to the same session whenever the connection
+// drops, and returns the exit code to exit with
+func runConnect(args []string) int {
+	flags := flag.NewFlagSet("connect", flag.ExitOnError)
+	serverURL := flags.String("url", "ws://localhost:8080/ws", "WebSocket URL of the server's terminal endpoint")
+	authToken := flags.String("token", os.Getenv("GO_REMOTE_TERM_TOKEN"), "Authentication token (default: $GO_REMOTE_TERM_TOKEN)")
+	shareToken := flags.String("share", "", "Share link token to join a shared session with instead of -token")
+	sessionID := flags.String("session", "", "ID of the session to attach to (default: start a new session)")
+	profile := flags.String("profile", "", "Command profile to start a new session with")
+	target := flags.String("target", "", "SSH target of the server to open a new session on")
+	container := flags.String("container", "", "ID or name of the container to open a new session in")
+	serialPort := flags.String("serial", "", "Serial port of the server to attach to")
+	insecureTLS := flags.Bool("insecure-skip-verify", false, "Accept any TLS certificate, e.g. the self-signed ones generated by -secure")
+	reconnectTimeout := flags.Duration("reconnect-timeout", 5*time.Minute, "How long to keep trying to reconnect after the connection drops")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s connect [options]\n\n", AppName)
+		fmt.Fprintln(flags.Output(), "Attaches this terminal to a session on a remote server. Type ~. at the start of a line to disconnect, ~B to send a break.")
+		fmt.Fprintln(flags.Output())
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	options := client.Options{
+		Token:      *authToken,
+		Share:      *shareToken,
+		SessionID:  *sessionID,
+		Profile:    *profile,
+		Target:     *target,
+		Container:  *container,
+		SerialPort: *serialPort,
+	}
+	if *insecureTLS {
+		dialer := *websocket.DefaultDialer
+		dialer.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+		options.Dialer = &dialer
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	c, err := client.Dial(ctx, *serverURL, options)
+	cancel()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to connect to %s: %v\n", *serverURL, err)
+		return 1
+	}
+	defer c.Close()
+	fmt.Fprintf(os.Stderr, "Connected to session %s, type ~. at the start of a line to disconnect\n", c.SessionID())
+
+	// Pass every keystroke through to the remote shell
+	stdin := int(os.Stdin.Fd())
+	if term.IsTerminal(stdin) {
+		oldState, err := term.MakeRaw(stdin)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to put the terminal into raw mode: %v\n", err)
+			return 1
+		}
+		defer term.Restore(stdin, oldState)
+	}
+
+	// Keep the remote terminal the size of the local one
+	resize := func() {
+		if cols, rows, err := term.GetSize(int(os.Stdout.Fd())); err == nil {
+			c.Resize(uint16(rows), uint16(cols))
+		}
+	}
+	resize()
+
+	var quitOnce sync.Once
+	quit := make(chan struct{})
+	disconnect := func() {
+		quitOnce.Do(func() {
+			close(quit)
+			c.Close()
+		})
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, append(resizeSignals, syscall.SIGTERM, syscall.SIGHUP)...)
+	defer signal.Stop(signals)
+	go func() {
+		for sig := range signals {
+			if sig == syscall.SIGTERM || sig == syscall.SIGHUP {
+				disconnect()
+			} else {
+				resize()
+			}
+		}
+	}()
+
+	go forwardInput(c, disconnect)
+
+	for {
+		event, err := c.Next()
+		if err == nil {
+			if event.Output != nil {
+				os.Stdout.Write(event.Output)
+			} else if event.Control.Type == "server_restart" || event.Control.Type == "server_shutdown" {
+				notice(event.Control.Message)
+			} else if event.Control.Type == "break_response" && !event.Control.Success {
+				notice("Failed to send break: " + event.Control.Message)
+			}
+			continue
+		}
+
+		if !disconnected(quit) && reconnectable(err) {
+			notice(fmt.Sprintf("Connection lost: %v, reconnecting", err))
+			previous := c.SessionID()
+			err = reconnect(c, *reconnectTimeout, quit)
+			if err == nil && c.SessionID() != previous {
+				// The server starts a new session in place of one that no longer exists,
+				// which is not the session the user was working in
+				c.Terminate()
+				notice(fmt.Sprintf("Session %s no longer exists", previous))
+				return 1
+			}
+			if err == nil {
+				// The session's scrollback is replayed from the top of a clean screen
+				os.Stdout.WriteString("\x1b[H\x1b[2J")
+				resize()
+				continue
+			}
+		}
+
+		var ended *client.SessionEndedError
+		switch {
+		case disconnected(quit):
+			notice(fmt.Sprintf("Disconnected from session %s", c.SessionID()))
+			return 0
+		case errors.As(err, &ended):
+			notice(ended.End.Describe())
+			if ended.End.ExitCode >= 0 {
+				return ended.End.ExitCode
+			}
+			return 1
+		default:
+			notice(fmt.Sprintf("Connection lost: %v", err))
+			return 1
+		}
+	}
+}
+
+// forwardInput sends standard input to the session until it is closed or the user
+// types the ~. escape sequence at the start of a line. ~B sends a break and ~~ a single ~.
+func forwardInput(c *client.Client, disconnect func()) {
+	buf := make([]byte, 4096)
+	atLineStart := true
+	escaped := false
+
+	for {
+		n, err := os.Stdin.Read(buf)
+		if err != nil {
+			return
+		}
+
+		var input []byte
+		for _, b := range buf[:n] {
+			if escaped {
+				escaped = false
+				if b == '.' {
+					disconnect()
+					return
+				}
+				if b == 'B' {
+					// Input typed before the break goes first
+					if len(input) > 0 {
+						c.Write(input)
+						input = nil
+					}
+					c.Break()
+					continue
+				}
+				if b != '~' {
+					input = append(input, '~')
+				}
+			} else if atLineStart && b == '~' {
+				escaped = true
+				continue
+			}
+			input = append(input, b)
+			atLineStart = b == '\r' || b == '\n'
+		}
+
+		// Input typed while reconnecting is dropped
+		if len(input) > 0 {
+			c.Write(input)
+		}
+	}
+}
+
+// reconnect attaches the client to its session again, retrying with increasing
+// delays until the timeout expires or the user disconnects
+func reconnect(c *client.Client, timeout time.Duration, quit <-chan struct{}) error {
+	deadline := time.Now().Add(timeout)
+	delay := 500 * time.Millisecond
+
+	for {
+		ctx, cancel := context.WithDeadline(context.Background(), deadline)
+		err := c.Reconnect(ctx)
+		cancel()
+		if err == nil || !reconnectable(err) || time.Now().Add(delay).After(deadline) {
+			return err
+		}
+
+		select {
+		case <-quit:
+			return errDisconnected
+		case <-time.After(delay):
+		}
+		delay = min(2*delay, maxReconnectDelay)
+	}
+}
+
+// reconnectable reports whether attaching to the session again may fix err
+func reconnectable(err error) bool {
+	var authErr *client.AuthError
+	var ended *client.SessionEndedError
+	return !errors.As(err, &authErr) && !errors.As(err, &ended)
+}
+
+// disconnected reports whether the user asked to disconnect
+func disconnected(quit <-chan struct{}) bool {
+	select {
+	case <-quit:
+		return true
+	default:
+		return false
+	}
+}
+
+// notice prints a status line between the output of the remote terminal
+func notice(message string) {
+	fmt.Fprintf(os.Stderr, "\r\n[%s]\r\n", message)
+}
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// resizeSignals is empty, there is no signal telling that the local terminal was
+// resized here, so the remote terminal keeps its initial size
+var resizeSignals []os.Signal
@@ -0,0 +1,11 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// resizeSignals are the signals telling that the local terminal was resized
+var resizeSignals = []os.Signal{syscall.SIGWINCH}
@@ -1,21 +1,47 @@
 package logger
 
 import (
+	"context"
 	"log"
 	"net/http"
 	"time"
 )
 
+// Key for the per-request log details in request context
+type contextKey string
+
+const requestInfoKey contextKey = "request_info"
+
+// requestInfo collects details about a request that are only known to inner handlers
+type requestInfo struct {
+	principal string
+}
+
+// SetPrincipal records who made the request so that it appears in the request log
+// It has no effect on requests that did not pass through RequestLoggerMiddleware
+func SetPrincipal(ctx context.Context, name string) {
+	if info, ok := ctx.Value(requestInfoKey).(*requestInfo); ok {
+		info.principal = name
+	}
+}
+
 // RequestLoggerMiddleware is a simple middleware that logs request information
 func RequestLoggerMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
 
+		info := &requestInfo{}
+		r = r.WithContext(context.WithValue(r.Context(), requestInfoKey, info))
+
 		// Call the next handler in the chain
 		next.ServeHTTP(w, r)
 
 		// Log request details after handler completes
 		duration := time.Since(start)
+		if info.principal != "" {
+			log.Printf("[%s] %s %s as %s (took %v)", r.Method, r.URL.Path, r.RemoteAddr, info.principal, duration)
+			return
+		}
 		log.Printf("[%s] %s %s (took %v)", r.Method, r.URL.Path, r.RemoteAddr, duration)
 	})
 }
@@ -42,6 +42,20 @@ func shouldRedirectToLogin(r *http.Request) bool {
 		!strings.HasSuffix(r.URL.Path, ".ico")
 }
 
+// isSharePagePath reports whether a path belongs to the terminal page that share links open
+func isSharePagePath(path string) bool {
+	switch path {
+	case "/", "/index.html", "/terminal.js":
+		return true
+	}
+	return false
+}
+
+// isValidShare checks a share link token with the configured validator
+func isValidShare(token string) bool {
+	return token != "" && config.ShareValidator != nil && config.ShareValidator(token)
+}
+
 // isOriginAllowed checks if the given origin is in the allowed list
 func isOriginAllowed(origin string) bool {
 	if origin == "" {
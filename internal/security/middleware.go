@@ -4,6 +4,8 @@ import (
 	"context"
 	"net/http"
 	"strings"
+
+	"github.com/dansun78/go-remote-term/internal/logger"
 )
 
 // AuthenticateMiddleware authenticates incoming HTTP requests
@@ -19,8 +21,8 @@ func AuthenticateMiddleware(next http.Handler) http.Handler {
 		ctx := context.WithValue(r.Context(), TokenContextKey, config.AuthToken)
 		newRequest := r.WithContext(ctx)
 
-		// If auth token is set, validate it
-		if config.AuthToken != "" {
+		// If authentication is configured, validate the request
+		if authEnabled() {
 			// For WebSocket endpoints, don't check credentials here
 			// We'll validate them after the WebSocket connection is established
 			if strings.HasPrefix(newRequest.URL.Path, "/ws") {
@@ -29,13 +31,20 @@ func AuthenticateMiddleware(next http.Handler) http.Handler {
 				return
 			}
 
-			// For API endpoints, check Authorization header
+			// For API and metrics endpoints, check Authorization header
+			// Prometheus scrapers authenticate the same way as API clients
 			authHeader := newRequest.Header.Get("Authorization")
-			if strings.HasPrefix(newRequest.URL.Path, "/api") {
-				if authHeader != "Bearer "+config.AuthToken {
+			if strings.HasPrefix(newRequest.URL.Path, "/api") || newRequest.URL.Path == "/metrics" {
+				principal, ok := Authenticate(strings.TrimPrefix(authHeader, "Bearer "))
+				if !strings.HasPrefix(authHeader, "Bearer ") || !ok {
 					http.Error(w, "Unauthorized: Invalid or missing token", http.StatusUnauthorized)
 					return
 				}
+				newRequest = withPrincipal(newRequest, principal)
+				if principal.Role != RoleAdmin {
+					http.Error(w, "Forbidden: Admin role required", http.StatusForbidden)
+					return
+				}
 				next.ServeHTTP(w, newRequest)
 				return
 			}
@@ -57,7 +66,35 @@ func AuthenticateMiddleware(next http.Handler) http.Handler {
 			}
 
 			// Check if token is valid
-			isValidToken := (tokenParam == config.AuthToken || (err == nil && tokenCookie.Value == config.AuthToken))
+			principal, isValidParam := Authenticate(tokenParam)
+			isValidToken := isValidParam
+			if !isValidToken && err == nil {
+				principal, isValidToken = Authenticate(tokenCookie.Value)
+			}
+
+			// Guests holding a share link may load the terminal page, but nothing else
+			if !isValidToken && isSharePagePath(newRequest.URL.Path) {
+				shareParam := newRequest.URL.Query().Get("share")
+				shareCookie, shareErr := newRequest.Cookie("share_token")
+
+				if isValidShare(shareParam) {
+					// Remember the share so the page's scripts and styles load too
+					http.SetCookie(w, &http.Cookie{
+						Name:     "share_token",
+						Value:    shareParam,
+						HttpOnly: true,
+						Secure:   isHTTPS(newRequest),
+						Path:     "/",
+						MaxAge:   3600 * 24, // 1 day
+					})
+					next.ServeHTTP(w, newRequest)
+					return
+				}
+				if shareErr == nil && isValidShare(shareCookie.Value) {
+					next.ServeHTTP(w, newRequest)
+					return
+				}
+			}
 
 			// If token is invalid, redirect to login page with error message
 			if !isValidToken {
@@ -76,12 +113,19 @@ func AuthenticateMiddleware(next http.Handler) http.Handler {
 				}
 				return
 			}
+			newRequest = withPrincipal(newRequest, principal)
+
+			// Recordings contain everyone's sessions, so only admins may watch them
+			if strings.HasPrefix(newRequest.URL.Path, "/recordings") && principal.Role != RoleAdmin {
+				http.Error(w, "Forbidden: Admin role required", http.StatusForbidden)
+				return
+			}
 
 			// If token is valid, set it as a cookie for future requests
-			if tokenParam == config.AuthToken {
+			if isValidParam {
 				http.SetCookie(w, &http.Cookie{
 					Name:     "auth_token",
-					Value:    config.AuthToken,
+					Value:    tokenParam,
 					HttpOnly: true,
 					Secure:   isHTTPS(newRequest),
 					Path:     "/",
@@ -94,6 +138,12 @@ func AuthenticateMiddleware(next http.Handler) http.Handler {
 	})
 }
 
+// withPrincipal attaches the authenticated principal to the request and to its log entry
+func withPrincipal(r *http.Request, principal Principal) *http.Request {
+	logger.SetPrincipal(r.Context(), principal.Name)
+	return r.WithContext(context.WithValue(r.Context(), PrincipalContextKey, principal))
+}
+
 // CORSMiddleware adds CORS headers to responses
 func CORSMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -107,7 +157,7 @@ func CORSMiddleware(next http.Handler) http.Handler {
 				// Set CORS headers for allowed origins
 				w.Header().Set("Access-Control-Allow-Origin", origin)
 				w.Header().Set("Access-Control-Allow-Credentials", "true")
-				w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+				w.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE, OPTIONS")
 				w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
 
 				// Handle preflight requests
@@ -1,6 +1,7 @@
 package security
 
 import (
+	"context"
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
@@ -23,10 +24,21 @@ type contextKey string
 
 const TokenContextKey contextKey = "auth_token"
 
+// Key for the authenticated Principal in request context
+const PrincipalContextKey contextKey = "principal"
+
 // Config holds configuration options for security features
 type Config struct {
 	InsecureMode bool   // Disable localhost-only restriction for HTTP mode (allows remote connections)
 	AuthToken    string // Authentication token for session access
+
+	// Tokens holds named per-person tokens. When set it replaces AuthToken.
+	Tokens *TokenStore
+
+	// ShareValidator reports whether a share link token is valid. Share links
+	// only grant access to the terminal page; the session itself is checked
+	// again when the WebSocket authenticates. Nil disables share links.
+	ShareValidator func(token string) bool
 }
 
 // Current security configuration, set by main.go
@@ -54,6 +66,17 @@ func GetAuthToken() string {
 	return config.AuthToken
 }
 
+// authEnabled reports whether requests need to be authenticated
+func authEnabled() bool {
+	return config.AuthToken != "" || config.Tokens != nil
+}
+
+// PrincipalFromContext returns the principal that authenticated a request, if any
+func PrincipalFromContext(ctx context.Context) (Principal, bool) {
+	principal, ok := ctx.Value(PrincipalContextKey).(Principal)
+	return principal, ok
+}
+
 // GenerateRandomToken creates a UUIDv4 token for authentication
 func GenerateRandomToken() (string, error) {
 	// Generate a UUIDv4 (random UUID)
@@ -0,0 +1,144 @@
+package security
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// Role determines what an authenticated principal may do
+type Role string
+
+const (
+	// RoleAdmin may use every session, the management API, metrics and recordings
+	RoleAdmin Role = "admin"
+	// RoleUser may create sessions and use their own
+	RoleUser Role = "user"
+	// RoleViewer may only watch existing sessions
+	RoleViewer Role = "viewer"
+)
+
+// Principal is the identity behind an authentication token
+type Principal struct {
+	Name string
+	Role Role
+}
+
+// defaultPrincipal is the identity of the single token configured with Config.AuthToken
+var defaultPrincipal = Principal{Name: "default", Role: RoleAdmin}
+
+// tokenEntry is a single token in a token file
+type tokenEntry struct {
+	Name        string     `json:"name"`
+	TokenSHA256 string     `json:"token_sha256"` // Hex-encoded SHA-256 of the token
+	Role        Role       `json:"role"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+}
+
+// TokenStore holds named tokens loaded from a token file.
+// Only SHA-256 hashes of the tokens are kept, both on disk and in memory.
+type TokenStore struct {
+	path    string
+	lock    sync.RWMutex
+	entries map[string]tokenEntry // By token hash
+}
+
+// LoadTokenStore loads a token file. The file is a JSON array of entries:
+//
+//	[{"name": "alice", "token_sha256": "<hex>", "role": "admin", "expires_at": "2025-12-31T00:00:00Z"}]
+//
+// expires_at is optional.
+func LoadTokenStore(path string) (*TokenStore, error) {
+	store := &TokenStore{path: path}
+	if err := store.Reload(); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// Reload re-reads the token file. On error the previously loaded tokens stay in effect.
+func (s *TokenStore) Reload() error {
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return fmt.Errorf("failed to read token file: %v", err)
+	}
+
+	var list []tokenEntry
+	if err := json.Unmarshal(data, &list); err != nil {
+		return fmt.Errorf("failed to parse token file: %v", err)
+	}
+
+	entries := make(map[string]tokenEntry, len(list))
+	names := make(map[string]bool, len(list))
+	for i, entry := range list {
+		if entry.Name == "" {
+			return fmt.Errorf("token %d in %s has no name", i+1, s.path)
+		}
+		if names[entry.Name] {
+			return fmt.Errorf("duplicate token name %q in %s", entry.Name, s.path)
+		}
+		if entry.Role != RoleAdmin && entry.Role != RoleUser && entry.Role != RoleViewer {
+			return fmt.Errorf("token %q has invalid role %q", entry.Name, entry.Role)
+		}
+		hash, err := hex.DecodeString(entry.TokenSHA256)
+		if err != nil || len(hash) != sha256.Size {
+			return fmt.Errorf("token %q has an invalid token_sha256", entry.Name)
+		}
+		key := hex.EncodeToString(hash)
+		if _, exists := entries[key]; exists {
+			return fmt.Errorf("token %q has the same hash as another token", entry.Name)
+		}
+
+		names[entry.Name] = true
+		entries[key] = entry
+	}
+
+	s.lock.Lock()
+	s.entries = entries
+	s.lock.Unlock()
+
+	log.Printf("Loaded %d tokens from %s", len(entries), s.path)
+	return nil
+}
+
+// Authenticate returns the principal a token belongs to, if the token is known and not expired
+func (s *TokenStore) Authenticate(token string) (Principal, bool) {
+	if token == "" {
+		return Principal{}, false
+	}
+
+	s.lock.RLock()
+	entry, exists := s.entries[HashToken(token)]
+	s.lock.RUnlock()
+
+	if !exists {
+		return Principal{}, false
+	}
+	if entry.ExpiresAt != nil && time.Now().After(*entry.ExpiresAt) {
+		return Principal{}, false
+	}
+	return Principal{Name: entry.Name, Role: entry.Role}, true
+}
+
+// HashToken returns the hex-encoded SHA-256 hash of a token as stored in token files
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Authenticate returns the principal a token belongs to using the current configuration:
+// the token store if one is configured, otherwise the single configured token
+func Authenticate(token string) (Principal, bool) {
+	if config.Tokens != nil {
+		return config.Tokens.Authenticate(token)
+	}
+	if token == "" || config.AuthToken == "" || token != config.AuthToken {
+		return Principal{}, false
+	}
+	return defaultPrincipal, true
+}
@@ -0,0 +1,76 @@
+package security
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeTokenFile writes a token file with the given contents and returns its path
+func writeTokenFile(t *testing.T, dir, contents string) string {
+	t.Helper()
+
+	path := filepath.Join(dir, "tokens.json")
+	if err := os.WriteFile(path, []byte(contents), 0600); err != nil {
+		t.Fatalf("Failed to write token file: %v", err)
+	}
+	return path
+}
+
+func TestTokenStoreAuthenticate(t *testing.T) {
+	expired := time.Now().Add(-time.Hour).Format(time.RFC3339)
+	path := writeTokenFile(t, t.TempDir(), fmt.Sprintf(`[
+		{"name": "alice", "token_sha256": %q, "role": "admin"},
+		{"name": "bob", "token_sha256": %q, "role": "viewer", "expires_at": %q}
+	]`, HashToken("alice-token"), HashToken("bob-token"), expired))
+
+	store, err := LoadTokenStore(path)
+	if err != nil {
+		t.Fatalf("Failed to load token store: %v", err)
+	}
+
+	principal, ok := store.Authenticate("alice-token")
+	if !ok || principal.Name != "alice" || principal.Role != RoleAdmin {
+		t.Errorf("Unexpected principal for alice: %+v, %v", principal, ok)
+	}
+	if _, ok := store.Authenticate("bob-token"); ok {
+		t.Errorf("Expected expired token to be rejected")
+	}
+	if _, ok := store.Authenticate("unknown"); ok {
+		t.Errorf("Expected unknown token to be rejected")
+	}
+}
+
+func TestTokenStoreReload(t *testing.T) {
+	dir := t.TempDir()
+	path := writeTokenFile(t, dir, fmt.Sprintf(`[{"name": "alice", "token_sha256": %q, "role": "user"}]`,
+		HashToken("old-token")))
+
+	store, err := LoadTokenStore(path)
+	if err != nil {
+		t.Fatalf("Failed to load token store: %v", err)
+	}
+
+	// A broken file is rejected and the previous tokens stay in effect
+	writeTokenFile(t, dir, `[{"name": "alice", "token_sha256": "not-hex", "role": "user"}]`)
+	if err := store.Reload(); err == nil {
+		t.Errorf("Expected reload of an invalid file to fail")
+	}
+	if _, ok := store.Authenticate("old-token"); !ok {
+		t.Errorf("Expected previous tokens to survive a failed reload")
+	}
+
+	writeTokenFile(t, dir, fmt.Sprintf(`[{"name": "alice", "token_sha256": %q, "role": "user"}]`,
+		HashToken("new-token")))
+	if err := store.Reload(); err != nil {
+		t.Fatalf("Failed to reload token store: %v", err)
+	}
+	if _, ok := store.Authenticate("old-token"); ok {
+		t.Errorf("Expected rotated token to be rejected after reload")
+	}
+	if _, ok := store.Authenticate("new-token"); !ok {
+		t.Errorf("Expected new token to be accepted after reload")
+	}
+}
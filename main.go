@@ -5,9 +5,13 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/dansun78/go-remote-term/internal/logger"
 	"github.com/dansun78/go-remote-term/internal/network"
@@ -32,37 +36,126 @@ var (
 	secure         = flag.Bool("secure", false, "Force HTTPS usage (generates self-signed cert if not provided)")
 	insecure       = flag.Bool("insecure", false, "Disable localhost-only restriction for HTTP mode (allows remote connections)")
 	token          = flag.String("token", "", "Authentication token for accessing the terminal (if empty, a random token will be generated)")
+	tokenFile      = flag.String("token-file", "", "JSON file of named tokens with roles (replaces -token; reloaded on SIGHUP)")
 	versionFlag    = flag.Bool("version", false, "Display version information")
 	allowedOrigins = flag.String("allowed-origins", "", "Comma-separated list of allowed origins for CORS (default: localhost URLs only)")
+	recordDir      = flag.String("record-dir", "", "Directory to write asciicast recordings of every session to (recording disabled if empty)")
+	recordInput    = flag.Bool("record-input", false, "Include keystrokes typed by clients in session recordings")
+	shutdownGrace  = flag.Duration("shutdown-grace", 5*time.Second, "Time to wait for clients and shells to finish when shutting down")
+	hangupGrace    = flag.Duration("hangup-grace", terminal.DefaultHangupGrace, "Time the processes of a closing session get to exit after SIGHUP before SIGTERM")
+	terminateGrace = flag.Duration("terminate-grace", terminal.DefaultTerminateGrace, "Time the processes of a closing session get to exit after SIGTERM before SIGKILL")
+	profilesFile   = flag.String("profiles", "", "JSON file of named command profiles clients may start sessions with")
+	targetsFile    = flag.String("targets", "", "JSON file of SSH hosts clients may open sessions on, making the server a bastion")
+	dockerEnabled  = flag.Bool("docker", false, "Let clients open sessions in running Docker containers")
+	dockerSocket   = flag.String("docker-socket", terminal.DefaultDockerSocket, "Unix socket of the Docker Engine API")
+	dockerLabels   = flag.String("docker-labels", "", "Comma-separated label filters (key or key=value) containers must all match to be offered (default: every running container)")
+	dockerCommand  = flag.String("docker-command", "/bin/sh", "Command to run in containers")
+	dockerUser     = flag.String("docker-user", "", "User to run the command as in containers (default: the container's user)")
+	serialFile     = flag.String("serial-ports", "", "JSON file of serial ports, such as board consoles, clients may attach sessions to")
+	userMapFile    = flag.String("user-map", "", "JSON file mapping principal names to the OS users their shells run as (requires root)")
+	rlimitCPU      = flag.Uint64("rlimit-cpu", 0, "CPU seconds each process of a session may use (0 for no limit)")
+	rlimitNoFile   = flag.Uint64("rlimit-nofile", 0, "Open files each process of a session may have (0 for no limit)")
+	rlimitNProc    = flag.Uint64("rlimit-nproc", 0, "Processes the user of a session may have (0 for no limit, not enforced for root)")
+	rlimitASMB     = flag.Uint64("rlimit-as-mb", 0, "Address space in MiB each process of a session may use (0 for no limit)")
+	noCoreDumps    = flag.Bool("no-core-dumps", false, "Disable core dumps of session processes")
+	cgroupParent   = flag.String("cgroup-parent", "", "cgroup v2 directory to create a cgroup per session in (disabled if empty)")
+	cgroupMemoryMB = flag.Int64("cgroup-memory-mb", 0, "Memory in MiB each session may use, requires -cgroup-parent (0 for no limit)")
+	cgroupCPUs     = flag.Float64("cgroup-cpus", 0, "CPUs each session may use, e.g. 0.5, requires -cgroup-parent (0 for no limit)")
+	cgroupPids     = flag.Int64("cgroup-pids", 0, "Processes each session may have, requires -cgroup-parent (0 for no limit)")
+	sandbox        = flag.Bool("sandbox", false, "Run each session in its own mount, PID and UTS namespaces (requires root unless -sandbox-userns)")
+	sandboxRoot    = flag.String("sandbox-root", "", "Directory sandboxed sessions see as their root directory (default: /)")
+	sandboxOverlay = flag.String("sandbox-overlay", "", "Directory to keep a copy-on-write overlay of the root per sandboxed session in, discarded when it ends")
+	sandboxNetwork = flag.Bool("sandbox-network", false, "Give each sandboxed session its own network namespace with only a loopback interface")
+	sandboxUserNS  = flag.Bool("sandbox-userns", false, "Create sandboxes in user namespaces, so that root is not needed")
+	sshAddr        = flag.String("ssh-addr", "", "Address to serve terminal sessions over SSH on, e.g. :2222 (disabled if empty)")
+	sshHostKey     = flag.String("ssh-host-key", "ssh_host_ed25519_key", "SSH host key file, generated if it does not exist")
+	sshAuthKeys    = flag.String("ssh-authorized-keys", "", "authorized_keys file of the keys SSH clients may log in with, besides tokens (reloaded on SIGHUP)")
+	persistDir     = flag.String("persist-dir", "", "Directory for the control sockets of the processes keeping sessions alive across server restarts (disabled if empty)")
 )
 
-// SecurityAuthProvider adapts our security package to the terminal.AuthProvider interface
-type SecurityAuthProvider struct {
-	authToken string
-}
+// SecurityAuthProvider adapts our security package to the terminal.PrincipalAuthProvider interface
+type SecurityAuthProvider struct{}
 
 // ValidataAuthToken implements the terminal.AuthProvider interface
 func (p *SecurityAuthProvider) ValidataAuthToken(token string) bool {
-	return token == p.authToken
+	_, ok := security.Authenticate(token)
+	return ok
+}
+
+// AuthenticatePrincipal implements the terminal.PrincipalAuthProvider interface
+func (p *SecurityAuthProvider) AuthenticatePrincipal(token string) (*terminal.Principal, bool) {
+	principal, ok := security.Authenticate(token)
+	if !ok {
+		return nil, false
+	}
+	return &terminal.Principal{Name: principal.Name, Role: string(principal.Role)}, true
+}
+
+// newTerminalOptions creates the options of the sessions served by this process
+func newTerminalOptions(profiles []terminal.Profile, targets []terminal.SSHTarget, docker *terminal.Docker, serialPorts []terminal.SerialPort, userMap map[string]string) *terminal.TerminalOptions {
+	// Create terminal options with our auth provider
+	opts := terminal.DefaultOptions()
+	opts.AuthProvider = &SecurityAuthProvider{}
+	opts.RecordingDir = *recordDir
+	opts.RecordInput = *recordInput
+	opts.HangupGrace = *hangupGrace
+	opts.TerminateGrace = *terminateGrace
+	opts.PersistDir = *persistDir
+	opts.Profiles = profiles
+	opts.Targets = targets
+	opts.Docker = docker
+	opts.SerialPorts = serialPorts
+	if userMap != nil {
+		opts.UserMapper = terminal.UserMap(userMap)
+	}
+	opts.Limits = terminal.ResourceLimits{
+		CPUSeconds:        *rlimitCPU,
+		OpenFiles:         *rlimitNoFile,
+		Processes:         *rlimitNProc,
+		DisableCoreDumps:  *noCoreDumps,
+		AddressSpaceBytes: *rlimitASMB * 1024 * 1024,
+	}
+	if *cgroupParent != "" {
+		opts.Cgroup = &terminal.CgroupLimits{
+			Parent:      *cgroupParent,
+			MemoryBytes: *cgroupMemoryMB * 1024 * 1024,
+			CPUs:        *cgroupCPUs,
+			Pids:        *cgroupPids,
+		}
+	}
+	if *sandbox {
+		opts.Sandbox = &terminal.Sandbox{
+			RootDir:       *sandboxRoot,
+			OverlayDir:    *sandboxOverlay,
+			Network:       *sandboxNetwork,
+			UserNamespace: *sandboxUserNS,
+		}
+	}
+	return opts
 }
 
 // TerminalHandler creates a handler for terminal WebSocket connections
-func TerminalHandler(authToken string) http.HandlerFunc {
+// backed by the given session manager
+func TerminalHandler(authToken string, manager *terminal.SessionManager, opts *terminal.TerminalOptions) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		// Create terminal options with our auth provider
-		opts := terminal.DefaultOptions()
-		opts.AuthProvider = &SecurityAuthProvider{authToken: authToken}
-
 		// Store the token in request context for compatibility with existing code
 		ctx := context.WithValue(r.Context(), "auth_token", authToken)
 		r = r.WithContext(ctx)
 
 		// Handle the WebSocket connection with our configured options
-		terminal.HandleWebSocketWithOptions(w, r, opts)
+		terminal.HandleWebSocketWithOptions(w, r, manager, opts)
 	}
 }
 
 func main() {
+	// Become a session's sandbox init or PTY holder if re-executed as one
+	terminal.Init()
+
+	// Attach this terminal to a session on a remote server instead of serving
+	if len(os.Args) > 1 && os.Args[1] == "connect" {
+		os.Exit(runConnect(os.Args[2:]))
+	}
+
 	flag.Parse()
 
 	// Handle version flag
@@ -73,7 +166,18 @@ func main() {
 
 	// Set security configuration
 	var authToken string
-	if *token == "" {
+	var tokenStore *security.TokenStore
+	if *tokenFile != "" {
+		if *token != "" {
+			log.Fatal("-token and -token-file cannot be used together")
+		}
+		store, err := security.LoadTokenStore(*tokenFile)
+		if err != nil {
+			log.Fatalf("Failed to load token file: %v", err)
+		}
+		tokenStore = store
+		fmt.Printf("Using named tokens from %s\n", *tokenFile)
+	} else if *token == "" {
 		// Generate a random token if not provided
 		randomToken, err := security.GenerateRandomToken()
 		if err != nil {
@@ -94,9 +198,83 @@ func main() {
 		fmt.Printf("Using provided authentication token: %s\n", authToken)
 	}
 
+	// Command profiles clients may choose from when starting a session
+	var profiles []terminal.Profile
+	if *profilesFile != "" {
+		loaded, err := terminal.LoadProfiles(*profilesFile)
+		if err != nil {
+			log.Fatalf("Failed to load profiles: %v", err)
+		}
+		profiles = loaded
+		fmt.Printf("Loaded %d command profiles from %s\n", len(profiles), *profilesFile)
+	}
+
+	// SSH hosts clients may open sessions on instead of a local shell
+	var targets []terminal.SSHTarget
+	if *targetsFile != "" {
+		loaded, err := terminal.LoadTargets(*targetsFile)
+		if err != nil {
+			log.Fatalf("Failed to load targets: %v", err)
+		}
+		targets = loaded
+		fmt.Printf("Loaded %d SSH targets from %s\n", len(targets), *targetsFile)
+	}
+
+	// Containers clients may open sessions in
+	var docker *terminal.Docker
+	if *dockerEnabled {
+		docker = &terminal.Docker{
+			Socket:  *dockerSocket,
+			Command: strings.Fields(*dockerCommand),
+			User:    *dockerUser,
+		}
+		for _, label := range strings.Split(*dockerLabels, ",") {
+			if label = strings.TrimSpace(label); label != "" {
+				docker.Labels = append(docker.Labels, label)
+			}
+		}
+		if len(docker.Labels) == 0 {
+			log.Printf("Warning: sessions may be opened in every running container, use -docker-labels to restrict them")
+		}
+	}
+
+	// Serial ports clients may attach sessions to
+	var serialPorts []terminal.SerialPort
+	if *serialFile != "" {
+		loaded, err := terminal.LoadSerialPorts(*serialFile)
+		if err != nil {
+			log.Fatalf("Failed to load serial ports: %v", err)
+		}
+		serialPorts = loaded
+		fmt.Printf("Loaded %d serial ports from %s\n", len(serialPorts), *serialFile)
+	}
+
+	// OS users the shells of each principal run as
+	var userMap map[string]string
+	if *userMapFile != "" {
+		if os.Geteuid() != 0 {
+			log.Printf("Warning: -user-map needs root or CAP_SETUID and CAP_SETGID to start shells as other users")
+		}
+		loaded, err := terminal.LoadUserMap(*userMapFile)
+		if err != nil {
+			log.Fatalf("Failed to load user map: %v", err)
+		}
+		userMap = loaded
+		fmt.Printf("Running shells as the OS users mapped in %s\n", *userMapFile)
+	}
+
+	// Session manager owning all terminal sessions served by this process
+	manager := terminal.NewSessionManager()
+	defer manager.Close()
+
 	security.SetConfig(security.Config{
 		InsecureMode: *insecure,
 		AuthToken:    authToken,
+		Tokens:       tokenStore,
+		ShareValidator: func(token string) bool {
+			_, ok := manager.LookupShare(token)
+			return ok
+		},
 	})
 
 	// If secure mode is enabled but no cert/key provided, generate them
@@ -224,6 +402,31 @@ func main() {
 	}
 	http.Handle("/", middleware.Chain(http.FileServer(http.FS(staticFS)), middlewareChain...))
 
+	// Recording playback endpoint used by player.html, only available when recording is enabled
+	if *recordDir != "" {
+		recordingsHandler := http.StripPrefix("/recordings", terminal.RecordingsHandler(*recordDir))
+		http.Handle("/recordings/", middleware.Chain(recordingsHandler, middlewareChain...))
+	}
+
+	// Session management API, authenticated with a Bearer token by AuthenticateMiddleware
+	apiHandler := http.StripPrefix("/api", terminal.APIHandler(manager))
+	http.Handle("/api/", middleware.Chain(apiHandler, middlewareChain...))
+
+	// Prometheus metrics, authenticated with a Bearer token like the API
+	http.Handle("/metrics", middleware.Chain(terminal.MetricsHandler(manager), middlewareChain...))
+
+	// Command profiles offered by the New Session button
+	http.Handle("/profiles", middleware.Chain(terminal.ProfilesHandler(profiles), middlewareChain...))
+
+	// SSH targets offered by the New Session button
+	http.Handle("/targets", middleware.Chain(terminal.TargetsHandler(targets), middlewareChain...))
+
+	// Containers offered by the New Session button
+	http.Handle("/containers", middleware.Chain(terminal.ContainersHandler(docker), middlewareChain...))
+
+	// Serial ports offered by the New Session button
+	http.Handle("/serial-ports", middleware.Chain(terminal.SerialPortsHandler(serialPorts), middlewareChain...))
+
 	// Terminal WebSocket handler with middleware for security
 	// The security middleware will handle authentication, but we also pass the token
 	// to our TerminalHandler which will create the appropriate auth provider
@@ -232,14 +435,104 @@ func main() {
 		middleware.ConvertToFuncMiddleware(security.CORSMiddleware),
 		middleware.ConvertToFuncMiddleware(security.AuthenticateMiddleware),
 	}
-	http.HandleFunc("/ws", middleware.ChainFunc(TerminalHandler(authToken), handlerMiddlewares...))
+	terminalOptions := newTerminalOptions(profiles, targets, docker, serialPorts, userMap)
+	http.HandleFunc("/ws", middleware.ChainFunc(TerminalHandler(authToken, manager, terminalOptions), handlerMiddlewares...))
+
+	// Take over the sessions left running by the previous instance of the server
+	if *persistDir != "" {
+		restored, err := manager.Restore(terminalOptions)
+		if err != nil {
+			log.Fatalf("Failed to restore sessions from %s: %v", *persistDir, err)
+		}
+		fmt.Printf("Restored %d sessions from %s\n", len(restored), *persistDir)
+	}
 
 	// Start the server
 	fmt.Printf("Starting remote terminal server on %s\n", *addr)
 
+	server := &http.Server{Addr: *addr}
+
+	// Serve the same sessions to native SSH clients
+	var sshServer *terminal.SSHServer
+	var authorizedKeys *terminal.AuthorizedKeys
+	if *sshAddr != "" {
+		hostKey, err := terminal.LoadHostKey(*sshHostKey)
+		if err != nil {
+			log.Fatalf("Failed to load SSH host key: %v", err)
+		}
+		sshServer = terminal.NewSSHServer(manager, terminalOptions, hostKey)
+		if *sshAuthKeys != "" {
+			authorizedKeys, err = terminal.LoadAuthorizedKeys(*sshAuthKeys)
+			if err != nil {
+				log.Fatalf("Failed to load SSH authorized keys: %v", err)
+			}
+			sshServer.AuthorizedKeys = authorizedKeys
+		}
+
+		listener, err := net.Listen("tcp", *sshAddr)
+		if err != nil {
+			log.Fatalf("Failed to listen for SSH connections: %v", err)
+		}
+		fmt.Printf("Serving terminal sessions over SSH on %s\n", *sshAddr)
+		go func() {
+			if err := sshServer.Serve(listener); err != nil && err != terminal.ErrSSHServerClosed {
+				log.Printf("SSH server error: %v", err)
+			}
+		}()
+	}
+
+	// Reload the token and authorized keys files on SIGHUP so tokens and keys can be
+	// added and revoked without a restart
+	if tokenStore != nil || authorizedKeys != nil {
+		go func() {
+			reload := make(chan os.Signal, 1)
+			signal.Notify(reload, syscall.SIGHUP)
+			for range reload {
+				if tokenStore != nil {
+					log.Printf("Received SIGHUP, reloading %s", *tokenFile)
+					if err := tokenStore.Reload(); err != nil {
+						log.Printf("Failed to reload token file, keeping previous tokens: %v", err)
+					}
+				}
+				if authorizedKeys != nil {
+					log.Printf("Received SIGHUP, reloading %s", *sshAuthKeys)
+					if err := authorizedKeys.Reload(); err != nil {
+						log.Printf("Failed to reload SSH authorized keys, keeping previous keys: %v", err)
+					}
+				}
+			}
+		}()
+	}
+
+	// Shut down gracefully on SIGINT/SIGTERM: stop accepting connections,
+	// notify attached clients, hang up the shells (or hand persistent sessions over to
+	// their holders) and wait for them to finish
+	shutdownComplete := make(chan struct{})
+	go func() {
+		defer close(shutdownComplete)
+
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+		sig := <-signals
+		log.Printf("Received %v, shutting down (grace period %v)", sig, *shutdownGrace)
+
+		ctx, cancel := context.WithTimeout(context.Background(), *shutdownGrace)
+		defer cancel()
+
+		if err := server.Shutdown(ctx); err != nil {
+			log.Printf("Error shutting down HTTP server: %v", err)
+		}
+		if err := manager.Shutdown(ctx); err != nil {
+			log.Printf("Error shutting down terminal sessions: %v", err)
+		}
+		if sshServer != nil {
+			sshServer.Close()
+		}
+	}()
+
 	if *certFile != "" && *keyFile != "" {
 		fmt.Println("Using HTTPS")
-		err = http.ListenAndServeTLS(*addr, *certFile, *keyFile, nil)
+		err = server.ListenAndServeTLS(*certFile, *keyFile)
 	} else {
 		if *secure {
 			// This shouldn't be reached due to the earlier handling
@@ -248,13 +541,18 @@ func main() {
 
 		// Ensure localhost binding if needed
 		*addr = security.EnsureLocalhostBinding(*addr)
+		server.Addr = *addr
 
 		fmt.Println("WARNING: Using HTTP (insecure)")
-		err = http.ListenAndServe(*addr, nil)
+		err = server.ListenAndServe()
 	}
 
 	if err != nil && err != http.ErrServerClosed {
 		fmt.Fprintf(os.Stderr, "Server error: %v\n", err)
 		os.Exit(1)
 	}
+
+	// ListenAndServe returns as soon as shutdown begins, so wait for it to finish
+	<-shutdownComplete
+	log.Println("Server stopped")
 }
@@ -0,0 +1,371 @@
+// Package client connects to go-remote-term servers over their WebSocket protocol,
+// so that terminal sessions can be used from Go programs and other terminals as
+// well as from browsers.
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/dansun78/go-remote-term/pkg/terminal"
+	"github.com/gorilla/websocket"
+)
+
+// DefaultKeepAlive is how often clients ping the server when Options.KeepAlive is not set
+const DefaultKeepAlive = 30 * time.Second
+
+// authTimeout limits how long Dial waits for the server to answer the auth message
+const authTimeout = 30 * time.Second
+
+// Options configures how a Client authenticates and which session it attaches to
+type Options struct {
+	// Token is the authentication token of the server
+	Token string
+
+	// Share is a share link token, used instead of Token to join a shared session as a guest
+	Share string
+
+	// SessionID is the session to attach to (default: empty, start a new session)
+	SessionID string
+
+	// Profile is the command profile new sessions are started with (default: the server's shell)
+	Profile string
+
+	// Target is the SSH target new sessions are opened on instead of the server itself
+	Target string
+
+	// Container is the container new sessions are opened in instead of the server itself
+	Container string
+
+	// SerialPort is the serial port new sessions are attached to, or whose session is joined
+	SerialPort string
+
+	// Header holds extra HTTP headers sent with the WebSocket handshake, e.g. Origin
+	Header http.Header
+
+	// Dialer opens the WebSocket connection, e.g. with a custom TLS configuration
+	// (default: websocket.DefaultDialer)
+	Dialer *websocket.Dialer
+
+	// KeepAlive is how often the server is pinged. A connection that stays silent
+	// for twice as long is considered dropped. (default: DefaultKeepAlive)
+	KeepAlive time.Duration
+}
+
+// Event is something received from the server: terminal output or a control message
+type Event struct {
+	Output  []byte             // Terminal output, nil for control messages
+	Control *terminal.Response // Control message, nil for output
+}
+
+// AuthError is returned by Dial and Reconnect when the server refuses the client
+type AuthError struct {
+	Message string
+}
+
+func (e *AuthError) Error() string {
+	return fmt.Sprintf("authentication failed: %s", e.Message)
+}
+
+// SessionEndedError is returned once the session the client is attached to has ended
+type SessionEndedError struct {
+	SessionID string
+	End       terminal.SessionEnd
+}
+
+func (e *SessionEndedError) Error() string {
+	return fmt.Sprintf("session %s ended: %s", e.SessionID, e.End.Describe())
+}
+
+// Client is a connection to a terminal session on a go-remote-term server, speaking
+// protocol v2. Next and Reconnect must be called from one goroutine, while Write,
+// Resize and Terminate may be called from any goroutine.
+type Client struct {
+	url     string
+	options Options
+
+	mu        sync.Mutex // Guards the fields below and serializes writes
+	conn      *websocket.Conn
+	done      chan struct{} // Closed when conn is closed, stops its keepalive
+	sessionID string
+	role      terminal.ParticipantRole
+}
+
+// Dial connects to the terminal WebSocket endpoint at url (e.g. ws://localhost:8080/ws)
+// and authenticates, attaching to options.SessionID or starting a new session
+func Dial(ctx context.Context, url string, options Options) (*Client, error) {
+	c := &Client{url: url, options: options, sessionID: options.SessionID}
+	if err := c.connect(ctx); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// SessionID returns the ID of the session the client is attached to
+func (c *Client) SessionID() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.sessionID
+}
+
+// Role returns the role the server granted the client in the session
+func (c *Client) Role() terminal.ParticipantRole {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.role
+}
+
+// Reconnect replaces the connection with a new one attached to the same session,
+// e.g. after Next failed because the network dropped. The server replays the
+// session's scrollback on the new connection. If the session no longer exists on
+// the server, a new one is started and SessionID changes.
+func (c *Client) Reconnect(ctx context.Context) error {
+	c.closeConn()
+	return c.connect(ctx)
+}
+
+// connect dials the server and authenticates a new connection
+func (c *Client) connect(ctx context.Context) error {
+	dialer := *websocket.DefaultDialer
+	if c.options.Dialer != nil {
+		dialer = *c.options.Dialer
+	}
+	dialer.Subprotocols = []string{terminal.Subprotocol}
+
+	conn, resp, err := dialer.DialContext(ctx, c.url, c.options.Header)
+	if err != nil {
+		if resp != nil {
+			return fmt.Errorf("failed to connect: %v (%s)", err, resp.Status)
+		}
+		return fmt.Errorf("failed to connect: %v", err)
+	}
+
+	c.mu.Lock()
+	sessionID := c.sessionID
+	c.mu.Unlock()
+
+	auth := terminal.Message{
+		Type:       "auth",
+		Token:      c.options.Token,
+		Share:      c.options.Share,
+		SessionID:  sessionID,
+		Profile:    c.options.Profile,
+		Target:     c.options.Target,
+		Container:  c.options.Container,
+		SerialPort: c.options.SerialPort,
+		Protocol:   terminal.ProtocolV2,
+	}
+	reply, err := authenticate(ctx, conn, auth)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+
+	switch {
+	case reply.Type == "session_ended" && reply.End != nil:
+		conn.Close()
+		return &SessionEndedError{SessionID: reply.SessionID, End: *reply.End}
+	case reply.Type != "auth_response":
+		conn.Close()
+		return fmt.Errorf("unexpected reply to auth message: %s", reply.Type)
+	case !reply.Success:
+		conn.Close()
+		return &AuthError{Message: reply.Message}
+	}
+
+	done := make(chan struct{})
+	c.mu.Lock()
+	c.conn = conn
+	c.done = done
+	c.sessionID = reply.SessionID
+	c.role = reply.Role
+	c.mu.Unlock()
+
+	c.keepAlive(conn, done)
+	return nil
+}
+
+// authenticate sends the auth message on a new connection and reads the server's reply
+func authenticate(ctx context.Context, conn *websocket.Conn, auth terminal.Message) (*terminal.Response, error) {
+	deadline := time.Now().Add(authTimeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	conn.SetReadDeadline(deadline)
+	conn.SetWriteDeadline(deadline)
+	defer conn.SetWriteDeadline(time.Time{})
+
+	payload, _ := json.Marshal(auth)
+	if err := conn.WriteMessage(websocket.BinaryMessage, terminal.EncodeFrame(terminal.FrameControl, payload)); err != nil {
+		return nil, fmt.Errorf("failed to send auth message: %v", err)
+	}
+
+	messageType, frame, err := conn.ReadMessage()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read auth response: %v", err)
+	}
+	if messageType != websocket.BinaryMessage {
+		return nil, errors.New("server does not support protocol v2")
+	}
+	opcode, payload, err := terminal.DecodeFrame(frame)
+	if err != nil || opcode != terminal.FrameControl {
+		return nil, errors.New("server did not answer the auth message with a control frame")
+	}
+
+	var reply terminal.Response
+	if err := json.Unmarshal(payload, &reply); err != nil {
+		return nil, fmt.Errorf("malformed auth response: %v", err)
+	}
+	return &reply, nil
+}
+
+// keepAlive pings the server until the connection is closed, and makes reads
+// fail once the server has not been heard from for two intervals
+func (c *Client) keepAlive(conn *websocket.Conn, done chan struct{}) {
+	interval := c.options.KeepAlive
+	if interval <= 0 {
+		interval = DefaultKeepAlive
+	}
+
+	extend := func() {
+		conn.SetReadDeadline(time.Now().Add(2 * interval))
+	}
+	extend()
+	conn.SetPongHandler(func(string) error {
+		extend()
+		return nil
+	})
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(interval)); err != nil {
+					return
+				}
+			}
+		}
+	}()
+}
+
+// Next returns the next output or control message from the server. It returns a
+// *SessionEndedError once the session has ended, and the connection's error when
+// it is lost, after which Reconnect can attach to the session again.
+func (c *Client) Next() (Event, error) {
+	c.mu.Lock()
+	conn := c.conn
+	c.mu.Unlock()
+
+	for {
+		messageType, frame, err := conn.ReadMessage()
+		if err != nil {
+			return Event{}, err
+		}
+		// Any message proves the connection alive, not only pongs
+		conn.SetReadDeadline(time.Now().Add(2 * c.keepAliveInterval()))
+
+		// Frames the client does not understand are skipped, like the server does
+		if messageType != websocket.BinaryMessage {
+			continue
+		}
+		opcode, payload, err := terminal.DecodeFrame(frame)
+		if err != nil {
+			continue
+		}
+		if opcode == terminal.FrameData {
+			return Event{Output: payload}, nil
+		}
+
+		var resp terminal.Response
+		if err := json.Unmarshal(payload, &resp); err != nil {
+			continue
+		}
+		if resp.Type == "session_ended" && resp.End != nil {
+			return Event{}, &SessionEndedError{SessionID: resp.SessionID, End: *resp.End}
+		}
+		return Event{Control: &resp}, nil
+	}
+}
+
+// keepAliveInterval returns the configured keepalive interval or its default
+func (c *Client) keepAliveInterval() time.Duration {
+	if c.options.KeepAlive > 0 {
+		return c.options.KeepAlive
+	}
+	return DefaultKeepAlive
+}
+
+// Write sends terminal input to the session
+func (c *Client) Write(p []byte) (int, error) {
+	if err := c.writeFrame(terminal.FrameData, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Resize changes the size of the session's terminal
+func (c *Client) Resize(rows, cols uint16) error {
+	return c.writeControl(terminal.Message{Type: "resize", Rows: rows, Cols: cols})
+}
+
+// Terminate asks the server to end the session. Only the session's owner may do
+// so; the outcome arrives as a terminate_response control message, followed by
+// a *SessionEndedError from Next.
+func (c *Client) Terminate() error {
+	return c.writeControl(terminal.Message{Type: "terminate", SessionID: c.SessionID()})
+}
+
+// Break sends a break to the session's terminal, e.g. to stop the boot loader of a
+// board on a serial port. The outcome arrives as a break_response control message.
+func (c *Client) Break() error {
+	return c.writeControl(terminal.Message{Type: "break"})
+}
+
+// writeControl sends a control message to the server
+func (c *Client) writeControl(msg terminal.Message) error {
+	payload, _ := json.Marshal(msg)
+	return c.writeFrame(terminal.FrameControl, payload)
+}
+
+// writeFrame sends a protocol v2 frame on the current connection
+func (c *Client) writeFrame(opcode byte, payload []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.conn.WriteMessage(websocket.BinaryMessage, terminal.EncodeFrame(opcode, payload))
+}
+
+// Close closes the connection. The session keeps running on the server until it
+// times out, so it can be attached to again later.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	c.conn.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+	c.mu.Unlock()
+
+	return c.closeConn()
+}
+
+// closeConn closes the current connection and stops its keepalive
+func (c *Client) closeConn() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	select {
+	case <-c.done:
+		return nil
+	default:
+	}
+	close(c.done)
+	return c.conn.Close()
+}
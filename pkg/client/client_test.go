@@ -0,0 +1,146 @@
+package client_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dansun78/go-remote-term/pkg/client"
+	"github.com/dansun78/go-remote-term/pkg/terminal"
+)
+
+// newServer starts a terminal WebSocket server accepting the token "secret"
+func newServer(t *testing.T) (*terminal.SessionManager, string) {
+	t.Helper()
+
+	manager := terminal.NewSessionManager()
+	t.Cleanup(manager.Close)
+
+	opts := terminal.DefaultOptions()
+	opts.Shell = "/bin/sh"
+	terminal.SetAuthToken(opts, "secret")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		terminal.HandleWebSocketWithOptions(w, r, manager, opts)
+	}))
+	t.Cleanup(server.Close)
+
+	return manager, "ws" + strings.TrimPrefix(server.URL, "http")
+}
+
+// readOutputUntil reads events from c until the output received contains want
+func readOutputUntil(t *testing.T, c *client.Client, want string) {
+	t.Helper()
+
+	var output bytes.Buffer
+	for !strings.Contains(output.String(), want) {
+		event, err := c.Next()
+		if err != nil {
+			t.Fatalf("Failed to read %q, got %q: %v", want, output.String(), err)
+		}
+		output.Write(event.Output)
+	}
+}
+
+func TestClientSession(t *testing.T) {
+	t.Parallel()
+
+	manager, url := newServer(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	c, err := client.Dial(ctx, url, client.Options{Token: "secret"})
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer c.Close()
+	if c.SessionID() == "" || c.Role() != terminal.RoleOwner {
+		t.Fatalf("Unexpected session %q and role %q", c.SessionID(), c.Role())
+	}
+
+	if err := c.Resize(30, 100); err != nil {
+		t.Fatalf("Failed to resize: %v", err)
+	}
+	if _, err := c.Write([]byte("stty size\n")); err != nil {
+		t.Fatalf("Failed to write: %v", err)
+	}
+	readOutputUntil(t, c, "30 100")
+
+	if err := c.Terminate(); err != nil {
+		t.Fatalf("Failed to terminate: %v", err)
+	}
+	for {
+		event, err := c.Next()
+		var ended *client.SessionEndedError
+		if errors.As(err, &ended) {
+			if ended.SessionID != c.SessionID() || ended.End.Reason != terminal.EndReasonTerminated {
+				t.Fatalf("Unexpected session end: %+v", ended)
+			}
+			break
+		}
+		if err != nil {
+			t.Fatalf("Connection failed before the session ended: %v", err)
+		}
+		if event.Control != nil && event.Control.Type == "terminate_response" && !event.Control.Success {
+			t.Fatalf("Terminate was refused: %+v", event.Control)
+		}
+	}
+	if _, ok := manager.Get(c.SessionID()); ok {
+		t.Fatal("Session still exists after terminate")
+	}
+
+	// Attaching to the ended session reports how it ended
+	_, err = client.Dial(ctx, url, client.Options{Token: "secret", SessionID: c.SessionID()})
+	var ended *client.SessionEndedError
+	if !errors.As(err, &ended) {
+		t.Fatalf("Expected a SessionEndedError attaching to the ended session, got %v", err)
+	}
+}
+
+func TestClientReconnect(t *testing.T) {
+	t.Parallel()
+
+	_, url := newServer(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	c, err := client.Dial(ctx, url, client.Options{Token: "secret"})
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer c.Close()
+	sessionID := c.SessionID()
+
+	c.Write([]byte("echo before-$((6*7))\n"))
+	readOutputUntil(t, c, "before-42")
+
+	if err := c.Reconnect(ctx); err != nil {
+		t.Fatalf("Failed to reconnect: %v", err)
+	}
+	if c.SessionID() != sessionID {
+		t.Fatalf("Reconnected to session %s instead of %s", c.SessionID(), sessionID)
+	}
+
+	// The scrollback is replayed and the shell is still the same one
+	readOutputUntil(t, c, "before-42")
+	c.Write([]byte("echo after-$((6*7))\n"))
+	readOutputUntil(t, c, "after-42")
+}
+
+func TestClientAuthError(t *testing.T) {
+	t.Parallel()
+
+	_, url := newServer(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := client.Dial(ctx, url, client.Options{Token: "wrong"})
+	var authErr *client.AuthError
+	if !errors.As(err, &authErr) {
+		t.Fatalf("Expected an AuthError, got %v", err)
+	}
+}
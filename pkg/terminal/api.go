@@ -0,0 +1,229 @@
+package terminal
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dansun78/go-remote-term/internal/security"
+)
+
+// apiError is the JSON body returned for failed API requests
+type apiError struct {
+	Error string `json:"error"`
+}
+
+// resizeRequest is the JSON body accepted by the resize endpoint
+type resizeRequest struct {
+	Rows uint16 `json:"rows"`
+	Cols uint16 `json:"cols"`
+}
+
+// shareRequest is the JSON body accepted by the share endpoint
+type shareRequest struct {
+	Role ParticipantRole `json:"role"`
+	TTL  string          `json:"ttl,omitempty"` // Go duration, e.g. "1h"; empty for no expiry
+}
+
+// APIHandler serves a JSON management API for the sessions owned by manager.
+// Paths are relative to where the handler is mounted:
+//
+//	GET    /sessions                     list sessions
+//	GET    /sessions/{id}                fetch one session, or how a recently ended one ended
+//	DELETE /sessions/{id}                terminate a session
+//	POST   /sessions/{id}/resize         resize a session ({"rows": 24, "cols": 80})
+//	GET    /sessions/{id}/output         read the session's buffered output
+//	GET    /sessions/{id}/shares         list the session's share links
+//	POST   /sessions/{id}/shares         create a share link ({"role": "read-only", "ttl": "1h"})
+//	DELETE /sessions/{id}/shares/{token} revoke a share link
+//
+// The handler performs no authentication of its own; mount it with
+// http.StripPrefix behind the application's authentication middleware.
+func APIHandler(manager *SessionManager) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+		if parts[0] != "sessions" {
+			writeAPIError(w, http.StatusNotFound, "Not found")
+			return
+		}
+
+		switch len(parts) {
+		case 1:
+			handleListSessions(w, r, manager)
+		case 2:
+			handleSession(w, r, manager, parts[1])
+		case 3:
+			handleSessionAction(w, r, manager, parts[1], parts[2])
+		case 4:
+			if parts[2] != "shares" {
+				writeAPIError(w, http.StatusNotFound, "Not found")
+				return
+			}
+			handleShare(w, r, manager, parts[1], parts[3])
+		default:
+			writeAPIError(w, http.StatusNotFound, "Not found")
+		}
+	})
+}
+
+// handleListSessions returns information about every session
+func handleListSessions(w http.ResponseWriter, r *http.Request, manager *SessionManager) {
+	if r.Method != http.MethodGet {
+		writeAPIError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	sessions := manager.List()
+	infos := make([]SessionInfo, 0, len(sessions))
+	for _, session := range sessions {
+		infos = append(infos, session.Info())
+	}
+	writeJSON(w, http.StatusOK, infos)
+}
+
+// handleSession fetches or terminates a single session
+func handleSession(w http.ResponseWriter, r *http.Request, manager *SessionManager, sessionID string) {
+	session, exists := manager.Get(sessionID)
+	if !exists {
+		// Recently ended sessions can still be fetched for their exit status
+		if info, ended := manager.EndedInfo(sessionID); ended && r.Method == http.MethodGet {
+			writeJSON(w, http.StatusOK, info)
+			return
+		}
+		writeAPIError(w, http.StatusNotFound, "Session not found")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, session.Info())
+	case http.MethodDelete:
+		// The session ended on behalf of the caller, if the request was authenticated
+		by := "api"
+		if principal, ok := security.PrincipalFromContext(r.Context()); ok {
+			by = principal.Name
+		}
+		log.Printf("Terminating session %s via API on behalf of %s", sessionID, by)
+		if !manager.TerminateBy(sessionID, by) {
+			writeAPIError(w, http.StatusNotFound, "Session not found")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeAPIError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// handleSessionAction handles the sub-resources of a session
+func handleSessionAction(w http.ResponseWriter, r *http.Request, manager *SessionManager, sessionID, action string) {
+	session, exists := manager.Get(sessionID)
+	if !exists {
+		writeAPIError(w, http.StatusNotFound, "Session not found")
+		return
+	}
+
+	switch action {
+	case "resize":
+		if r.Method != http.MethodPost {
+			writeAPIError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+
+		var req resizeRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeAPIError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+		if req.Rows == 0 || req.Cols == 0 {
+			writeAPIError(w, http.StatusBadRequest, "Rows and cols must be greater than zero")
+			return
+		}
+
+		if err := session.Resize(req.Rows, req.Cols); err != nil {
+			log.Printf("Failed to resize session %s: %v", sessionID, err)
+			writeAPIError(w, http.StatusInternalServerError, "Failed to resize terminal")
+			return
+		}
+		writeJSON(w, http.StatusOK, session.Info())
+
+	case "output":
+		if r.Method != http.MethodGet {
+			writeAPIError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write(session.BufferedOutput())
+
+	case "shares":
+		switch r.Method {
+		case http.MethodGet:
+			writeJSON(w, http.StatusOK, manager.Shares(sessionID))
+		case http.MethodPost:
+			var req shareRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				writeAPIError(w, http.StatusBadRequest, "Invalid request body")
+				return
+			}
+
+			var ttl time.Duration
+			if req.TTL != "" {
+				parsed, err := time.ParseDuration(req.TTL)
+				if err != nil || parsed < 0 {
+					writeAPIError(w, http.StatusBadRequest, "Invalid ttl")
+					return
+				}
+				ttl = parsed
+			}
+
+			share, err := manager.CreateShare(sessionID, req.Role, ttl)
+			switch {
+			case errors.Is(err, ErrInvalidShareRole):
+				writeAPIError(w, http.StatusBadRequest, err.Error())
+			case errors.Is(err, ErrSessionNotFound):
+				writeAPIError(w, http.StatusNotFound, "Session not found")
+			case err != nil:
+				log.Printf("Failed to share session %s: %v", sessionID, err)
+				writeAPIError(w, http.StatusInternalServerError, "Failed to create share")
+			default:
+				writeJSON(w, http.StatusCreated, share)
+			}
+		default:
+			writeAPIError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		}
+
+	default:
+		writeAPIError(w, http.StatusNotFound, "Not found")
+	}
+}
+
+// handleShare revokes a share link of a session
+func handleShare(w http.ResponseWriter, r *http.Request, manager *SessionManager, sessionID, token string) {
+	if r.Method != http.MethodDelete {
+		writeAPIError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	share, exists := manager.LookupShare(token)
+	if !exists || share.SessionID != sessionID || !manager.RevokeShare(token) {
+		writeAPIError(w, http.StatusNotFound, "Share not found")
+		return
+	}
+	log.Printf("Revoked %s share for session %s via API", share.Role, sessionID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeJSON writes v as a JSON response with the given status code
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeAPIError writes a JSON error response
+func writeAPIError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, apiError{Error: message})
+}
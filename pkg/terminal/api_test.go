@@ -0,0 +1,124 @@
+package terminal_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/dansun78/go-remote-term/internal/security"
+	"github.com/dansun78/go-remote-term/pkg/terminal"
+)
+
+func TestAPISessionLifecycle(t *testing.T) {
+	t.Parallel()
+
+	manager := terminal.NewSessionManager()
+	defer manager.Close()
+
+	session, err := manager.New(testOptions())
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+
+	server := httptest.NewServer(terminal.APIHandler(manager))
+	defer server.Close()
+
+	// List sessions
+	resp, err := http.Get(server.URL + "/sessions")
+	if err != nil {
+		t.Fatalf("List request failed: %v", err)
+	}
+	var infos []terminal.SessionInfo
+	json.NewDecoder(resp.Body).Decode(&infos)
+	resp.Body.Close()
+	if len(infos) != 1 || infos[0].ID != session.ID || infos[0].PID == 0 {
+		t.Fatalf("Unexpected session list: %+v", infos)
+	}
+
+	// Resize the session
+	resp, err = http.Post(server.URL+"/sessions/"+session.ID+"/resize", "application/json",
+		strings.NewReader(`{"rows": 40, "cols": 120}`))
+	if err != nil {
+		t.Fatalf("Resize request failed: %v", err)
+	}
+	var info terminal.SessionInfo
+	json.NewDecoder(resp.Body).Decode(&info)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || info.Rows != 40 || info.Cols != 120 {
+		t.Errorf("Unexpected resize result: status %d, %+v", resp.StatusCode, info)
+	}
+
+	// Terminate the session
+	req, _ := http.NewRequest(http.MethodDelete, server.URL+"/sessions/"+session.ID, nil)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Terminate request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Errorf("Expected status %d, got %d", http.StatusNoContent, resp.StatusCode)
+	}
+
+	// The session should now be gone from the list
+	resp, err = http.Get(server.URL + "/sessions")
+	if err != nil {
+		t.Fatalf("List request failed: %v", err)
+	}
+	infos = nil
+	json.NewDecoder(resp.Body).Decode(&infos)
+	resp.Body.Close()
+	if len(infos) != 0 {
+		t.Errorf("Expected no sessions after termination, got %+v", infos)
+	}
+
+	// but can still be fetched for its exit status for a while
+	resp, err = http.Get(server.URL + "/sessions/" + session.ID)
+	if err != nil {
+		t.Fatalf("Get request failed: %v", err)
+	}
+	info = terminal.SessionInfo{}
+	json.NewDecoder(resp.Body).Decode(&info)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || info.End == nil {
+		t.Fatalf("Expected the ended session, got status %d, %+v", resp.StatusCode, info)
+	}
+	if info.End.Reason != terminal.EndReasonTerminated || info.End.TerminatedBy != "api" || info.End.Usage == nil {
+		t.Errorf("Unexpected session end: %+v", *info.End)
+	}
+
+	// Unknown sessions are not found
+	resp, err = http.Get(server.URL + "/sessions/unknown")
+	if err != nil {
+		t.Fatalf("Get request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected status %d, got %d", http.StatusNotFound, resp.StatusCode)
+	}
+}
+
+func TestAPITerminateRecordsPrincipal(t *testing.T) {
+	t.Parallel()
+
+	manager := terminal.NewSessionManager()
+	defer manager.Close()
+	session, err := manager.New(testOptions())
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+
+	// The session is terminated by whoever authenticated the request
+	req := httptest.NewRequest(http.MethodDelete, "/sessions/"+session.ID, nil)
+	req = req.WithContext(context.WithValue(req.Context(), security.PrincipalContextKey, security.Principal{Name: "alice", Role: security.RoleAdmin}))
+	recorder := httptest.NewRecorder()
+	terminal.APIHandler(manager).ServeHTTP(recorder, req)
+	if recorder.Code != http.StatusNoContent {
+		t.Fatalf("Expected status %d, got %d", http.StatusNoContent, recorder.Code)
+	}
+	if end := session.End(); end == nil || end.TerminatedBy != "alice" {
+		t.Fatalf("Expected the session to be terminated by alice, got %+v", end)
+	}
+}
@@ -32,63 +32,134 @@ func SetAuthToken(options *TerminalOptions, token string) {
 	}
 }
 
+// Reasons recorded in the auth failure metric
+const (
+	authFailureReadError    = "read_error"
+	authFailureInvalidJSON  = "invalid_format"
+	authFailureWrongType    = "invalid_message_type"
+	authFailureMissingToken = "missing_token"
+	authFailureInvalidToken = "invalid_token"
+	authFailureInvalidShare = "invalid_share"
+)
+
+// clientAuth describes how a client authenticated
+type clientAuth struct {
+	Principal *Principal // Who the token belongs to, nil if the provider does not identify principals
+	Share     *Share     // Share link the client joined through, nil for token authentication
+}
+
 // validateClientAuth validates a client's authentication message
-// Returns whether authentication was successful and any error message
-func validateClientAuth(conn *websocket.Conn, options *TerminalOptions) (bool, string, *Message) {
+// Returns whether authentication was successful and any error message, plus
+// who the client is or the share it joined through
+// Failures are counted in the manager's metrics by reason
+// The client's protocol is switched to v2 if the auth message asks for it
+func validateClientAuth(client *clientConn, options *TerminalOptions, manager *SessionManager) (bool, string, *Message, *clientAuth) {
+	metrics := manager.metrics
+
 	// Wait for authentication message
-	_, rawMessage, err := conn.ReadMessage()
+	messageType, rawMessage, err := client.conn.ReadMessage()
 	if err != nil {
 		log.Println("Failed to read authentication message:", err)
-		return false, "Failed to read authentication message", nil
+		metrics.authFailure(authFailureReadError)
+		return false, "Failed to read authentication message", nil, nil
+	}
+
+	// Protocol v2 clients may send the auth message as a control frame
+	if messageType == websocket.BinaryMessage {
+		opcode, payload, err := DecodeFrame(rawMessage)
+		if err != nil || opcode != FrameControl {
+			log.Println("Authentication message is not a control frame")
+			metrics.authFailure(authFailureInvalidJSON)
+			return false, "Invalid authentication format", nil, nil
+		}
+		client.protocol = ProtocolV2
+		rawMessage = payload
 	}
 
 	// Parse the authentication message
 	var msg Message
 	if err := json.Unmarshal(rawMessage, &msg); err != nil {
 		log.Println("Failed to parse authentication message:", err)
-		return false, "Invalid authentication format", nil
+		metrics.authFailure(authFailureInvalidJSON)
+		return false, "Invalid authentication format", nil, nil
+	}
+
+	// Negotiate the protocol version requested in the auth message
+	switch msg.Protocol {
+	case "", ProtocolLegacy:
+	case ProtocolV2:
+		client.protocol = ProtocolV2
+	default:
+		log.Println("Unsupported protocol version:", msg.Protocol)
+		metrics.authFailure(authFailureInvalidJSON)
+		return false, "Unsupported protocol version", nil, nil
 	}
 
 	// Handle authentication
 	if msg.Type != "auth" {
 		log.Println("Expected auth message type but got:", msg.Type)
-		return false, "Invalid message type", nil
+		metrics.authFailure(authFailureWrongType)
+		return false, "Invalid message type", nil, nil
+	}
+
+	// Guests holding a share link authenticate with the share token instead
+	if msg.Token == "" && msg.Share != "" {
+		share, ok := manager.LookupShare(msg.Share)
+		if !ok {
+			log.Println("Authentication failed: Invalid or expired share link")
+			metrics.authFailure(authFailureInvalidShare)
+			return false, "Invalid or expired share link", nil, nil
+		}
+		return true, "", &msg, &clientAuth{Share: share}
 	}
 
 	// Check token validity - client-provided token must not be empty
 	if msg.Token == "" {
 		log.Println("Authentication failed: Missing token")
-		return false, "Missing authentication token", nil
+		metrics.authFailure(authFailureMissingToken)
+		return false, "Missing authentication token", nil, nil
+	}
+
+	// Providers that know who tokens belong to identify the principal as well
+	if provider, ok := options.AuthProvider.(PrincipalAuthProvider); ok {
+		principal, valid := provider.AuthenticatePrincipal(msg.Token)
+		if !valid {
+			log.Println("Authentication failed: Invalid token")
+			metrics.authFailure(authFailureInvalidToken)
+			return false, "Invalid authentication token", nil, nil
+		}
+		return true, "", &msg, &clientAuth{Principal: principal}
 	}
 
 	// Validate token using the AuthProvider interface
 	if options.AuthProvider != nil && !options.AuthProvider.ValidataAuthToken(msg.Token) {
 		log.Println("Authentication failed: Invalid token")
-		return false, "Invalid authentication token", nil
+		metrics.authFailure(authFailureInvalidToken)
+		return false, "Invalid authentication token", nil, nil
 	}
 
 	// Authentication successful
-	return true, "", &msg
+	return true, "", &msg, &clientAuth{}
 }
 
 // sendErrorResponse sends an error response to the client
-func sendErrorResponse(conn *websocket.Conn, message string) {
+func sendErrorResponse(client *clientConn, message string) {
 	resp := Response{
 		Type:    "auth_response",
 		Success: false,
 		Message: message,
 	}
-	respBytes, _ := json.Marshal(resp)
-	conn.WriteMessage(websocket.TextMessage, respBytes)
+	client.writeControl(resp)
 }
 
-// sendAuthSuccess sends a successful authentication response
-func sendAuthSuccess(conn *websocket.Conn, sessionID string) error {
+// sendAuthSuccess sends a successful authentication response with the role granted to the client
+func sendAuthSuccess(client *clientConn, sessionID string, role ParticipantRole) error {
 	authResp := Response{
 		Type:      "auth_response",
 		Success:   true,
 		SessionID: sessionID,
+		Role:      role,
+		Protocol:  client.protocol,
 	}
-	respBytes, _ := json.Marshal(authResp)
-	return conn.WriteMessage(websocket.TextMessage, respBytes)
+	return client.writeControl(authResp)
 }
@@ -0,0 +1,81 @@
+package terminal
+
+import (
+	"errors"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ErrSignalUnsupported is returned by backends that cannot deliver a signal
+var ErrSignalUnsupported = errors.New("signal not supported by backend")
+
+// ErrBreakUnsupported is returned when a break is sent to a session whose backend cannot send one
+var ErrBreakUnsupported = errors.New("break not supported by backend")
+
+// breakLength is how long a break condition lasts, as tcsendbreak does on Linux
+const breakLength = 250 * time.Millisecond
+
+// Backend is what the terminal of a session is attached to. By default sessions run the
+// shell or a profile's command on a local PTY; other backends attach sessions to SSH
+// targets, containers, serial ports or test doubles, see SessionManager.NewWithBackend.
+//
+// The session reads output from a single goroutine until Read fails, which ends the
+// session, and writes input from any goroutine, one Write at a time.
+type Backend interface {
+	// Start starts the program on a terminal of the given size
+	Start(rows, cols uint16) error
+
+	// Read reads output of the terminal. It returns io.EOF once the program has exited.
+	Read(p []byte) (int, error)
+
+	// Write sends input to the terminal
+	Write(p []byte) (int, error)
+
+	// Resize changes the size of the terminal
+	Resize(rows, cols uint16) error
+
+	// Signal sends sig to the program on the terminal, or returns ErrSignalUnsupported
+	Signal(sig syscall.Signal) error
+
+	// Close hangs up the terminal, ending the program on it as a real terminal would
+	// when disconnected, and releases the backend's resources. Reads fail afterwards.
+	Close() error
+
+	// Wait waits until the program has ended, or the backend was closed, and returns
+	// how it ended
+	Wait() ExitStatus
+}
+
+// ExitStatus describes how the program of a backend ended
+type ExitStatus struct {
+	Code   int            // Exit code, -1 if the program was killed by a signal or it is unknown
+	Signal string         // Signal that killed the program, if any, e.g. "SIGKILL"
+	Usage  *ResourceUsage // Resources used by the program, if known
+}
+
+// unknownExit is the exit status of programs whose end could not be observed
+var unknownExit = ExitStatus{Code: -1}
+
+// Breaker is implemented by backends that can send a break condition to their terminal,
+// such as serial ports, where a break commonly stops a board's boot loader or triggers
+// a magic SysRq. See TerminalSession.SendBreak.
+type Breaker interface {
+	// Break holds the transmit line of the terminal in the break condition for breakLength
+	Break() error
+}
+
+// remoteEnvironment returns the variables of environment that make sense on another
+// host or in a container, leaving out those describing the server's own user and paths
+func remoteEnvironment(environment []string) []string {
+	var filtered []string
+	for _, variable := range environment {
+		name, _, _ := strings.Cut(variable, "=")
+		switch name {
+		case "PATH", "HOME", "USER", "SHELL":
+			continue
+		}
+		filtered = append(filtered, variable)
+	}
+	return filtered
+}
@@ -0,0 +1,199 @@
+package terminal_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/dansun78/go-remote-term/pkg/terminal"
+	"github.com/gorilla/websocket"
+)
+
+// fakeBackend is a terminal whose program is played by the test
+type fakeBackend struct {
+	output *io.PipeReader
+	print  *io.PipeWriter // Writes output of the terminal
+
+	lock    sync.Mutex
+	input   strings.Builder
+	rows    uint16
+	cols    uint16
+	signals []syscall.Signal
+	closed  bool
+
+	// stall, if set, holds up writes: a write announces itself by sending on stall,
+	// and goes on once it receives from it
+	stall chan struct{}
+}
+
+func newFakeBackend() *fakeBackend {
+	output, print := io.Pipe()
+	return &fakeBackend{output: output, print: print}
+}
+
+func (b *fakeBackend) Start(rows, cols uint16) error {
+	return b.Resize(rows, cols)
+}
+
+func (b *fakeBackend) Read(p []byte) (int, error) {
+	return b.output.Read(p)
+}
+
+func (b *fakeBackend) Write(p []byte) (int, error) {
+	if b.stall != nil {
+		b.stall <- struct{}{}
+		<-b.stall
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.input.Write(p)
+}
+
+func (b *fakeBackend) Resize(rows, cols uint16) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.rows, b.cols = rows, cols
+	return nil
+}
+
+func (b *fakeBackend) Signal(sig syscall.Signal) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.signals = append(b.signals, sig)
+	return nil
+}
+
+func (b *fakeBackend) Close() error {
+	b.lock.Lock()
+	b.closed = true
+	b.lock.Unlock()
+	return b.output.Close()
+}
+
+func (b *fakeBackend) Wait() terminal.ExitStatus {
+	return terminal.ExitStatus{Code: 5}
+}
+
+// received returns the input written to the terminal so far
+func (b *fakeBackend) received() string {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.input.String()
+}
+
+func TestSessionWithBackend(t *testing.T) {
+	t.Parallel()
+
+	manager := terminal.NewSessionManager()
+	defer manager.Close()
+	opts := testOptions()
+	terminal.SetAuthToken(opts, "secret")
+
+	backend := newFakeBackend()
+	session, err := manager.NewWithBackend(opts, nil, backend)
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+	if backend.rows != opts.InitialRows || backend.cols != opts.InitialCols {
+		t.Fatalf("Backend started with size %dx%d", backend.rows, backend.cols)
+	}
+
+	// Output of the backend is buffered and replayed to clients
+	backend.print.Write([]byte("hello from the backend\r\n"))
+	waitForOutput(t, session, "hello from the backend")
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		terminal.HandleWebSocketWithOptions(w, r, manager, opts)
+	}))
+	defer server.Close()
+	conn, resp := dialTerminal(t, server, terminal.Message{Type: "auth", Token: "secret", SessionID: session.ID})
+	defer conn.Close()
+	if !resp.Success || resp.SessionID != session.ID {
+		t.Fatalf("Failed to attach to session: %+v", resp)
+	}
+	readUntil(t, conn, func(message []byte) bool {
+		return strings.Contains(string(message), "hello from the backend")
+	})
+
+	// Input, resizes and signals reach the backend
+	conn.WriteMessage(websocket.TextMessage, []byte("typed"))
+	conn.WriteJSON(terminal.Message{Type: "resize", Rows: 30, Cols: 100})
+	deadline := time.Now().Add(5 * time.Second)
+	for backend.received() != "typed" || session.Info().Rows != 30 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Input or resize did not reach the backend, got %q", backend.received())
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if err := session.Signal(syscall.SIGINT); err != nil || len(backend.signals) != 1 {
+		t.Fatalf("Signal did not reach the backend: %v", err)
+	}
+
+	// The end of the output ends the session with the backend's exit status
+	backend.print.Close()
+	select {
+	case <-session.Done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Session did not end with its backend")
+	}
+	if end := session.End(); end.Reason != terminal.EndReasonExited || end.ExitCode != 5 || !backend.closed {
+		t.Fatalf("Unexpected end %+v, backend closed: %v", end, backend.closed)
+	}
+	if info := session.Info(); info.PID != 0 {
+		t.Fatalf("Expected no PID for a backend session, got %d", info.PID)
+	}
+}
+
+func TestStalledInputDoesNotBlockOutput(t *testing.T) {
+	t.Parallel()
+
+	manager := terminal.NewSessionManager()
+	defer manager.Close()
+	opts := testOptions()
+	terminal.SetAuthToken(opts, "secret")
+
+	backend := newFakeBackend()
+	backend.stall = make(chan struct{})
+	session, err := manager.NewWithBackend(opts, nil, backend)
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		terminal.HandleWebSocketWithOptions(w, r, manager, opts)
+	}))
+	defer server.Close()
+	conn, resp := dialTerminal(t, server, terminal.Message{Type: "auth", Token: "secret", SessionID: session.ID})
+	defer conn.Close()
+	if !resp.Success {
+		t.Fatalf("Failed to attach to session: %+v", resp)
+	}
+
+	// While the backend holds up input, output still reaches clients
+	conn.WriteMessage(websocket.TextMessage, []byte("typed"))
+	select {
+	case <-backend.stall:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Input did not reach the backend")
+	}
+	go backend.print.Write([]byte("output while stalled\r\n"))
+	readUntil(t, conn, func(message []byte) bool {
+		return strings.Contains(string(message), "output while stalled")
+	})
+	if len(manager.List()) != 1 {
+		t.Fatal("Expected the manager to list the session")
+	}
+
+	backend.stall <- struct{}{}
+	deadline := time.Now().Add(5 * time.Second)
+	for backend.received() != "typed" {
+		if time.Now().After(deadline) {
+			t.Fatalf("Input did not reach the backend, got %q", backend.received())
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
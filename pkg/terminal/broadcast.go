@@ -0,0 +1,112 @@
+package terminal
+
+import "log"
+
+// subscriberQueueSize is the number of events buffered per subscriber
+// before it is considered too slow and dropped
+const subscriberQueueSize = 256
+
+// sessionEvent is a single item delivered to the connections attached to a session:
+// either a chunk of terminal output or a control message
+type sessionEvent struct {
+	Output  []byte    // Terminal output
+	Control *Response // Control message, sent instead of output when set
+}
+
+// outputSubscriber receives the events of a session for a single connection
+type outputSubscriber struct {
+	// C delivers events in order; it is closed when the subscriber is dropped
+	C chan sessionEvent
+}
+
+// subscribe registers a new output subscriber for the session
+// The caller must hold session.Lock, which lets it snapshot the output
+// buffer and subscribe atomically so that no output is lost or duplicated
+func (session *TerminalSession) subscribe() *outputSubscriber {
+	sub := &outputSubscriber{
+		C: make(chan sessionEvent, subscriberQueueSize),
+	}
+	if session.subscribers == nil {
+		session.subscribers = make(map[*outputSubscriber]struct{})
+	}
+	session.subscribers[sub] = struct{}{}
+	return sub
+}
+
+// unsubscribe removes a subscriber from the session
+func (session *TerminalSession) unsubscribe(sub *outputSubscriber) {
+	session.Lock.Lock()
+	defer session.Lock.Unlock()
+
+	if _, exists := session.subscribers[sub]; exists {
+		delete(session.subscribers, sub)
+		close(sub.C)
+	}
+}
+
+// publish pushes an output chunk to every subscriber of the session
+// The caller must hold session.Lock.
+func (session *TerminalSession) publish(data []byte) {
+	session.publishEvent(sessionEvent{Output: data})
+}
+
+// broadcastControl sends a control message to every connection attached to the session
+func (session *TerminalSession) broadcastControl(resp Response) {
+	session.Lock.Lock()
+	defer session.Lock.Unlock()
+
+	session.publishEvent(sessionEvent{Control: &resp})
+}
+
+// dropSubscribers drops every subscriber of the session, which closes their connections
+func (session *TerminalSession) dropSubscribers() {
+	session.Lock.Lock()
+	defer session.Lock.Unlock()
+
+	for sub := range session.subscribers {
+		delete(session.subscribers, sub)
+		close(sub.C)
+	}
+}
+
+// publishEvent pushes an event to every subscriber of the session
+// The caller must hold session.Lock. Subscribers whose queue is full are
+// dropped instead of blocking the PTY reader; their connection is closed and
+// the client resynchronizes from the output buffer when it reconnects.
+func (session *TerminalSession) publishEvent(event sessionEvent) {
+	for sub := range session.subscribers {
+		select {
+		case sub.C <- event:
+		default:
+			log.Printf("Dropping slow subscriber for session %s", session.ID)
+			delete(session.subscribers, sub)
+			close(sub.C)
+		}
+	}
+}
+
+// maxCoalescedSize bounds how many bytes are merged into a single WebSocket frame
+const maxCoalescedSize = 32 * 1024
+
+// drainPending appends the output of events already queued for the subscriber
+// to data without blocking, so that bursts of output are sent in as few frames
+// as possible. Draining stops at the first control message, which is returned
+// so the caller can send it after the merged output.
+func drainPending(sub *outputSubscriber, data []byte) ([]byte, *sessionEvent) {
+	for len(data) < maxCoalescedSize {
+		select {
+		case event, ok := <-sub.C:
+			if !ok {
+				return data, nil
+			}
+			if event.Control != nil {
+				return data, &event
+			}
+			// Chunks are shared between subscribers, so never append in place
+			data = append(data[:len(data):len(data)], event.Output...)
+		default:
+			return data, nil
+		}
+	}
+	return data, nil
+}
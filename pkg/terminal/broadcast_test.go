@@ -0,0 +1,88 @@
+package terminal
+
+import "testing"
+
+func TestPublishDeliversToAllSubscribers(t *testing.T) {
+	session := &TerminalSession{ID: "test"}
+
+	session.Lock.Lock()
+	first := session.subscribe()
+	second := session.subscribe()
+	session.publish([]byte("hello"))
+	session.Lock.Unlock()
+
+	for i, sub := range []*outputSubscriber{first, second} {
+		select {
+		case event := <-sub.C:
+			if string(event.Output) != "hello" {
+				t.Errorf("Subscriber %d got %q, expected %q", i, event.Output, "hello")
+			}
+		default:
+			t.Errorf("Subscriber %d received nothing", i)
+		}
+	}
+}
+
+func TestPublishDropsSlowSubscriber(t *testing.T) {
+	session := &TerminalSession{ID: "test"}
+
+	session.Lock.Lock()
+	sub := session.subscribe()
+	// Fill the queue and publish one more chunk to overflow it
+	for i := 0; i <= subscriberQueueSize; i++ {
+		session.publish([]byte("x"))
+	}
+	_, stillSubscribed := session.subscribers[sub]
+	session.Lock.Unlock()
+
+	if stillSubscribed {
+		t.Fatalf("Expected slow subscriber to be dropped")
+	}
+
+	// The queued chunks are still readable, followed by the channel being closed
+	count := 0
+	for range sub.C {
+		count++
+	}
+	if count != subscriberQueueSize {
+		t.Errorf("Expected %d queued chunks, got %d", subscriberQueueSize, count)
+	}
+
+	// Unsubscribing a dropped subscriber must not panic
+	session.unsubscribe(sub)
+}
+
+func TestDrainPendingDoesNotModifySharedChunks(t *testing.T) {
+	sub := &outputSubscriber{C: make(chan sessionEvent, 2)}
+
+	shared := make([]byte, 1, 16)
+	shared[0] = 'a'
+	sub.C <- sessionEvent{Output: []byte("b")}
+
+	merged, next := drainPending(sub, shared)
+	if string(merged) != "ab" || next != nil {
+		t.Errorf("Expected merged output %q, got %q", "ab", merged)
+	}
+	if string(shared[:cap(shared)][1:2]) == "b" {
+		t.Errorf("drainPending wrote into the shared chunk's backing array")
+	}
+}
+
+func TestDrainPendingStopsAtControlMessage(t *testing.T) {
+	sub := &outputSubscriber{C: make(chan sessionEvent, 3)}
+
+	sub.C <- sessionEvent{Output: []byte("b")}
+	sub.C <- sessionEvent{Control: &Response{Type: "server_shutdown"}}
+	sub.C <- sessionEvent{Output: []byte("c")}
+
+	merged, next := drainPending(sub, []byte("a"))
+	if string(merged) != "ab" {
+		t.Errorf("Expected merged output %q, got %q", "ab", merged)
+	}
+	if next == nil || next.Control == nil || next.Control.Type != "server_shutdown" {
+		t.Fatalf("Expected the control message to be returned, got %+v", next)
+	}
+	if len(sub.C) != 1 {
+		t.Errorf("Expected output after the control message to stay queued")
+	}
+}
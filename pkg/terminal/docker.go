@@ -0,0 +1,420 @@
+package terminal
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// DefaultDockerSocket is where the Docker Engine API is served by default
+const DefaultDockerSocket = "/var/run/docker.sock"
+
+// dockerTimeout bounds each request to the Docker Engine API, except the exec stream
+const dockerTimeout = 10 * time.Second
+
+// ErrUnknownContainer is returned when a client requests a container that is not
+// running or does not match the configured labels
+var ErrUnknownContainer = errors.New("unknown container")
+
+// Docker lets sessions open a shell inside running containers through the Docker Engine
+// API. Only running containers matching every label filter are offered to clients and
+// accepted from them, so Labels acts as an allowlist.
+type Docker struct {
+	// Socket is the Unix socket of the Docker Engine API (default: DefaultDockerSocket)
+	Socket string
+
+	// Labels are the label filters containers must all match, each "key" or "key=value",
+	// e.g. "go-remote-term.enable=true" (default: none, every running container)
+	Labels []string
+
+	// Command runs in the container (default: /bin/sh)
+	Command []string
+
+	// User is the user the command runs as in the container (default: the container's user)
+	User string
+
+	clientOnce sync.Once
+	client     *http.Client
+}
+
+// Container is a running container sessions can be opened in
+type Container struct {
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	Image string `json:"image"`
+	State string `json:"state"`
+}
+
+// socket returns the path of the Docker Engine API socket
+func (d *Docker) socket() string {
+	if d.Socket == "" {
+		return DefaultDockerSocket
+	}
+	return d.Socket
+}
+
+// dial connects to the Docker Engine API socket
+func (d *Docker) dial(ctx context.Context) (net.Conn, error) {
+	var dialer net.Dialer
+	return dialer.DialContext(ctx, "unix", d.socket())
+}
+
+// httpClient returns the HTTP client talking to the Docker Engine API
+func (d *Docker) httpClient() *http.Client {
+	d.clientOnce.Do(func() {
+		d.client = &http.Client{
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+					return d.dial(ctx)
+				},
+			},
+			Timeout: dockerTimeout,
+		}
+	})
+	return d.client
+}
+
+// request sends a request to the Docker Engine API and decodes the JSON response into
+// result, if it is not nil
+func (d *Docker) request(ctx context.Context, method, path string, body, result interface{}) error {
+	var payload io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		payload = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, "http://docker"+path, payload)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := d.httpClient().Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach Docker: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return dockerError(resp)
+	}
+	if result == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return fmt.Errorf("failed to parse Docker response: %v", err)
+	}
+	return nil
+}
+
+// dockerError returns the error reported in a failed Docker Engine API response
+func dockerError(resp *http.Response) error {
+	var reply struct {
+		Message string `json:"message"`
+	}
+	json.NewDecoder(resp.Body).Decode(&reply)
+	if reply.Message == "" {
+		reply.Message = resp.Status
+	}
+	return fmt.Errorf("docker: %s", reply.Message)
+}
+
+// Containers lists the running containers matching the labels
+func (d *Docker) Containers(ctx context.Context) ([]Container, error) {
+	filters := map[string][]string{"status": {"running"}}
+	if len(d.Labels) > 0 {
+		filters["label"] = d.Labels
+	}
+	encoded, err := json.Marshal(filters)
+	if err != nil {
+		return nil, err
+	}
+
+	var listed []struct {
+		ID    string   `json:"Id"`
+		Names []string `json:"Names"`
+		Image string   `json:"Image"`
+		State string   `json:"State"`
+	}
+	if err := d.request(ctx, http.MethodGet, "/containers/json?filters="+url.QueryEscape(string(encoded)), nil, &listed); err != nil {
+		return nil, err
+	}
+
+	containers := make([]Container, 0, len(listed))
+	for _, entry := range listed {
+		container := Container{ID: entry.ID, Image: entry.Image, State: entry.State}
+		if len(entry.Names) > 0 {
+			container.Name = strings.TrimPrefix(entry.Names[0], "/")
+		}
+		containers = append(containers, container)
+	}
+	return containers, nil
+}
+
+// lookup returns the running container matching the labels with the given ID, ID prefix of
+// at least 12 characters, or name
+func (d *Docker) lookup(ctx context.Context, reference string) (*Container, error) {
+	containers, err := d.Containers(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for i, container := range containers {
+		if container.ID == reference || container.Name == reference ||
+			(len(reference) >= 12 && strings.HasPrefix(container.ID, reference)) {
+			return &containers[i], nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %q", ErrUnknownContainer, reference)
+}
+
+// sessionOptions returns the options of sessions in the container, based on options
+func (d *Docker) sessionOptions(options *TerminalOptions, container *Container) *TerminalOptions {
+	opts := *options
+	opts.Shell = "docker://" + container.Name
+	return &opts
+}
+
+// ContainersHandler serves the containers sessions can be opened in as JSON, so that
+// clients can offer them when starting a new session. It serves an empty list if docker is nil.
+func ContainersHandler(docker *Docker) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeAPIError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+		if docker == nil {
+			writeJSON(w, http.StatusOK, []Container{})
+			return
+		}
+		containers, err := docker.Containers(r.Context())
+		if err != nil {
+			writeAPIError(w, http.StatusBadGateway, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, containers)
+	})
+}
+
+// dockerBackend runs a command in a container through a Docker exec instance
+type dockerBackend struct {
+	docker      *Docker
+	containerID string
+	environment []string
+
+	execID string
+	conn   net.Conn      // Hijacked connection carrying the raw terminal stream
+	stream *bufio.Reader // Output of the exec, starting with what was read with the response
+
+	closed    chan struct{} // Closed once the backend has been closed
+	closeOnce sync.Once
+}
+
+// newDockerBackend returns a backend running the configured command in the container
+func newDockerBackend(docker *Docker, containerID string, environment []string) *dockerBackend {
+	return &dockerBackend{
+		docker:      docker,
+		containerID: containerID,
+		environment: remoteEnvironment(environment),
+		closed:      make(chan struct{}),
+	}
+}
+
+// Start creates an exec instance with a TTY of the given size in the container and
+// attaches to it
+func (b *dockerBackend) Start(rows, cols uint16) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dockerTimeout)
+	defer cancel()
+
+	command := b.docker.Command
+	if len(command) == 0 {
+		command = []string{"/bin/sh"}
+	}
+	var created struct {
+		ID string `json:"Id"`
+	}
+	err := b.docker.request(ctx, http.MethodPost, "/containers/"+url.PathEscape(b.containerID)+"/exec", map[string]interface{}{
+		"AttachStdin":  true,
+		"AttachStdout": true,
+		"AttachStderr": true,
+		"Tty":          true,
+		"Cmd":          command,
+		"Env":          b.environment,
+		"User":         b.docker.User,
+		"ConsoleSize":  []uint16{rows, cols},
+	}, &created)
+	if err != nil {
+		return fmt.Errorf("failed to create exec in container %s: %v", b.containerID, err)
+	}
+	b.execID = created.ID
+
+	if err := b.attach(ctx); err != nil {
+		return fmt.Errorf("failed to start exec in container %s: %v", b.containerID, err)
+	}
+
+	// Daemons that predate ConsoleSize only size the terminal once the exec is running
+	b.Resize(rows, cols)
+	return nil
+}
+
+// attach starts the exec instance and takes over the connection of the request, which
+// the daemon turns into the raw stream of the terminal
+func (b *dockerBackend) attach(ctx context.Context) error {
+	conn, err := b.docker.dial(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to reach Docker: %v", err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	body, _ := json.Marshal(map[string]bool{"Detach": false, "Tty": true})
+	req, err := http.NewRequest(http.MethodPost, "http://docker/exec/"+url.PathEscape(b.execID)+"/start", bytes.NewReader(body))
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "tcp")
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to send request: %v", err)
+	}
+
+	stream := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(stream, req)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to read response: %v", err)
+	}
+	// The daemon switches protocols, or streams in the response body on older versions
+	if resp.StatusCode != http.StatusSwitchingProtocols && resp.StatusCode != http.StatusOK {
+		err := dockerError(resp)
+		conn.Close()
+		return err
+	}
+
+	conn.SetDeadline(time.Time{})
+	b.conn = conn
+	b.stream = stream
+	return nil
+}
+
+// Read reads output of the terminal in the container
+func (b *dockerBackend) Read(p []byte) (int, error) {
+	return b.stream.Read(p)
+}
+
+// Write sends input to the terminal in the container
+func (b *dockerBackend) Write(p []byte) (int, error) {
+	return b.conn.Write(p)
+}
+
+// Resize changes the size of the terminal in the container
+func (b *dockerBackend) Resize(rows, cols uint16) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dockerTimeout)
+	defer cancel()
+	return b.docker.request(ctx, http.MethodPost, fmt.Sprintf("/exec/%s/resize?h=%d&w=%d", url.PathEscape(b.execID), rows, cols), nil, nil)
+}
+
+// Signal is not supported, as the Docker Engine API cannot signal exec instances
+func (b *dockerBackend) Signal(sig syscall.Signal) error {
+	return ErrSignalUnsupported
+}
+
+// Close ends the command of the exec instance and detaches from it. The daemon keeps
+// the terminal open after detaching, so the command is hung up first.
+func (b *dockerBackend) Close() error {
+	var err error
+	b.closeOnce.Do(func() {
+		if b.conn != nil {
+			b.hangUp()
+			err = b.conn.Close()
+		}
+		close(b.closed)
+	})
+	return err
+}
+
+// hangUp sends SIGHUP to the command of the exec instance if it runs on this host, as
+// the Docker Engine API cannot signal exec instances. Otherwise the terminal is sent an
+// end of file, which ends shells waiting for input.
+func (b *dockerBackend) hangUp() {
+	var inspected struct {
+		Running bool `json:"Running"`
+		Pid     int  `json:"Pid"`
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), dockerTimeout)
+	err := b.docker.request(ctx, http.MethodGet, "/exec/"+url.PathEscape(b.execID)+"/json", nil, &inspected)
+	cancel()
+	if err == nil && !inspected.Running {
+		return
+	}
+
+	// The PID is in the daemon's namespace, which is only the server's if the process
+	// shows up here in the container
+	if err == nil && inspected.Pid > 0 && inContainer(inspected.Pid, b.containerID) {
+		if process, err := os.FindProcess(inspected.Pid); err == nil && process.Signal(syscall.SIGHUP) == nil {
+			return
+		}
+	}
+	b.conn.SetWriteDeadline(time.Now().Add(dockerTimeout))
+	b.conn.Write([]byte{4})
+}
+
+// Wait waits for the exec instance to stop running and returns its exit code. It is
+// unknown if the instance is still running shortly after the backend was closed.
+func (b *dockerBackend) Wait() ExitStatus {
+	if b.execID == "" {
+		<-b.closed
+		return unknownExit
+	}
+
+	var deadline time.Time
+	interval := 20 * time.Millisecond
+	for {
+		var inspected struct {
+			Running  bool `json:"Running"`
+			ExitCode *int `json:"ExitCode"`
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), dockerTimeout)
+		err := b.docker.request(ctx, http.MethodGet, "/exec/"+url.PathEscape(b.execID)+"/json", nil, &inspected)
+		cancel()
+		if err != nil {
+			return unknownExit
+		}
+		if !inspected.Running && inspected.ExitCode != nil {
+			return ExitStatus{Code: *inspected.ExitCode}
+		}
+
+		select {
+		case <-b.closed:
+			if deadline.IsZero() {
+				deadline = time.Now().Add(processExitTimeout)
+			}
+			if time.Now().After(deadline) {
+				return unknownExit
+			}
+		default:
+		}
+		time.Sleep(interval)
+		if interval < 500*time.Millisecond {
+			interval *= 2
+		}
+	}
+}
@@ -0,0 +1,313 @@
+package terminal_test
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/creack/pty"
+	"github.com/dansun78/go-remote-term/pkg/terminal"
+	"github.com/gorilla/websocket"
+)
+
+// fakeDocker serves the parts of the Docker Engine API used by the terminal package,
+// running exec instances as local shells
+type fakeDocker struct {
+	t          *testing.T
+	containers []fakeContainer
+
+	lock  sync.Mutex
+	execs map[string]*fakeExec
+}
+
+type fakeContainer struct {
+	id     string
+	name   string
+	labels map[string]string
+}
+
+type fakeExec struct {
+	container string
+	config    struct {
+		Tty         bool
+		Cmd         []string
+		Env         []string
+		ConsoleSize []uint16
+	}
+	pty      *os.File
+	running  bool
+	exitCode int
+}
+
+// startFakeDocker serves a fake Docker Engine API on a Unix socket and returns its path
+func startFakeDocker(t *testing.T, containers ...fakeContainer) (*fakeDocker, string) {
+	t.Helper()
+
+	docker := &fakeDocker{t: t, containers: containers, execs: make(map[string]*fakeExec)}
+	socket := filepath.Join(t.TempDir(), "docker.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	server := &http.Server{Handler: docker}
+	go server.Serve(listener)
+	t.Cleanup(func() { server.Close() })
+	return docker, socket
+}
+
+func (d *fakeDocker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/containers/json":
+		d.list(w, r)
+	case r.Method == http.MethodPost && len(parts) == 3 && parts[0] == "containers" && parts[2] == "exec":
+		d.create(w, r, parts[1])
+	case r.Method == http.MethodPost && len(parts) == 3 && parts[0] == "exec" && parts[2] == "start":
+		d.start(w, r, parts[1])
+	case r.Method == http.MethodPost && len(parts) == 3 && parts[0] == "exec" && parts[2] == "resize":
+		d.resize(w, r, parts[1])
+	case r.Method == http.MethodGet && len(parts) == 3 && parts[0] == "exec" && parts[2] == "json":
+		d.inspect(w, parts[1])
+	default:
+		http.Error(w, `{"message": "page not found"}`, http.StatusNotFound)
+	}
+}
+
+// list lists the containers matching every label filter
+func (d *fakeDocker) list(w http.ResponseWriter, r *http.Request) {
+	var filters map[string][]string
+	json.Unmarshal([]byte(r.URL.Query().Get("filters")), &filters)
+
+	var listed []map[string]interface{}
+	for _, container := range d.containers {
+		matches := true
+		for _, filter := range filters["label"] {
+			key, value, hasValue := strings.Cut(filter, "=")
+			actual, exists := container.labels[key]
+			if !exists || (hasValue && actual != value) {
+				matches = false
+			}
+		}
+		if matches {
+			listed = append(listed, map[string]interface{}{
+				"Id": container.id, "Names": []string{"/" + container.name}, "Image": "alpine", "State": "running",
+			})
+		}
+	}
+	json.NewEncoder(w).Encode(listed)
+}
+
+func (d *fakeDocker) create(w http.ResponseWriter, r *http.Request, container string) {
+	instance := &fakeExec{container: container}
+	if err := json.NewDecoder(r.Body).Decode(&instance.config); err != nil {
+		http.Error(w, `{"message": "bad request"}`, http.StatusBadRequest)
+		return
+	}
+
+	d.lock.Lock()
+	id := "exec" + strconv.Itoa(len(d.execs)+1)
+	d.execs[id] = instance
+	d.lock.Unlock()
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]string{"Id": id})
+}
+
+// start runs the exec instance's command on a PTY and relays it over the hijacked connection
+func (d *fakeDocker) start(w http.ResponseWriter, r *http.Request, id string) {
+	d.lock.Lock()
+	instance, exists := d.execs[id]
+	d.lock.Unlock()
+	if !exists || r.Header.Get("Upgrade") != "tcp" {
+		http.Error(w, `{"message": "no such exec"}`, http.StatusNotFound)
+		return
+	}
+
+	cmd := execCommand(instance.config.Cmd, instance.config.Env)
+	size := &pty.Winsize{Rows: instance.config.ConsoleSize[0], Cols: instance.config.ConsoleSize[1]}
+	ptmx, err := pty.StartWithSize(cmd, size)
+	if err != nil {
+		http.Error(w, `{"message": "failed to start"}`, http.StatusInternalServerError)
+		return
+	}
+	d.lock.Lock()
+	instance.pty = ptmx
+	instance.running = true
+	d.lock.Unlock()
+
+	io.Copy(io.Discard, r.Body)
+	conn, buf, err := w.(http.Hijacker).Hijack()
+	if err != nil {
+		d.t.Errorf("Failed to hijack: %v", err)
+		return
+	}
+	buf.WriteString("HTTP/1.1 101 UPGRADED\r\nContent-Type: application/vnd.docker.raw-stream\r\nConnection: Upgrade\r\nUpgrade: tcp\r\n\r\n")
+	buf.Flush()
+
+	go io.Copy(ptmx, buf)
+	io.Copy(conn, ptmx)
+	cmd.Wait()
+	d.lock.Lock()
+	instance.running = false
+	instance.exitCode = cmd.ProcessState.ExitCode()
+	d.lock.Unlock()
+	conn.Close()
+}
+
+func (d *fakeDocker) resize(w http.ResponseWriter, r *http.Request, id string) {
+	rows, _ := strconv.Atoi(r.URL.Query().Get("h"))
+	cols, _ := strconv.Atoi(r.URL.Query().Get("w"))
+	d.lock.Lock()
+	instance, exists := d.execs[id]
+	d.lock.Unlock()
+	if !exists || instance.pty == nil {
+		http.Error(w, `{"message": "exec is not running"}`, http.StatusConflict)
+		return
+	}
+	pty.Setsize(instance.pty, &pty.Winsize{Rows: uint16(rows), Cols: uint16(cols)})
+}
+
+func (d *fakeDocker) inspect(w http.ResponseWriter, id string) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	instance, exists := d.execs[id]
+	if !exists {
+		http.Error(w, `{"message": "no such exec"}`, http.StatusNotFound)
+		return
+	}
+	reply := map[string]interface{}{"Running": instance.running, "ExitCode": nil}
+	if !instance.running && instance.pty != nil {
+		reply["ExitCode"] = instance.exitCode
+	}
+	json.NewEncoder(w).Encode(reply)
+}
+
+// firstExec returns the only exec instance created so far
+func (d *fakeDocker) firstExec() *fakeExec {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.execs["exec1"]
+}
+
+// execCommand returns the command of an exec instance with the given environment
+func execCommand(argv, env []string) *exec.Cmd {
+	cmd := exec.Command(argv[0], argv[1:]...)
+	cmd.Env = append(env, "PATH="+os.Getenv("PATH"))
+	return cmd
+}
+
+func TestDockerSession(t *testing.T) {
+	t.Parallel()
+
+	docker, socket := startFakeDocker(t,
+		fakeContainer{id: strings.Repeat("a", 64), name: "web", labels: map[string]string{"terminal": "yes"}},
+		fakeContainer{id: strings.Repeat("b", 64), name: "db", labels: map[string]string{"terminal": "no"}},
+	)
+
+	manager := terminal.NewSessionManager()
+	defer manager.Close()
+	opts := testOptions()
+	terminal.SetAuthToken(opts, "secret")
+	opts.Docker = &terminal.Docker{Socket: socket, Labels: []string{"terminal=yes"}}
+
+	// Only containers matching the labels are listed
+	recorder := httptest.NewRecorder()
+	terminal.ContainersHandler(opts.Docker).ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/containers", nil))
+	var containers []terminal.Container
+	json.NewDecoder(recorder.Body).Decode(&containers)
+	if len(containers) != 1 || containers[0].Name != "web" {
+		t.Fatalf("Expected only the labelled container, got %+v", containers)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		terminal.HandleWebSocketWithOptions(w, r, manager, opts)
+	}))
+	defer server.Close()
+
+	// Nor can sessions be opened in other containers
+	conn, resp := dialTerminal(t, server, terminal.Message{Type: "auth", Token: "secret", Container: "db"})
+	conn.Close()
+	if resp.Success {
+		t.Fatal("Session opened in a container not matching the labels")
+	}
+
+	conn, resp = dialTerminal(t, server, terminal.Message{Type: "auth", Token: "secret", Container: "web"})
+	defer conn.Close()
+	if !resp.Success {
+		t.Fatalf("Failed to open session in container: %+v", resp)
+	}
+	session, _ := manager.Get(resp.SessionID)
+	if info := session.Info(); info.Container != "web" {
+		t.Fatalf("Unexpected session info %+v", info)
+	}
+	instance := docker.firstExec()
+	if instance.container != strings.Repeat("a", 64) || !instance.config.Tty || instance.config.Cmd[0] != "/bin/sh" {
+		t.Fatalf("Unexpected exec instance %+v", instance)
+	}
+	for _, variable := range instance.config.Env {
+		if strings.HasPrefix(variable, "PATH=") || strings.HasPrefix(variable, "HOME=") {
+			t.Fatalf("Server variable %s passed to the container", variable)
+		}
+	}
+
+	// Input, output and resizes travel over the exec stream
+	if err := session.Resize(40, 120); err != nil {
+		t.Fatalf("Failed to resize: %v", err)
+	}
+	conn.WriteMessage(websocket.TextMessage, []byte("stty size; echo docker-$((6*7))\n"))
+	readUntil(t, conn, func(message []byte) bool {
+		return strings.Contains(string(message), "docker-42")
+	})
+	waitForOutput(t, session, "40 120")
+
+	// The exit code of the exec instance ends the session
+	conn.WriteMessage(websocket.TextMessage, []byte("exit 4\n"))
+	readUntil(t, conn, func(message []byte) bool {
+		return strings.Contains(string(message), "session_ended")
+	})
+	if end := session.End(); end == nil || end.Reason != terminal.EndReasonExited || end.ExitCode != 4 {
+		t.Fatalf("Expected the session to end with exit code 4, got %+v", end)
+	}
+}
+
+func TestDockerSessionTerminate(t *testing.T) {
+	t.Parallel()
+
+	docker, socket := startFakeDocker(t, fakeContainer{id: strings.Repeat("c", 64), name: "web"})
+	manager := terminal.NewSessionManager()
+	defer manager.Close()
+	opts := testOptions()
+	opts.Docker = &terminal.Docker{Socket: socket}
+
+	session, err := manager.NewInContainer(opts, nil, "web")
+	if err != nil {
+		t.Fatalf("Failed to open session in container: %v", err)
+	}
+	session.Backend.Write([]byte("echo ready-$((6*7))\n"))
+	waitForOutput(t, session, "ready-42")
+
+	// The daemon keeps the command running after detaching, so it is hung up first
+	manager.Terminate(session.ID)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		docker.lock.Lock()
+		running := docker.execs["exec1"].running
+		docker.lock.Unlock()
+		if !running {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the command of the exec instance to end with the session")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
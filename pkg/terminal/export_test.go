@@ -0,0 +1,15 @@
+package terminal
+
+// AttachForTest counts a connection to session, as a client that has not detached
+// yet, until the returned function is called
+func AttachForTest(session *TerminalSession) (detach func()) {
+	session.Lock.Lock()
+	session.Connections++
+	session.Lock.Unlock()
+
+	return func() {
+		session.Lock.Lock()
+		session.Connections--
+		session.Lock.Unlock()
+	}
+}
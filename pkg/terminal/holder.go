@@ -0,0 +1,245 @@
+package terminal
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// ptyHolderArg0 is the program name the server is re-executed with as PTY holder
+const ptyHolderArg0 = "go-remote-term-pty-holder"
+
+// holderTimeout bounds each exchange with a holder
+const holderTimeout = 5 * time.Second
+
+// Operations understood by a holder
+const (
+	holderSave    = "save"    // Store the session state sent along
+	holderAttach  = "attach"  // Send the terminal and the stored session state
+	holderRelease = "release" // Exit, as the session has ended
+)
+
+// persistedSession is the state of a persistent session kept by its holder, from
+// which a later server instance restores the session
+type persistedSession struct {
+	ID         string     `json:"id"`
+	PID        int        `json:"pid"`
+	CreatedAt  time.Time  `json:"created_at"`
+	Principal  *Principal `json:"principal,omitempty"`
+	Profile    string     `json:"profile,omitempty"`
+	User       string     `json:"user,omitempty"`
+	Rows       uint16     `json:"rows"`
+	Cols       uint16     `json:"cols"`
+	Scrollback []byte     `json:"scrollback,omitempty"`
+	Cgroup     string     `json:"cgroup,omitempty"`  // Path of the session's cgroup, if any
+	Overlay    string     `json:"overlay,omitempty"` // Overlay layers of the session's sandbox, if any
+
+	// RecordingStart is when the session's recording began, so that a restored session
+	// continues it with consistent event times
+	RecordingStart time.Time `json:"recording_start,omitempty"`
+}
+
+// holderRequest is sent to a holder, which answers with a holderReply
+type holderRequest struct {
+	Op    string            `json:"op"`
+	State *persistedSession `json:"state,omitempty"`
+}
+
+// holderReply answers a holderRequest. The reply to an attach request is preceded by
+// a single byte carrying the terminal as SCM_RIGHTS ancillary data.
+type holderReply struct {
+	Error string            `json:"error,omitempty"`
+	State *persistedSession `json:"state,omitempty"`
+}
+
+// ptyHolder is the holder process keeping the terminal of a persistent session open
+// while no server owns it, reached through its control socket
+type ptyHolder struct {
+	socket string
+}
+
+// save stores the state of the session in the holder
+func (h *ptyHolder) save(state *persistedSession) error {
+	_, _, err := h.exchange(holderRequest{Op: holderSave, State: state})
+	return err
+}
+
+// attach returns the session's terminal and its last saved state
+func (h *ptyHolder) attach() (*persistedSession, *os.File, error) {
+	reply, ptmx, err := h.exchange(holderRequest{Op: holderAttach})
+	if err != nil {
+		return nil, nil, err
+	}
+	return reply.State, ptmx, nil
+}
+
+// release makes the holder exit. A holder that is already gone is not an error.
+func (h *ptyHolder) release() error {
+	_, _, err := h.exchange(holderRequest{Op: holderRelease})
+	if errors.Is(err, fs.ErrNotExist) || errors.Is(err, syscall.ECONNREFUSED) {
+		return nil
+	}
+	return err
+}
+
+// detach hands the session over to its holder for a later server instance to restore:
+// connections are told the server is restarting and dropped, and the session's state
+// is saved in the holder. The shell keeps running.
+func (session *TerminalSession) detach() error {
+	session.Lock.Lock()
+	session.detached = true
+	session.Lock.Unlock()
+
+	// Stop reading, so the scrollback saved below is complete; unread output stays
+	// in the terminal for the next server
+	session.Backend.(*ptyBackend).pty.Close()
+	<-session.readerDone
+
+	session.broadcastControl(Response{
+		Type:      "server_restart",
+		Success:   true,
+		Message:   "Server is restarting, the session will resume on reconnect",
+		SessionID: session.ID,
+	})
+	session.dropSubscribers()
+
+	if session.recorder != nil {
+		if err := session.recorder.Close(); err != nil {
+			log.Printf("Error closing recording for session %s: %v", session.ID, err)
+		}
+	}
+
+	if err := session.holder.save(session.persistedState()); err != nil {
+		return fmt.Errorf("failed to save session %s: %v", session.ID, err)
+	}
+	log.Printf("Session %s detached to its holder", session.ID)
+	return nil
+}
+
+// persistedState returns the state of the session to keep in its holder
+func (session *TerminalSession) persistedState() *persistedSession {
+	session.Lock.Lock()
+	defer session.Lock.Unlock()
+
+	backend := session.Backend.(*ptyBackend)
+	state := &persistedSession{
+		ID:         session.ID,
+		PID:        backend.pid(),
+		CreatedAt:  session.CreatedAt,
+		Principal:  session.Principal,
+		Profile:    session.Profile,
+		User:       session.User,
+		Rows:       session.Rows,
+		Cols:       session.Cols,
+		Scrollback: session.OutputBuffer.Bytes(),
+	}
+	if backend.cgroup != nil {
+		state.Cgroup = backend.cgroup.path
+	}
+	if backend.sandbox != nil {
+		state.Overlay = backend.sandbox.overlayDir
+	}
+	if session.recorder != nil {
+		state.RecordingStart = session.recorder.start
+	}
+	return state
+}
+
+// restoreSession recreates a session detached by a previous server instance from
+// its terminal and the state kept by its holder. The shell is not a child of this
+// process, so its exit status cannot be collected.
+func restoreSession(options *TerminalOptions, holder *ptyHolder, state *persistedSession, ptmx *os.File) *TerminalSession {
+	process, _ := os.FindProcess(state.PID)
+	backend := newPTYBackend(state.ID, &exec.Cmd{Process: process}, options, nil)
+	backend.pty = ptmx
+	if state.Cgroup != "" {
+		backend.cgroup = &sessionCgroup{path: state.Cgroup}
+	}
+	if state.Overlay != "" {
+		backend.sandbox = &sessionSandbox{overlayDir: state.Overlay}
+	}
+
+	session := &TerminalSession{
+		ID:           state.ID,
+		Backend:      backend,
+		Options:      options,
+		OutputBuffer: NewScrollback(options.ScrollbackBytes, options.ScrollbackLines),
+		CreatedAt:    state.CreatedAt,
+		LastActive:   time.Now(),
+		Rows:         state.Rows,
+		Cols:         state.Cols,
+		Done:         make(chan struct{}),
+		Principal:    state.Principal,
+		Profile:      state.Profile,
+		User:         state.User,
+		readerDone:   make(chan struct{}),
+		holder:       holder,
+	}
+	session.OutputBuffer.Write(state.Scrollback)
+	return session
+}
+
+// resumeRecording continues the recording of a restored session that began at start,
+// if options.RecordingDir is set. The session is closed if that fails.
+func (session *TerminalSession) resumeRecording(start time.Time) error {
+	if session.Options.RecordingDir == "" {
+		return nil
+	}
+	rec, err := resumeRecorder(session.ID, session.Options, start)
+	if err != nil {
+		session.close(SessionEnd{Reason: EndReasonTerminated})
+		return err
+	}
+	session.recorder = rec
+	return nil
+}
+
+// Restore takes over the persistent sessions a previous server instance left in
+// options.PersistDir and registers them with the manager under their old IDs, so
+// clients reconnecting with a saved session ID resume them with their scrollback.
+// Sessions whose shell has exited in the meantime are gone.
+func (m *SessionManager) Restore(options *TerminalOptions) ([]*TerminalSession, error) {
+	if err := preparePersistDir(options.PersistDir); err != nil {
+		return nil, err
+	}
+	sockets, err := filepath.Glob(filepath.Join(options.PersistDir, "*.sock"))
+	if err != nil {
+		return nil, err
+	}
+
+	var restored []*TerminalSession
+	for _, socket := range sockets {
+		holder := &ptyHolder{socket: socket}
+		state, ptmx, err := holder.attach()
+		if err != nil {
+			// Nothing listens on the sockets of holders that were killed
+			if errors.Is(err, syscall.ECONNREFUSED) {
+				os.Remove(socket)
+			}
+			log.Printf("Failed to restore session from %s: %v", socket, err)
+			continue
+		}
+
+		session := restoreSession(options, holder, state, ptmx)
+		if err := session.resumeRecording(state.RecordingStart); err != nil {
+			// Sessions are not run unrecorded when recording is enabled
+			log.Printf("Failed to restore session %s: %v", session.ID, err)
+			continue
+		}
+		if err := m.register(session); err != nil {
+			ptmx.Close()
+			return restored, err
+		}
+		go bufferTerminalOutput(session)
+
+		log.Printf("Restored session %s (shell PID %d)", session.ID, state.PID)
+		restored = append(restored, session)
+	}
+	return restored, nil
+}
@@ -0,0 +1,42 @@
+//go:build !unix
+
+package terminal
+
+import (
+	"errors"
+	"os"
+)
+
+// errPersistenceUnsupported is returned when sessions are to be persisted on a platform other than Unix
+var errPersistenceUnsupported = errors.New("persistent sessions are only supported on Unix")
+
+// startPtyHolder fails, terminals cannot be handed to another process here
+func startPtyHolder(dir, sessionID string, ptmx *os.File, pid int) (*ptyHolder, error) {
+	return nil, errPersistenceUnsupported
+}
+
+// preparePersistDir fails, as persistent sessions are not supported
+func preparePersistDir(dir string) error {
+	return errPersistenceUnsupported
+}
+
+// pollable fails, as persistent sessions are not supported
+func pollable(ptmx *os.File) (*os.File, error) {
+	ptmx.Close()
+	return nil, errPersistenceUnsupported
+}
+
+// resizePollable resizes the terminal with ResizeTerminal
+func resizePollable(ptmx *os.File, rows, cols uint16) error {
+	return ResizeTerminal(ptmx, rows, cols)
+}
+
+// exchange fails, there is no holder to reach
+func (h *ptyHolder) exchange(req holderRequest) (*holderReply, *os.File, error) {
+	return nil, nil, errPersistenceUnsupported
+}
+
+// runPtyHolder fails, a holder cannot be run here
+func runPtyHolder(args []string) error {
+	return errPersistenceUnsupported
+}
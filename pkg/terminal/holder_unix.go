@@ -0,0 +1,307 @@
+//go:build unix
+
+package terminal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// holderPTYFd and holderListenerFd are the descriptors a holder inherits its
+// terminal and its listening socket as
+const (
+	holderPTYFd      = 3
+	holderListenerFd = 4
+)
+
+// startPtyHolder starts a detached holder process for the terminal of a new session,
+// listening on a control socket named after the session in dir
+func startPtyHolder(dir, sessionID string, ptmx *os.File, pid int) (*ptyHolder, error) {
+	if err := preparePersistDir(dir); err != nil {
+		return nil, err
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("failed to find executable: %v", err)
+	}
+
+	// The socket is created here, so it accepts connections as soon as this returns,
+	// and handed to the holder, which removes it when it exits
+	socket := filepath.Join(dir, sessionID+".sock")
+	listenerFile, err := listenHolderSocket(socket)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create holder socket: %v", err)
+	}
+	defer listenerFile.Close()
+
+	// The terminal is passed by its raw descriptor: os/exec would switch it to
+	// blocking mode, which stops Close from interrupting reads in this process
+	ptmxFd, err := rawFd(ptmx)
+	if err != nil {
+		os.Remove(socket)
+		return nil, fmt.Errorf("failed to pass terminal to holder: %v", err)
+	}
+	devNull, err := os.Open(os.DevNull)
+	if err != nil {
+		os.Remove(socket)
+		return nil, err
+	}
+	defer devNull.Close()
+
+	// The holder gets a session of its own, so it outlives the server and is not
+	// hung up along with it
+	holderPid, err := syscall.ForkExec(executable, []string{ptyHolderArg0, sessionID, strconv.Itoa(pid)}, &syscall.ProcAttr{
+		Env:   os.Environ(),
+		Files: []uintptr{devNull.Fd(), devNull.Fd(), devNull.Fd(), ptmxFd, listenerFile.Fd()},
+		Sys:   &syscall.SysProcAttr{Setsid: true},
+	})
+	if err != nil {
+		os.Remove(socket)
+		return nil, fmt.Errorf("failed to start holder: %v", err)
+	}
+
+	// Reap the holder if it exits while this server is still running
+	if process, err := os.FindProcess(holderPid); err == nil {
+		go process.Wait()
+	}
+
+	return &ptyHolder{socket: socket}, nil
+}
+
+// preparePersistDir creates dir if needed and makes sure that only this user can reach
+// the holder sockets in it, as whoever connects to them can take over the sessions
+func preparePersistDir(dir string) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("failed to create persistence directory: %v", err)
+	}
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return fmt.Errorf("failed to check persistence directory: %v", err)
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !info.IsDir() || !ok || int(stat.Uid) != os.Geteuid() {
+		return fmt.Errorf("persistence directory %s is not a directory owned by this user", dir)
+	}
+	if info.Mode().Perm()&0077 != 0 {
+		if err := os.Chmod(dir, 0700); err != nil {
+			return fmt.Errorf("failed to restrict persistence directory: %v", err)
+		}
+	}
+	return nil
+}
+
+// listenHolderSocket creates a listening Unix socket at path. Unlike net.ListenUnix,
+// it makes the socket private before it accepts connections.
+func listenHolderSocket(path string) (*os.File, error) {
+	syscall.ForkLock.RLock()
+	fd, err := unix.Socket(unix.AF_UNIX, unix.SOCK_STREAM, 0)
+	if err == nil {
+		unix.CloseOnExec(fd)
+	}
+	syscall.ForkLock.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := unix.Bind(fd, &unix.SockaddrUnix{Name: path}); err != nil {
+		unix.Close(fd)
+		return nil, err
+	}
+	err = os.Chmod(path, 0600)
+	if err == nil {
+		err = unix.Listen(fd, unix.SOMAXCONN)
+	}
+	if err != nil {
+		unix.Close(fd)
+		os.Remove(path)
+		return nil, err
+	}
+	return os.NewFile(uintptr(fd), path), nil
+}
+
+// pollable returns a non-blocking replacement for ptmx, whose reads are interrupted
+// by Close, so that a session can stop reading its terminal without closing it
+func pollable(ptmx *os.File) (*os.File, error) {
+	fd, err := syscall.Dup(int(ptmx.Fd()))
+	ptmx.Close()
+	if err != nil {
+		return nil, err
+	}
+	syscall.CloseOnExec(fd)
+	if err := syscall.SetNonblock(fd, true); err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+	return os.NewFile(uintptr(fd), ptmx.Name()), nil
+}
+
+// resizePollable resizes a terminal returned by pollable. Unlike ResizeTerminal, it
+// leaves the terminal in non-blocking mode.
+func resizePollable(ptmx *os.File, rows, cols uint16) error {
+	conn, err := ptmx.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var ioctlErr error
+	err = conn.Control(func(fd uintptr) {
+		ioctlErr = unix.IoctlSetWinsize(int(fd), unix.TIOCSWINSZ, &unix.Winsize{Row: rows, Col: cols})
+	})
+	if err != nil {
+		return err
+	}
+	return ioctlErr
+}
+
+// rawFd returns the descriptor of f without changing its mode, unlike f.Fd
+func rawFd(f *os.File) (uintptr, error) {
+	conn, err := f.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+	var fd uintptr
+	err = conn.Control(func(descriptor uintptr) {
+		fd = descriptor
+	})
+	return fd, err
+}
+
+// exchange sends a request to the holder and reads its reply, along with the
+// terminal for attach requests
+func (h *ptyHolder) exchange(req holderRequest) (*holderReply, *os.File, error) {
+	conn, err := net.DialUnix("unix", nil, &net.UnixAddr{Name: h.socket, Net: "unix"})
+	if err != nil {
+		return nil, nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(holderTimeout))
+
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return nil, nil, err
+	}
+
+	var ptmx *os.File
+	if req.Op == holderAttach {
+		oob := make([]byte, syscall.CmsgSpace(4))
+		_, oobn, _, _, err := conn.ReadMsgUnix(make([]byte, 1), oob)
+		if err != nil {
+			return nil, nil, err
+		}
+		messages, err := syscall.ParseSocketControlMessage(oob[:oobn])
+		if err != nil || len(messages) != 1 {
+			return nil, nil, fmt.Errorf("holder did not send a terminal: %v", err)
+		}
+		fds, err := syscall.ParseUnixRights(&messages[0])
+		if err != nil || len(fds) != 1 {
+			return nil, nil, fmt.Errorf("holder did not send a terminal: %v", err)
+		}
+		syscall.CloseOnExec(fds[0])
+		ptmx = os.NewFile(uintptr(fds[0]), "/dev/ptmx")
+	}
+
+	var reply holderReply
+	if err := json.NewDecoder(conn).Decode(&reply); err != nil {
+		if ptmx != nil {
+			ptmx.Close()
+		}
+		return nil, nil, err
+	}
+	if reply.Error != "" {
+		if ptmx != nil {
+			ptmx.Close()
+		}
+		return nil, nil, errors.New(reply.Error)
+	}
+	return &reply, ptmx, nil
+}
+
+// runPtyHolder keeps the inherited terminal open and serves requests on the inherited
+// socket until the session is released or its shell is gone. args are the session ID
+// and the PID of its shell.
+func runPtyHolder(args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("expected session ID and shell PID, got %q", args)
+	}
+	pid, err := strconv.Atoi(args[1])
+	if err != nil {
+		return fmt.Errorf("invalid shell PID: %v", err)
+	}
+
+	listenerFile := os.NewFile(holderListenerFd, "holder socket")
+	fileListener, err := net.FileListener(listenerFile)
+	listenerFile.Close()
+	if err != nil {
+		return fmt.Errorf("invalid holder socket: %v", err)
+	}
+	listener := fileListener.(*net.UnixListener)
+	listener.SetUnlinkOnClose(true)
+	defer listener.Close()
+
+	// Stop once the terminal hangs up, when the shell and whatever else had it open are
+	// gone; the server restoring the session sees it end then. Unlike the shell's PID,
+	// which may be reused by an unrelated process, the terminal stays ours.
+	go func() {
+		fds := []unix.PollFd{{Fd: holderPTYFd}}
+		for {
+			if _, err := unix.Poll(fds, -1); err != nil && err != unix.EINTR {
+				return
+			}
+			if fds[0].Revents&(unix.POLLHUP|unix.POLLERR|unix.POLLNVAL) != 0 {
+				listener.Close()
+				return
+			}
+		}
+	}()
+
+	state := &persistedSession{ID: args[0], PID: pid}
+	for {
+		conn, err := listener.AcceptUnix()
+		if err != nil {
+			return nil
+		}
+		if released := serveHolderRequest(conn, &state); released {
+			return nil
+		}
+	}
+}
+
+// serveHolderRequest answers a single request, reporting whether the holder was released
+func serveHolderRequest(conn *net.UnixConn, state **persistedSession) bool {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(holderTimeout))
+
+	var req holderRequest
+	if err := json.NewDecoder(conn).Decode(&req); err != nil {
+		return false
+	}
+
+	var reply holderReply
+	switch req.Op {
+	case holderSave:
+		if req.State == nil {
+			reply.Error = "no state to save"
+		} else {
+			*state = req.State
+		}
+	case holderAttach:
+		// The raw descriptor is used so that the terminal stays in non-blocking mode
+		if _, _, err := conn.WriteMsgUnix([]byte{0}, syscall.UnixRights(holderPTYFd), nil); err != nil {
+			return false
+		}
+		reply.State = *state
+	case holderRelease:
+	default:
+		reply.Error = fmt.Sprintf("unknown operation %q", req.Op)
+	}
+
+	json.NewEncoder(conn).Encode(reply)
+	return req.Op == holderRelease
+}
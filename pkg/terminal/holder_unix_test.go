@@ -0,0 +1,159 @@
+//go:build unix
+
+package terminal_test
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/dansun78/go-remote-term/pkg/terminal"
+)
+
+func TestSessionSurvivesRestart(t *testing.T) {
+	t.Parallel()
+
+	opts := testOptions()
+	opts.PersistDir = t.TempDir()
+	opts.RecordingDir = t.TempDir()
+	os.Chmod(opts.PersistDir, 0755)
+
+	first := terminal.NewSessionManager()
+	session, err := first.NewWithPrincipal(opts, &terminal.Principal{Name: "alice", Role: terminal.PrincipalUser})
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+
+	// Only this user can reach the holder
+	for path, want := range map[string]os.FileMode{
+		opts.PersistDir: 0700,
+		filepath.Join(opts.PersistDir, session.ID+".sock"): 0600,
+	} {
+		if info, err := os.Stat(path); err != nil || info.Mode().Perm() != want {
+			t.Fatalf("Expected %s to have mode %v: %v %v", path, want, info, err)
+		}
+	}
+	pid := session.Info().PID
+	session.Backend.Write([]byte("echo before-$((20+1))\n"))
+	waitForOutput(t, session, "before-21")
+
+	// The first server goes away, the shell stays
+	if err := first.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown failed: %v", err)
+	}
+	if err := syscall.Kill(pid, 0); err != nil {
+		t.Fatalf("Expected the shell to survive the shutdown: %v", err)
+	}
+	select {
+	case <-session.Done:
+		t.Fatalf("Expected the detached session not to end")
+	default:
+	}
+
+	// The next server takes the session over with its ID, owner and scrollback
+	second := terminal.NewSessionManager()
+	defer second.Close()
+
+	restored, err := second.Restore(opts)
+	if err != nil || len(restored) != 1 {
+		t.Fatalf("Expected one restored session, got %d (%v)", len(restored), err)
+	}
+	resumed, ok := second.Get(session.ID)
+	if !ok {
+		t.Fatalf("Expected session %s to be restored under its ID", session.ID)
+	}
+	if info := resumed.Info(); info.PID != pid || info.Principal == nil || info.Principal.Name != "alice" {
+		t.Errorf("Unexpected restored session: %+v", info)
+	}
+	waitForOutput(t, resumed, "before-21")
+
+	resumed.Backend.Write([]byte("echo after-$((40+2))\n"))
+	waitForOutput(t, resumed, "after-42")
+
+	// The recording continues in the same file, with event times carrying on
+	var last float64
+	recordingDeadline := time.Now().Add(5 * time.Second)
+	for {
+		data, err := os.ReadFile(filepath.Join(opts.RecordingDir, session.ID+".cast"))
+		if err != nil {
+			t.Fatalf("Failed to read recording: %v", err)
+		}
+		if strings.Contains(string(data), "after-42") {
+			lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+			for _, line := range lines[1:] {
+				var event []interface{}
+				if err := json.Unmarshal([]byte(line), &event); err != nil {
+					t.Fatalf("Invalid event %q in recording: %v", line, err)
+				}
+				elapsed := event[0].(float64)
+				if elapsed < last {
+					t.Fatalf("Event times go backwards in recording: %q", data)
+				}
+				last = elapsed
+			}
+			if !strings.Contains(string(data), "before-21") || strings.Count(string(data), `"version"`) != 1 {
+				t.Fatalf("Expected a single recording of both servers, got %q", data)
+			}
+			break
+		}
+		if time.Now().After(recordingDeadline) {
+			t.Fatalf("Output after the restart was not recorded: %q", data)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	// Ending the session releases the holder
+	second.Terminate(session.ID)
+	if err := syscall.Kill(pid, 0); err == nil {
+		t.Errorf("Expected the shell to be gone after the session was terminated")
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		sockets, _ := filepath.Glob(filepath.Join(opts.PersistDir, "*.sock"))
+		if len(sockets) == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the holder to remove its socket, found %v", sockets)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if _, err := os.Stat(opts.PersistDir); err != nil {
+		t.Errorf("Expected the persistence directory to remain: %v", err)
+	}
+}
+
+func TestHolderEndsWithShell(t *testing.T) {
+	t.Parallel()
+
+	opts := testOptions()
+	opts.PersistDir = t.TempDir()
+
+	manager := terminal.NewSessionManager()
+	session, err := manager.New(opts)
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+	if err := manager.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown failed: %v", err)
+	}
+
+	// The holder notices the terminal hang up once the shell is gone
+	syscall.Kill(session.Info().PID, syscall.SIGKILL)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		sockets, _ := filepath.Glob(filepath.Join(opts.PersistDir, "*.sock"))
+		if len(sockets) == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the holder to exit with the shell, found %v", sockets)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
@@ -0,0 +1,199 @@
+package terminal
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+)
+
+// Reasons a session ended
+const (
+	EndReasonExited     = "exited"     // The shell exited on its own
+	EndReasonTerminated = "terminated" // The session was terminated explicitly
+	EndReasonExpired    = "expired"    // The session was idle for longer than its timeout
+	EndReasonShutdown   = "shutdown"   // The server or session manager shut down
+)
+
+// Default grace periods for the processes of a closing session, see TerminalOptions
+const (
+	DefaultHangupGrace    = 2 * time.Second
+	DefaultTerminateGrace = 2 * time.Second
+)
+
+// processExitTimeout is how long killed processes get to disappear before they are
+// reported as left running
+const processExitTimeout = 2 * time.Second
+
+// DefaultEndedSessionRetention is how long a manager remembers why a session
+// ended, so that clients reconnecting shortly afterwards can be told
+const DefaultEndedSessionRetention = 2 * time.Minute
+
+// SessionEnd describes why and how a session ended
+type SessionEnd struct {
+	Reason       string         `json:"reason"`                  // One of the EndReason constants
+	ExitCode     int            `json:"exit_code"`               // Exit code of the shell, -1 if it was killed by a signal or is unknown
+	Signal       string         `json:"signal,omitempty"`        // Signal that killed the shell, if any
+	TerminatedBy string         `json:"terminated_by,omitempty"` // Who terminated the session, for EndReasonTerminated
+	Usage        *ResourceUsage `json:"usage,omitempty"`         // Resources used by the shell, if it was reaped
+	EndedAt      time.Time      `json:"ended_at"`
+}
+
+// ResourceUsage describes the resources used by a finished shell process
+type ResourceUsage struct {
+	UserCPUSeconds   float64 `json:"user_cpu_seconds"`
+	SystemCPUSeconds float64 `json:"system_cpu_seconds"`
+	MaxRSSBytes      int64   `json:"max_rss_bytes"` // Peak resident set size
+}
+
+// String returns a short human readable summary of the resource usage
+func (u ResourceUsage) String() string {
+	return fmt.Sprintf("user %.2fs, system %.2fs, max RSS %.1f MiB",
+		u.UserCPUSeconds, u.SystemCPUSeconds, float64(u.MaxRSSBytes)/(1024*1024))
+}
+
+// Describe returns a human readable description of how the session ended
+func (e SessionEnd) Describe() string {
+	var description string
+	switch e.Reason {
+	case EndReasonTerminated:
+		description = "Session terminated"
+		if e.TerminatedBy != "" {
+			description += " by " + e.TerminatedBy
+		}
+	case EndReasonExpired:
+		description = "Session expired after being idle"
+	case EndReasonShutdown:
+		description = "Server is shutting down"
+	default:
+		if e.Signal != "" {
+			return fmt.Sprintf("Shell process was killed by %s", e.Signal)
+		}
+		if e.ExitCode < 0 {
+			return "Shell process exited"
+		}
+		return fmt.Sprintf("Shell process exited with code %d", e.ExitCode)
+	}
+	return description
+}
+
+// sessionEndedResponse returns the control message announcing how a session ended
+func sessionEndedResponse(sessionID string, end SessionEnd) Response {
+	return Response{
+		Type:      "session_ended",
+		Success:   false,
+		Message:   end.Describe(),
+		SessionID: sessionID,
+		End:       &end,
+	}
+}
+
+// durationOrDefault returns d, or def if d is not set
+func durationOrDefault(d, def time.Duration) time.Duration {
+	if d <= 0 {
+		return def
+	}
+	return d
+}
+
+// End returns how the session ended, or nil while it is still running
+func (session *TerminalSession) End() *SessionEnd {
+	session.Lock.Lock()
+	defer session.Lock.Unlock()
+
+	if session.end == nil {
+		return nil
+	}
+	end := *session.end
+	return &end
+}
+
+// exitStatus returns the exit code of a finished process and the name of the
+// signal that killed it, if any
+func exitStatus(state *os.ProcessState) (int, string) {
+	if state == nil {
+		return -1, ""
+	}
+	if status, ok := state.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return -1, signalName(status.Signal())
+	}
+	return state.ExitCode(), ""
+}
+
+// signalNames maps the signals that commonly end shells to their names
+var signalNames = map[syscall.Signal]string{
+	syscall.SIGHUP:  "SIGHUP",
+	syscall.SIGINT:  "SIGINT",
+	syscall.SIGQUIT: "SIGQUIT",
+	syscall.SIGABRT: "SIGABRT",
+	syscall.SIGKILL: "SIGKILL",
+	syscall.SIGSEGV: "SIGSEGV",
+	syscall.SIGPIPE: "SIGPIPE",
+	syscall.SIGTERM: "SIGTERM",
+}
+
+// signalName returns the conventional name of a signal
+func signalName(sig syscall.Signal) string {
+	if name, ok := signalNames[sig]; ok {
+		return name
+	}
+	return fmt.Sprintf("signal %d", int(sig))
+}
+
+// endedSession is a recently ended session remembered by its manager
+type endedSession struct {
+	info    SessionInfo // Final information about the session, with End set
+	expires time.Time
+}
+
+// Ended returns how a recently ended session ended, if the manager still remembers it
+func (m *SessionManager) Ended(sessionID string) (*SessionEnd, bool) {
+	info, exists := m.EndedInfo(sessionID)
+	if !exists {
+		return nil, false
+	}
+	return info.End, true
+}
+
+// EndedInfo returns the final information about a recently ended session,
+// including its exit status and resource usage, if the manager still remembers it
+func (m *SessionManager) EndedInfo(sessionID string) (SessionInfo, bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	ended, exists := m.ended[sessionID]
+	if !exists || time.Now().After(ended.expires) {
+		return SessionInfo{}, false
+	}
+	return ended.info, true
+}
+
+// rememberEnded records how a session ended for late reconnects
+func (m *SessionManager) rememberEnded(session *TerminalSession) {
+	info := session.Info()
+	if info.End == nil {
+		return
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if m.ended == nil {
+		m.ended = make(map[string]endedSession)
+	}
+	m.ended[session.ID] = endedSession{info: info, expires: time.Now().Add(m.endedRetention)}
+}
+
+// pruneEnded forgets ended sessions whose retention period is over
+func (m *SessionManager) pruneEnded() {
+	now := time.Now()
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	for id, ended := range m.ended {
+		if now.After(ended.expires) {
+			delete(m.ended, id)
+		}
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package terminal
+
+import "os"
+
+// resourceUsage returns nil, the peak memory of processes is only known on Unix
+func resourceUsage(state *os.ProcessState) *ResourceUsage {
+	return nil
+}
@@ -0,0 +1,79 @@
+package terminal_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dansun78/go-remote-term/pkg/terminal"
+	"github.com/gorilla/websocket"
+)
+
+func TestSessionEndedWithExitCode(t *testing.T) {
+	t.Parallel()
+
+	manager := terminal.NewSessionManager()
+	defer manager.Close()
+
+	opts := testOptions()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		terminal.HandleWebSocketWithOptions(w, r, manager, opts)
+	}))
+	defer server.Close()
+
+	conn, resp := dialTerminal(t, server, terminal.Message{Type: "auth", Token: "token"})
+	defer conn.Close()
+	if !resp.Success {
+		t.Fatalf("Unexpected auth response: %+v", resp)
+	}
+	sessionID := resp.SessionID
+
+	conn.WriteMessage(websocket.TextMessage, []byte("exit 3\n"))
+
+	// The end of the session arrives as a control message rather than as output
+	var ended terminal.Response
+	readUntil(t, conn, func(message []byte) bool {
+		return json.Unmarshal(message, &ended) == nil && ended.Type == "session_ended"
+	})
+	if ended.SessionID != sessionID || ended.End == nil {
+		t.Fatalf("Unexpected session_ended message: %+v", ended)
+	}
+	if ended.End.Reason != terminal.EndReasonExited || ended.End.ExitCode != 3 {
+		t.Errorf("Expected the shell to exit with code 3, got %+v", *ended.End)
+	}
+
+	// A client reconnecting shortly afterwards is told how the session ended
+	late, resp := dialTerminal(t, server, terminal.Message{Type: "auth", Token: "token", SessionID: sessionID})
+	defer late.Close()
+	if resp.Type != "session_ended" || resp.End == nil || resp.End.ExitCode != 3 {
+		t.Errorf("Expected a session_ended response on late reconnect, got %+v", resp)
+	}
+}
+
+func TestTerminateReportsTerminatedBy(t *testing.T) {
+	t.Parallel()
+
+	manager := terminal.NewSessionManager()
+	defer manager.Close()
+
+	session, err := manager.New(testOptions())
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+
+	if !manager.TerminateBy(session.ID, "alice") {
+		t.Fatalf("Expected session to be terminated")
+	}
+
+	end, ok := manager.Ended(session.ID)
+	if !ok {
+		t.Fatalf("Expected the manager to remember the ended session")
+	}
+	if end.Reason != terminal.EndReasonTerminated || end.TerminatedBy != "alice" {
+		t.Errorf("Unexpected session end: %+v", *end)
+	}
+	if end.Signal != "SIGHUP" {
+		t.Errorf("Expected the shell to be hung up, got %+v", *end)
+	}
+}
@@ -0,0 +1,32 @@
+//go:build unix
+
+package terminal
+
+import (
+	"os"
+	"runtime"
+	"syscall"
+)
+
+// resourceUsage returns the resources used by a finished process, or nil if they are unknown
+func resourceUsage(state *os.ProcessState) *ResourceUsage {
+	if state == nil {
+		return nil
+	}
+	rusage, ok := state.SysUsage().(*syscall.Rusage)
+	if !ok {
+		return nil
+	}
+
+	// ru_maxrss is reported in kilobytes, except on macOS where it is in bytes
+	maxRSS := int64(rusage.Maxrss)
+	if runtime.GOOS != "darwin" {
+		maxRSS *= 1024
+	}
+
+	return &ResourceUsage{
+		UserCPUSeconds:   state.UserTime().Seconds(),
+		SystemCPUSeconds: state.SystemTime().Seconds(),
+		MaxRSSBytes:      maxRSS,
+	}
+}
@@ -0,0 +1,50 @@
+package terminal
+
+import "time"
+
+// ResourceLimits are rlimits applied to the shell of every session and inherited by
+// the commands started from it. Zero fields leave the limit inherited from the server.
+// The shell is stopped right after it starts until the limits are in place.
+type ResourceLimits struct {
+	CPUSeconds        uint64 // RLIMIT_CPU: CPU time after which the process is killed with SIGXCPU
+	OpenFiles         uint64 // RLIMIT_NOFILE: open file descriptors per process
+	Processes         uint64 // RLIMIT_NPROC: processes of the shell's user; not enforced for root
+	CoreBytes         uint64 // RLIMIT_CORE: size of core dumps
+	DisableCoreDumps  bool   // Sets RLIMIT_CORE to zero, overriding CoreBytes
+	AddressSpaceBytes uint64 // RLIMIT_AS: virtual memory per process
+}
+
+// CgroupLimits places the process tree of every session in its own cgroup v2, which
+// bounds the whole tree rather than single processes and lets every process of the
+// session be killed when it ends. Zero fields leave the resource unlimited.
+type CgroupLimits struct {
+	// Parent is an existing cgroup v2 directory, writable by the server and holding no
+	// processes itself, under which a cgroup named after each session is created,
+	// e.g. /sys/fs/cgroup/go-remote-term
+	Parent string
+
+	MemoryBytes int64   // memory.max: memory of the whole session, beyond which it is reclaimed or OOM-killed
+	CPUs        float64 // cpu.max: CPU time of the whole session in CPUs, e.g. 0.5 for half a CPU
+	Pids        int64   // pids.max: number of processes and threads in the session
+}
+
+// cgroupCPUPeriod is the period cpu.max quotas are expressed in
+const cgroupCPUPeriod = 100 * time.Millisecond
+
+// cgroupRemoveTimeout is how long the processes of an ending session's cgroup get to die
+const cgroupRemoveTimeout = 2 * time.Second
+
+// controllers returns the cgroup controllers needed to enforce the limits
+func (l *CgroupLimits) controllers() []string {
+	var controllers []string
+	if l.MemoryBytes > 0 {
+		controllers = append(controllers, "memory")
+	}
+	if l.CPUs > 0 {
+		controllers = append(controllers, "cpu")
+	}
+	if l.Pids > 0 {
+		controllers = append(controllers, "pids")
+	}
+	return controllers
+}
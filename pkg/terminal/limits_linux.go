@@ -0,0 +1,172 @@
+package terminal
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/creack/pty"
+	"golang.org/x/sys/unix"
+)
+
+// startWithLimits starts cmd with a pty like pty.Start, applying the limits before
+// the command runs any code
+func startWithLimits(cmd *exec.Cmd, limits ResourceLimits) (*os.File, error) {
+	if limits == (ResourceLimits{}) {
+		return pty.Start(cmd)
+	}
+
+	// The command is started traced so that it stops right after exec; only the
+	// thread that started it may detach from it again
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Ptrace = true
+
+	ptmx, err := pty.Start(cmd)
+	if err != nil {
+		return nil, err
+	}
+	pid := cmd.Process.Pid
+
+	var status syscall.WaitStatus
+	if _, err = syscall.Wait4(pid, &status, 0, nil); err == nil && !status.Stopped() {
+		err = fmt.Errorf("process did not stop after exec: %v", status)
+	}
+	if err == nil {
+		err = limits.apply(pid)
+		if detachErr := syscall.PtraceDetach(pid); err == nil && detachErr != nil {
+			err = fmt.Errorf("failed to resume process: %v", detachErr)
+		}
+	}
+	if err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		ptmx.Close()
+		return nil, fmt.Errorf("failed to apply resource limits: %v", err)
+	}
+	return ptmx, nil
+}
+
+// apply sets the limits on a running process
+func (l ResourceLimits) apply(pid int) error {
+	limits := []struct {
+		resource int
+		name     string
+		value    uint64
+	}{
+		{unix.RLIMIT_CPU, "CPU time", l.CPUSeconds},
+		{unix.RLIMIT_NOFILE, "open files", l.OpenFiles},
+		{unix.RLIMIT_NPROC, "processes", l.Processes},
+		{unix.RLIMIT_CORE, "core size", l.CoreBytes},
+		{unix.RLIMIT_AS, "address space", l.AddressSpaceBytes},
+	}
+
+	for _, limit := range limits {
+		value := limit.value
+		if limit.resource == unix.RLIMIT_CORE && l.DisableCoreDumps {
+			value = 0
+		} else if value == 0 {
+			continue
+		}
+
+		// Lower the hard limit too, so the shell cannot raise the limit again
+		rlimit := unix.Rlimit{Cur: value, Max: value}
+		if err := unix.Prlimit(pid, limit.resource, &rlimit, nil); err != nil {
+			return fmt.Errorf("failed to limit %s: %v", limit.name, err)
+		}
+	}
+	return nil
+}
+
+// sessionCgroup is the cgroup v2 holding the process tree of a session
+type sessionCgroup struct {
+	path string
+}
+
+// newSessionCgroup creates the cgroup of a session and sets its limits
+func newSessionCgroup(limits *CgroupLimits, sessionID string) (*sessionCgroup, error) {
+	// Child cgroups only get the files of controllers enabled in their parent
+	if controllers := limits.controllers(); len(controllers) > 0 {
+		enable := "+" + strings.Join(controllers, " +")
+		if err := writeCgroupFile(limits.Parent, "cgroup.subtree_control", enable); err != nil {
+			return nil, fmt.Errorf("failed to enable cgroup controllers: %v", err)
+		}
+	}
+
+	cgroup := &sessionCgroup{path: filepath.Join(limits.Parent, sessionID)}
+	if err := os.Mkdir(cgroup.path, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create cgroup: %v", err)
+	}
+
+	var settings [][2]string
+	if limits.MemoryBytes > 0 {
+		settings = append(settings, [2]string{"memory.max", strconv.FormatInt(limits.MemoryBytes, 10)})
+	}
+	if limits.CPUs > 0 {
+		period := cgroupCPUPeriod.Microseconds()
+		quota := int64(limits.CPUs * float64(period))
+		settings = append(settings, [2]string{"cpu.max", fmt.Sprintf("%d %d", quota, period)})
+	}
+	if limits.Pids > 0 {
+		settings = append(settings, [2]string{"pids.max", strconv.FormatInt(limits.Pids, 10)})
+	}
+
+	for _, setting := range settings {
+		if err := writeCgroupFile(cgroup.path, setting[0], setting[1]); err != nil {
+			os.Remove(cgroup.path)
+			return nil, fmt.Errorf("failed to set %s: %v", setting[0], err)
+		}
+	}
+	return cgroup, nil
+}
+
+// attach makes cmd start inside the cgroup. The returned file must be closed once cmd has started.
+func (c *sessionCgroup) attach(cmd *exec.Cmd) (*os.File, error) {
+	dir, err := os.Open(c.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open cgroup: %v", err)
+	}
+
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.UseCgroupFD = true
+	cmd.SysProcAttr.CgroupFD = int(dir.Fd())
+	return dir, nil
+}
+
+// remove kills every process left in the cgroup and removes it
+func (c *sessionCgroup) remove() error {
+	if err := writeCgroupFile(c.path, "cgroup.kill", "1"); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to kill cgroup processes: %v", err)
+	}
+
+	// The cgroup can only be removed once the killed processes are gone
+	deadline := time.Now().Add(cgroupRemoveTimeout)
+	for {
+		err := os.Remove(c.path)
+		if err == nil || errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		if !errors.Is(err, syscall.EBUSY) || time.Now().After(deadline) {
+			return fmt.Errorf("failed to remove cgroup: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// writeCgroupFile writes a value to a cgroup interface file
+func writeCgroupFile(dir, name, value string) error {
+	return os.WriteFile(filepath.Join(dir, name), []byte(value), 0644)
+}
@@ -0,0 +1,102 @@
+package terminal_test
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dansun78/go-remote-term/pkg/terminal"
+)
+
+func TestSessionResourceLimits(t *testing.T) {
+	t.Parallel()
+
+	manager := terminal.NewSessionManager()
+	defer manager.Close()
+
+	opts := testOptions()
+	opts.Limits = terminal.ResourceLimits{OpenFiles: 64, DisableCoreDumps: true}
+	opts.Profiles = []terminal.Profile{{
+		Name:    "ulimit",
+		Command: []string{"/bin/sh", "-c", `echo "files=$(ulimit -n) core=$(ulimit -c)"`},
+	}}
+
+	session, err := manager.NewWithProfile(opts, nil, "ulimit")
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+
+	select {
+	case <-session.Done:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("Timed out waiting for the command to exit")
+	}
+
+	if output := string(session.BufferedOutput()); !strings.Contains(output, "files=64 core=0") {
+		t.Errorf("Expected limits to apply to the shell, got %q", output)
+	}
+}
+
+// cgroup2Mount returns where the cgroup v2 hierarchy is mounted, or "" if it is not
+func cgroup2Mount() string {
+	file, err := os.Open("/proc/self/mounts")
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 3 && fields[2] == "cgroup2" {
+			return fields[1]
+		}
+	}
+	return ""
+}
+
+func TestSessionCgroup(t *testing.T) {
+	mount := cgroup2Mount()
+	if mount == "" || os.Geteuid() != 0 {
+		t.Skip("Requires root and a cgroup v2 hierarchy")
+	}
+	parent, err := os.MkdirTemp(mount, "go-remote-term-test-")
+	if err != nil {
+		t.Skipf("Cannot create cgroups: %v", err)
+	}
+	defer os.Remove(parent)
+
+	manager := terminal.NewSessionManager()
+	defer manager.Close()
+
+	opts := testOptions()
+	opts.Cgroup = &terminal.CgroupLimits{Parent: parent}
+
+	session, err := manager.New(opts)
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+
+	// The shell runs inside the session's cgroup
+	cgroup := filepath.Join(parent, session.ID)
+	procs, err := os.ReadFile(filepath.Join(cgroup, "cgroup.procs"))
+	if err != nil {
+		t.Fatalf("Failed to read cgroup processes: %v", err)
+	}
+	if !strings.Contains(string(procs), strconv.Itoa(session.Info().PID)) {
+		t.Errorf("Expected shell %d in cgroup, got %q", session.Info().PID, procs)
+	}
+
+	// Processes left behind by the shell are killed with the session
+	session.Backend.Write([]byte("nohup sleep 1000 >/dev/null 2>&1 &\n"))
+	time.Sleep(200 * time.Millisecond)
+
+	manager.Terminate(session.ID)
+	if _, err := os.Stat(cgroup); !os.IsNotExist(err) {
+		t.Errorf("Expected the session's cgroup to be removed, got %v", err)
+	}
+}
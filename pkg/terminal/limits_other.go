@@ -0,0 +1,43 @@
+//go:build !linux
+
+package terminal
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+
+	"github.com/creack/pty"
+)
+
+// errLimitsUnsupported is returned when limits are configured on a platform that cannot enforce them
+var errLimitsUnsupported = errors.New("resource limits and cgroups are only supported on Linux")
+
+// startWithLimits starts cmd with a pty like pty.Start, applying the limits before
+// the command runs any code
+func startWithLimits(cmd *exec.Cmd, limits ResourceLimits) (*os.File, error) {
+	if limits != (ResourceLimits{}) {
+		return nil, errLimitsUnsupported
+	}
+	return pty.Start(cmd)
+}
+
+// sessionCgroup is the cgroup v2 holding the process tree of a session
+type sessionCgroup struct {
+	path string
+}
+
+// newSessionCgroup creates the cgroup of a session and sets its limits
+func newSessionCgroup(limits *CgroupLimits, sessionID string) (*sessionCgroup, error) {
+	return nil, errLimitsUnsupported
+}
+
+// attach makes cmd start inside the cgroup. The returned file must be closed once cmd has started.
+func (c *sessionCgroup) attach(cmd *exec.Cmd) (*os.File, error) {
+	return nil, errLimitsUnsupported
+}
+
+// remove kills every process left in the cgroup and removes it
+func (c *sessionCgroup) remove() error {
+	return nil
+}
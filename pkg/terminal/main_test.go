@@ -0,0 +1,14 @@
+package terminal_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/dansun78/go-remote-term/pkg/terminal"
+)
+
+// TestMain lets the test binary double as the sandbox init and PTY holder helpers
+func TestMain(m *testing.M) {
+	terminal.Init()
+	os.Exit(m.Run())
+}
@@ -0,0 +1,486 @@
+package terminal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrManagerClosed is returned when a session is requested from a closed manager
+var ErrManagerClosed = errors.New("session manager is closed")
+
+// SessionManager owns a set of terminal sessions together with the background
+// routine that reaps expired ones. Managers are independent of each other, so an
+// application can serve several terminal endpoints with different options side by side.
+type SessionManager struct {
+	sessions map[string]*TerminalSession
+	shares   map[string]*Share       // Share links by token
+	ended    map[string]endedSession // Recently ended sessions by ID, for late reconnects
+	lock     sync.Mutex
+
+	// serialLock serializes opening serial ports, so that a port never has two sessions
+	serialLock sync.Mutex
+
+	// endedRetention controls how long ended sessions are remembered (default: DefaultEndedSessionRetention)
+	endedRetention time.Duration
+
+	// cleanupInterval controls how often expired sessions are reaped (default: 1 minute)
+	cleanupInterval time.Duration
+
+	metrics *sessionMetrics
+
+	closed    bool
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewSessionManager creates a session manager and starts its cleanup routine
+func NewSessionManager() *SessionManager {
+	m := &SessionManager{
+		sessions:        make(map[string]*TerminalSession),
+		shares:          make(map[string]*Share),
+		ended:           make(map[string]endedSession),
+		cleanupInterval: 1 * time.Minute,
+		endedRetention:  DefaultEndedSessionRetention,
+		metrics:         newMetrics(),
+		done:            make(chan struct{}),
+	}
+
+	go m.reapExpiredSessions()
+
+	return m
+}
+
+// Default session manager used by HandleWebSocket
+var (
+	defaultManager     *SessionManager
+	defaultManagerOnce sync.Once
+)
+
+// DefaultSessionManager returns the package-level manager used by HandleWebSocket.
+// It is created on first use; applications embedding the package should prefer
+// creating their own manager with NewSessionManager.
+func DefaultSessionManager() *SessionManager {
+	defaultManagerOnce.Do(func() {
+		defaultManager = NewSessionManager()
+	})
+	return defaultManager
+}
+
+// New starts a new terminal session with the given options and registers it with the manager
+func (m *SessionManager) New(options *TerminalOptions) (*TerminalSession, error) {
+	return m.NewWithPrincipal(options, nil)
+}
+
+// NewWithPrincipal starts a new terminal session owned by principal and registers it with the manager
+func (m *SessionManager) NewWithPrincipal(options *TerminalOptions, principal *Principal) (*TerminalSession, error) {
+	return m.NewWithProfile(options, principal, "")
+}
+
+// NewWithProfile starts a new terminal session owned by principal running the named
+// profile from options.Profiles, or the shell if profile is empty, and registers it with the manager
+func (m *SessionManager) NewWithProfile(options *TerminalOptions, principal *Principal, profile string) (*TerminalSession, error) {
+	selected, err := lookupProfile(options, profile)
+	if err != nil {
+		return nil, err
+	}
+	osUser, err := resolveOSUser(options, principal)
+	if err != nil {
+		return nil, err
+	}
+
+	if m.isClosed() {
+		return nil, ErrManagerClosed
+	}
+
+	session, err := createNewSession(options, sessionSpec{profile: selected, user: osUser})
+	if err != nil {
+		return nil, err
+	}
+	return m.start(session, principal)
+}
+
+// NewOnTarget starts a new session owned by principal running a shell on the named SSH
+// target from options.Targets, or a local shell if target is empty, and registers it with the manager
+func (m *SessionManager) NewOnTarget(options *TerminalOptions, principal *Principal, target string) (*TerminalSession, error) {
+	selected, err := lookupTarget(options, target)
+	if err != nil {
+		return nil, err
+	}
+	if selected == nil {
+		return m.NewWithPrincipal(options, principal)
+	}
+
+	if m.isClosed() {
+		return nil, ErrManagerClosed
+	}
+
+	session, err := createBackendSession(selected.sessionOptions(options), newSSHBackend(selected, options.Environment))
+	if err != nil {
+		return nil, err
+	}
+	session.Target = selected.Name
+	return m.start(session, principal)
+}
+
+// NewInContainer starts a new session owned by principal running a shell in the running
+// container with the given ID or name, which must match the labels of options.Docker, and
+// registers it with the manager
+func (m *SessionManager) NewInContainer(options *TerminalOptions, principal *Principal, container string) (*TerminalSession, error) {
+	if options.Docker == nil {
+		return nil, fmt.Errorf("%w: %q, containers are not enabled", ErrUnknownContainer, container)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), dockerTimeout)
+	selected, err := options.Docker.lookup(ctx, container)
+	cancel()
+	if err != nil {
+		return nil, err
+	}
+	if m.isClosed() {
+		return nil, ErrManagerClosed
+	}
+
+	backend := newDockerBackend(options.Docker, selected.ID, options.Environment)
+	session, err := createBackendSession(options.Docker.sessionOptions(options, selected), backend)
+	if err != nil {
+		return nil, err
+	}
+	session.Container = selected.Name
+	return m.start(session, principal)
+}
+
+// OpenSerialPort returns the session attached to the named serial port from
+// options.SerialPorts, starting one owned by principal if the port has none, and reports
+// whether it started one. Principals that may not create sessions get ErrCreateDenied
+// when the port has none. Callers must check that principal may use an existing session.
+func (m *SessionManager) OpenSerialPort(options *TerminalOptions, principal *Principal, port string) (*TerminalSession, bool, error) {
+	selected, err := lookupSerialPort(options, port)
+	if err != nil {
+		return nil, false, err
+	}
+
+	m.serialLock.Lock()
+	defer m.serialLock.Unlock()
+
+	for _, session := range m.List() {
+		if session.SerialPort == selected.Name && session.End() == nil {
+			return session, false, nil
+		}
+	}
+	if !principal.canCreateSessions() {
+		return nil, false, ErrCreateDenied
+	}
+	if m.isClosed() {
+		return nil, false, ErrManagerClosed
+	}
+
+	session, err := createBackendSession(selected.sessionOptions(options), newSerialBackend(selected))
+	if err != nil {
+		return nil, false, err
+	}
+	session.SerialPort = selected.Name
+	session.exclusive = selected.Exclusive
+	if _, err := m.start(session, principal); err != nil {
+		return nil, false, err
+	}
+	return session, true, nil
+}
+
+// NewWithBackend starts a new terminal session owned by principal on the terminal of
+// backend instead of a local PTY, and registers it with the manager. The backend is
+// started with the initial size from options and closed when the session ends.
+func (m *SessionManager) NewWithBackend(options *TerminalOptions, principal *Principal, backend Backend) (*TerminalSession, error) {
+	if m.isClosed() {
+		return nil, ErrManagerClosed
+	}
+
+	session, err := createBackendSession(options, backend)
+	if err != nil {
+		return nil, err
+	}
+	return m.start(session, principal)
+}
+
+// isClosed reports whether the manager no longer accepts new sessions
+func (m *SessionManager) isClosed() bool {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	return m.closed
+}
+
+// start registers a newly created session owned by principal and starts reading its output
+func (m *SessionManager) start(session *TerminalSession, principal *Principal) (*TerminalSession, error) {
+	session.Principal = principal
+
+	if err := m.register(session); err != nil {
+		// The manager was closed while the shell was starting
+		session.close(SessionEnd{Reason: EndReasonShutdown})
+		return nil, err
+	}
+
+	m.metrics.sessionCreated()
+
+	// Let the holder restore the session even if this server dies without detaching it
+	if session.holder != nil {
+		if err := session.holder.save(session.persistedState()); err != nil {
+			log.Printf("Failed to save session %s to its holder: %v", session.ID, err)
+		}
+	}
+
+	// Start output buffer routine
+	go bufferTerminalOutput(session)
+
+	return session, nil
+}
+
+// register adds a session to the manager unless the manager is closed
+func (m *SessionManager) register(session *TerminalSession) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if m.closed {
+		return ErrManagerClosed
+	}
+	session.manager = m
+	m.sessions[session.ID] = session
+	return nil
+}
+
+// Get returns the session with the given ID, if it exists
+func (m *SessionManager) Get(sessionID string) (*TerminalSession, bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	session, exists := m.sessions[sessionID]
+	return session, exists
+}
+
+// List returns all sessions owned by the manager, oldest first
+func (m *SessionManager) List() []*TerminalSession {
+	m.lock.Lock()
+	list := make([]*TerminalSession, 0, len(m.sessions))
+	for _, session := range m.sessions {
+		list = append(list, session)
+	}
+	m.lock.Unlock()
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].CreatedAt.Before(list[j].CreatedAt)
+	})
+	return list
+}
+
+// Terminate explicitly terminates a session by ID
+// Returns false if the session does not exist
+func (m *SessionManager) Terminate(sessionID string) bool {
+	return m.TerminateBy(sessionID, "")
+}
+
+// TerminateBy explicitly terminates a session by ID on behalf of by, which is
+// reported to attached clients in the session_ended message
+// Returns false if the session does not exist
+func (m *SessionManager) TerminateBy(sessionID, by string) bool {
+	if sessionID == "" {
+		return false
+	}
+
+	if _, exists := m.Get(sessionID); !exists {
+		return false
+	}
+
+	log.Printf("Explicitly terminating session %s at user request", sessionID)
+	m.closeSession(sessionID, SessionEnd{Reason: EndReasonTerminated, TerminatedBy: by})
+	return true
+}
+
+// Close stops the cleanup routine and terminates every session owned by the manager.
+// The manager cannot be used to create new sessions afterwards.
+func (m *SessionManager) Close() {
+	m.closeOnce.Do(func() {
+		close(m.done)
+
+		m.lock.Lock()
+		m.closed = true
+		sessions := m.sessions
+		m.sessions = make(map[string]*TerminalSession)
+		m.shares = make(map[string]*Share)
+		m.lock.Unlock()
+
+		var wg sync.WaitGroup
+		for _, session := range sessions {
+			wg.Add(1)
+			go func(session *TerminalSession) {
+				defer wg.Done()
+				session.close(SessionEnd{Reason: EndReasonShutdown})
+				m.metrics.sessionTerminated()
+			}(session)
+		}
+		wg.Wait()
+	})
+}
+
+// Shutdown gracefully shuts the manager down. It stops accepting new sessions,
+// sends a server_shutdown control message to every attached client, hangs up
+// every shell and then waits for clients to detach until ctx is done. Sessions
+// with a PTY holder (see TerminalOptions.PersistDir) are handed over to it
+// instead, and their clients get a server_restart control message.
+// The manager is closed when Shutdown returns; ctx's error is returned if
+// clients were still attached when it expired.
+func (m *SessionManager) Shutdown(ctx context.Context) error {
+	m.lock.Lock()
+	m.closed = true
+	sessions := make([]*TerminalSession, 0, len(m.sessions))
+	for _, session := range m.sessions {
+		sessions = append(sessions, session)
+	}
+	m.lock.Unlock()
+
+	log.Printf("Shutting down %d terminal sessions", len(sessions))
+
+	// Tell every client why it is about to be disconnected; clients of persistent
+	// sessions are told the server is restarting when their session is detached
+	for _, session := range sessions {
+		if session.holder != nil {
+			continue
+		}
+		session.broadcastControl(Response{
+			Type:      "server_shutdown",
+			Success:   true,
+			Message:   "Server is shutting down",
+			SessionID: session.ID,
+		})
+	}
+
+	// Hang up the shells, or hand persistent sessions over to their holders; each
+	// connection flushes its queued messages and closes
+	var wg sync.WaitGroup
+	for _, session := range sessions {
+		wg.Add(1)
+		go func(session *TerminalSession) {
+			defer wg.Done()
+			if session.holder != nil {
+				m.detachSession(session)
+			} else {
+				m.closeSession(session.ID, SessionEnd{Reason: EndReasonShutdown})
+			}
+		}(session)
+	}
+	wg.Wait()
+
+	// Wait for the connections to detach or the grace period to run out
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+
+	defer m.Close()
+	for m.attachedConnections(sessions) > 0 {
+		select {
+		case <-ctx.Done():
+			log.Printf("Shutdown grace period expired with %d connections still attached",
+				m.attachedConnections(sessions))
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+// attachedConnections returns the number of connections attached to the given sessions
+func (m *SessionManager) attachedConnections(sessions []*TerminalSession) int {
+	total := 0
+	for _, session := range sessions {
+		session.Lock.Lock()
+		total += session.Connections
+		session.Lock.Unlock()
+	}
+	return total
+}
+
+// closeSession terminates a session, removes it from the manager and remembers how it ended
+func (m *SessionManager) closeSession(sessionID string, end SessionEnd) {
+	m.lock.Lock()
+	session, exists := m.sessions[sessionID]
+	if exists {
+		delete(m.sessions, sessionID)
+		m.removeSharesLocked(sessionID)
+	}
+	m.lock.Unlock()
+
+	if exists {
+		session.close(end)
+		m.rememberEnded(session)
+		m.metrics.sessionTerminated()
+	}
+}
+
+// detachSession hands a persistent session over to its holder and removes it from the manager
+// If that fails, the session is closed instead.
+func (m *SessionManager) detachSession(session *TerminalSession) {
+	m.lock.Lock()
+	delete(m.sessions, session.ID)
+	m.removeSharesLocked(session.ID)
+	m.lock.Unlock()
+
+	if err := session.detach(); err != nil {
+		log.Printf("Error detaching session: %v", err)
+		session.close(SessionEnd{Reason: EndReasonShutdown})
+		m.metrics.sessionTerminated()
+	}
+}
+
+// reapExpiredSessions periodically removes expired sessions until the manager is closed
+func (m *SessionManager) reapExpiredSessions() {
+	ticker := time.NewTicker(m.cleanupInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.done:
+			return
+		case <-ticker.C:
+			m.cleanupExpiredSessions()
+			m.pruneEnded()
+		}
+	}
+}
+
+// cleanupExpiredSessions removes sessions that have been inactive longer than their timeout
+func (m *SessionManager) cleanupExpiredSessions() {
+	now := time.Now()
+
+	// Collect expired sessions first so that closing them doesn't happen under the manager lock
+	var expired []*TerminalSession
+	m.lock.Lock()
+	for id, session := range m.sessions {
+		session.Lock.Lock()
+		lastActive := session.LastActive
+		connections := session.Connections
+		session.Lock.Unlock()
+
+		// If session has no active connections and has exceeded timeout
+		if connections == 0 && now.Sub(lastActive) > session.Options.SessionTimeout {
+			log.Printf("Cleaning up expired session %s (inactive for %v)", id, now.Sub(lastActive))
+			delete(m.sessions, id)
+			m.removeSharesLocked(id)
+			expired = append(expired, session)
+		}
+	}
+	m.lock.Unlock()
+
+	// Each shell may take a while to exit, so they are hung up in parallel like in Close
+	var wg sync.WaitGroup
+	for _, session := range expired {
+		wg.Add(1)
+		go func(session *TerminalSession) {
+			defer wg.Done()
+			session.close(SessionEnd{Reason: EndReasonExpired})
+			m.rememberEnded(session)
+			m.metrics.sessionExpired()
+		}(session)
+	}
+	wg.Wait()
+}
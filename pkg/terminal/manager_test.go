@@ -0,0 +1,252 @@
+package terminal_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dansun78/go-remote-term/pkg/terminal"
+	"github.com/gorilla/websocket"
+)
+
+// testOptions returns terminal options suitable for tests
+func testOptions() *terminal.TerminalOptions {
+	opts := terminal.DefaultOptions()
+	opts.Shell = "/bin/sh"
+	return opts
+}
+
+// dialTerminal connects to a terminal WebSocket server and sends the given auth message
+func dialTerminal(t *testing.T, server *httptest.Server, auth terminal.Message) (*websocket.Conn, terminal.Response) {
+	t.Helper()
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+
+	if err := conn.WriteJSON(auth); err != nil {
+		t.Fatalf("Failed to send auth message: %v", err)
+	}
+	var resp terminal.Response
+	if err := conn.ReadJSON(&resp); err != nil {
+		t.Fatalf("Failed to read auth response: %v", err)
+	}
+	return conn, resp
+}
+
+// readUntil reads messages from conn until match returns true for one of them
+func readUntil(t *testing.T, conn *websocket.Conn, match func(message []byte) bool) {
+	t.Helper()
+
+	for {
+		_, message, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("Failed to read message: %v", err)
+		}
+		if match(message) {
+			return
+		}
+	}
+}
+
+// waitForOutput waits until the session's buffered output contains want
+func waitForOutput(t *testing.T, session *terminal.TerminalSession, want string) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !strings.Contains(string(session.BufferedOutput()), want) {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %q, got %q", want, session.BufferedOutput())
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestSessionManagerLifecycle(t *testing.T) {
+	t.Parallel()
+
+	manager := terminal.NewSessionManager()
+	defer manager.Close()
+
+	session, err := manager.New(testOptions())
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+
+	// The session should be retrievable by ID
+	if got, ok := manager.Get(session.ID); !ok || got != session {
+		t.Errorf("Expected Get to return the created session")
+	}
+
+	if n := len(manager.List()); n != 1 {
+		t.Errorf("Expected 1 session, got %d", n)
+	}
+
+	// Terminate should remove the session and close its done channel
+	if !manager.Terminate(session.ID) {
+		t.Fatalf("Expected Terminate to succeed")
+	}
+	if _, ok := manager.Get(session.ID); ok {
+		t.Errorf("Expected session to be removed after termination")
+	}
+	select {
+	case <-session.Done:
+	default:
+		t.Errorf("Expected session done channel to be closed")
+	}
+
+	// Terminating twice should report that the session is gone
+	if manager.Terminate(session.ID) {
+		t.Errorf("Expected second Terminate to fail")
+	}
+}
+
+func TestSessionManagersAreIndependent(t *testing.T) {
+	t.Parallel()
+
+	first := terminal.NewSessionManager()
+	defer first.Close()
+	second := terminal.NewSessionManager()
+	defer second.Close()
+
+	session, err := first.New(testOptions())
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+
+	if _, ok := second.Get(session.ID); ok {
+		t.Errorf("Expected session to be invisible to another manager")
+	}
+	if n := len(second.List()); n != 0 {
+		t.Errorf("Expected second manager to have no sessions, got %d", n)
+	}
+}
+
+func TestSessionManagerClose(t *testing.T) {
+	t.Parallel()
+
+	manager := terminal.NewSessionManager()
+
+	session, err := manager.New(testOptions())
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+
+	manager.Close()
+
+	select {
+	case <-session.Done:
+	default:
+		t.Errorf("Expected Close to terminate the session")
+	}
+	if n := len(manager.List()); n != 0 {
+		t.Errorf("Expected no sessions after Close, got %d", n)
+	}
+	if _, err := manager.New(testOptions()); err != terminal.ErrManagerClosed {
+		t.Errorf("Expected ErrManagerClosed, got %v", err)
+	}
+}
+
+func TestSessionManagerShutdown(t *testing.T) {
+	t.Parallel()
+
+	manager := terminal.NewSessionManager()
+	opts := testOptions()
+	terminal.SetAuthToken(opts, "secret")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		terminal.HandleWebSocketWithOptions(w, r, manager, opts)
+	}))
+	defer server.Close()
+
+	conn, resp := dialTerminal(t, server, terminal.Message{Type: "auth", Token: "secret"})
+	defer conn.Close()
+	if !resp.Success {
+		t.Fatalf("Failed to start a session: %+v", resp)
+	}
+	session, _ := manager.Get(resp.SessionID)
+	deadline := time.Now().Add(5 * time.Second)
+	for session.Info().Connections != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("Connection did not attach to the session")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	// Shutdown waits for the connection to detach, which it does once it was told why
+	shutdown := make(chan error, 1)
+	go func() {
+		shutdown <- manager.Shutdown(context.Background())
+	}()
+
+	var controls []terminal.Response
+	for {
+		_, message, err := conn.ReadMessage()
+		if err != nil {
+			break
+		}
+		var control terminal.Response
+		if json.Unmarshal(message, &control) == nil && control.Type != "" && control.Type != "presence" {
+			controls = append(controls, control)
+		}
+	}
+	if len(controls) != 2 || controls[0].Type != "server_shutdown" || controls[1].Type != "session_ended" {
+		t.Fatalf("Expected server_shutdown and then session_ended, got %+v", controls)
+	}
+	if end := controls[1].End; end == nil || end.Reason != terminal.EndReasonShutdown {
+		t.Fatalf("Expected the session to end with the shutdown, got %+v", controls[1])
+	}
+
+	select {
+	case err := <-shutdown:
+		if err != nil {
+			t.Fatalf("Shutdown failed: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Shutdown did not return once the connection detached")
+	}
+	select {
+	case <-session.Done:
+	default:
+		t.Error("Expected Shutdown to close the session")
+	}
+	if n := len(manager.List()); n != 0 {
+		t.Errorf("Expected no sessions after Shutdown, got %d", n)
+	}
+	if _, err := manager.New(testOptions()); err != terminal.ErrManagerClosed {
+		t.Errorf("Expected ErrManagerClosed, got %v", err)
+	}
+}
+
+func TestSessionManagerShutdownGracePeriod(t *testing.T) {
+	t.Parallel()
+
+	manager := terminal.NewSessionManager()
+	session, err := manager.New(testOptions())
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+
+	// A connection that never detaches holds Shutdown up until ctx expires
+	defer terminal.AttachForTest(session)()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := manager.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected the grace period to expire, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("Shutdown returned %v after the grace period expired", elapsed)
+	}
+	if _, err := manager.New(testOptions()); err != terminal.ErrManagerClosed {
+		t.Errorf("Expected ErrManagerClosed, got %v", err)
+	}
+}
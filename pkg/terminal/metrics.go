@@ -0,0 +1,176 @@
+package terminal
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// metricsPrefix is prepended to the name of every exported metric
+const metricsPrefix = "remote_term_"
+
+// connectionDurationBuckets are the upper bounds, in seconds, of the
+// WebSocket connection duration histogram
+var connectionDurationBuckets = []float64{1, 5, 15, 60, 300, 900, 3600, 4 * 3600, 24 * 3600}
+
+// sessionMetrics collects usage statistics for the sessions of a SessionManager.
+// Each manager owns its own collector, so several managers in one process
+// never share counters. A nil *sessionMetrics is valid and records nothing.
+type sessionMetrics struct {
+	sessionsCreated    atomic.Uint64
+	sessionsTerminated atomic.Uint64
+	sessionsExpired    atomic.Uint64
+	bytesIn            atomic.Uint64 // Client input written to terminals
+	bytesOut           atomic.Uint64 // Terminal output read from terminals
+
+	lock                sync.Mutex
+	authFailures        map[string]uint64
+	durationBucketCount []uint64
+	durationCount       uint64
+	durationSum         float64
+}
+
+// newMetrics creates an empty metrics collector
+func newMetrics() *sessionMetrics {
+	return &sessionMetrics{
+		authFailures:        make(map[string]uint64),
+		durationBucketCount: make([]uint64, len(connectionDurationBuckets)),
+	}
+}
+
+// sessionCreated records that a session was started
+func (m *sessionMetrics) sessionCreated() {
+	if m != nil {
+		m.sessionsCreated.Add(1)
+	}
+}
+
+// sessionTerminated records that a session was ended explicitly or because its shell exited
+func (m *sessionMetrics) sessionTerminated() {
+	if m != nil {
+		m.sessionsTerminated.Add(1)
+	}
+}
+
+// sessionExpired records that an idle session was reaped
+func (m *sessionMetrics) sessionExpired() {
+	if m != nil {
+		m.sessionsExpired.Add(1)
+	}
+}
+
+// input records bytes written by clients to a terminal
+func (m *sessionMetrics) input(n int) {
+	if m != nil {
+		m.bytesIn.Add(uint64(n))
+	}
+}
+
+// output records bytes produced by a terminal
+func (m *sessionMetrics) output(n int) {
+	if m != nil {
+		m.bytesOut.Add(uint64(n))
+	}
+}
+
+// authFailure records a failed WebSocket authentication attempt
+func (m *sessionMetrics) authFailure(reason string) {
+	if m == nil {
+		return
+	}
+	m.lock.Lock()
+	m.authFailures[reason]++
+	m.lock.Unlock()
+}
+
+// connectionClosed records the duration of a finished WebSocket connection
+func (m *sessionMetrics) connectionClosed(duration time.Duration) {
+	if m == nil {
+		return
+	}
+
+	seconds := duration.Seconds()
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	for i, bound := range connectionDurationBuckets {
+		if seconds <= bound {
+			m.durationBucketCount[i]++
+		}
+	}
+	m.durationCount++
+	m.durationSum += seconds
+}
+
+// MetricsHandler serves the metrics of a session manager in the Prometheus text exposition format
+func MetricsHandler(manager *SessionManager) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		manager.writeMetrics(w)
+	})
+}
+
+// writeMetrics writes the manager's metrics in the Prometheus text exposition format
+func (manager *SessionManager) writeMetrics(w io.Writer) {
+	m := manager.metrics
+
+	// Gauges are computed from the current sessions at scrape time
+	sessions := manager.List()
+	connections := 0
+	for _, session := range sessions {
+		session.Lock.Lock()
+		connections += session.Connections
+		session.Lock.Unlock()
+	}
+
+	writeMetricHeader(w, "sessions_active", "gauge", "Number of active terminal sessions.")
+	fmt.Fprintf(w, "%ssessions_active %d\n", metricsPrefix, len(sessions))
+
+	writeMetricHeader(w, "connections_attached", "gauge", "Number of WebSocket connections attached to sessions.")
+	fmt.Fprintf(w, "%sconnections_attached %d\n", metricsPrefix, connections)
+
+	writeMetricHeader(w, "sessions_created_total", "counter", "Total number of sessions created.")
+	fmt.Fprintf(w, "%ssessions_created_total %d\n", metricsPrefix, m.sessionsCreated.Load())
+
+	writeMetricHeader(w, "sessions_terminated_total", "counter", "Total number of sessions terminated explicitly or by shell exit.")
+	fmt.Fprintf(w, "%ssessions_terminated_total %d\n", metricsPrefix, m.sessionsTerminated.Load())
+
+	writeMetricHeader(w, "sessions_expired_total", "counter", "Total number of idle sessions reaped after their timeout.")
+	fmt.Fprintf(w, "%ssessions_expired_total %d\n", metricsPrefix, m.sessionsExpired.Load())
+
+	writeMetricHeader(w, "bytes_total", "counter", "Total number of terminal bytes transferred by direction.")
+	fmt.Fprintf(w, "%sbytes_total{direction=\"in\"} %d\n", metricsPrefix, m.bytesIn.Load())
+	fmt.Fprintf(w, "%sbytes_total{direction=\"out\"} %d\n", metricsPrefix, m.bytesOut.Load())
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	writeMetricHeader(w, "auth_failures_total", "counter", "Total number of failed WebSocket authentications by reason.")
+	reasons := make([]string, 0, len(m.authFailures))
+	for reason := range m.authFailures {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+	for _, reason := range reasons {
+		fmt.Fprintf(w, "%sauth_failures_total{reason=%q} %d\n", metricsPrefix, reason, m.authFailures[reason])
+	}
+
+	writeMetricHeader(w, "connection_duration_seconds", "histogram", "Duration of WebSocket connections to sessions.")
+	for i, bound := range connectionDurationBuckets {
+		fmt.Fprintf(w, "%sconnection_duration_seconds_bucket{le=\"%g\"} %d\n", metricsPrefix, bound, m.durationBucketCount[i])
+	}
+	fmt.Fprintf(w, "%sconnection_duration_seconds_bucket{le=\"+Inf\"} %d\n", metricsPrefix, m.durationCount)
+	fmt.Fprintf(w, "%sconnection_duration_seconds_sum %g\n", metricsPrefix, m.durationSum)
+	fmt.Fprintf(w, "%sconnection_duration_seconds_count %d\n", metricsPrefix, m.durationCount)
+}
+
+// writeMetricHeader writes the HELP and TYPE lines for a metric
+func writeMetricHeader(w io.Writer, name, metricType, help string) {
+	fmt.Fprintf(w, "# HELP %s%s %s\n", metricsPrefix, name, help)
+	fmt.Fprintf(w, "# TYPE %s%s %s\n", metricsPrefix, name, metricType)
+}
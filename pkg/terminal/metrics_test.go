@@ -0,0 +1,148 @@
+package terminal_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dansun78/go-remote-term/pkg/terminal"
+	"github.com/gorilla/websocket"
+)
+
+// scrapeMetrics fetches the metrics of manager and returns the samples by name and labels
+func scrapeMetrics(t *testing.T, manager *terminal.SessionManager) ([]string, map[string]float64) {
+	t.Helper()
+
+	recorder := httptest.NewRecorder()
+	terminal.MetricsHandler(manager).ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if contentType := recorder.Header().Get("Content-Type"); !strings.HasPrefix(contentType, "text/plain; version=0.0.4") {
+		t.Fatalf("Unexpected content type %q", contentType)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(recorder.Body.String(), "\n"), "\n")
+	samples := make(map[string]float64)
+	for _, line := range lines {
+		if strings.HasPrefix(line, "# HELP ") || strings.HasPrefix(line, "# TYPE ") {
+			continue
+		}
+		name, value, ok := strings.Cut(line, " ")
+		number, err := strconv.ParseFloat(value, 64)
+		if !ok || err != nil || !strings.HasPrefix(name, "remote_term_") {
+			t.Fatalf("Malformed sample line %q", line)
+		}
+		samples[name] = number
+	}
+	return lines, samples
+}
+
+// waitForMetric waits until the sample of metrics named name has the given value
+func waitForMetric(t *testing.T, manager *terminal.SessionManager, name string, want float64) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		_, samples := scrapeMetrics(t, manager)
+		if samples[name] == want {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected %s to be %g, got %g", name, want, samples[name])
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	t.Parallel()
+
+	manager := terminal.NewSessionManager()
+	defer manager.Close()
+	opts := testOptions()
+	terminal.SetAuthToken(opts, "secret")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		terminal.HandleWebSocketWithOptions(w, r, manager, opts)
+	}))
+	defer server.Close()
+
+	// Failed authentications are counted by reason
+	conn, resp := dialTerminal(t, server, terminal.Message{Type: "auth", Token: "wrong"})
+	conn.Close()
+	if resp.Success {
+		t.Fatal("Authenticated with a wrong token")
+	}
+
+	conn, resp = dialTerminal(t, server, terminal.Message{Type: "auth", Token: "secret"})
+	if !resp.Success {
+		t.Fatalf("Failed to start a session: %+v", resp)
+	}
+
+	// Bytes are counted in both directions
+	input := "echo he''llo\n"
+	conn.WriteMessage(websocket.TextMessage, []byte(input))
+	readUntil(t, conn, func(message []byte) bool {
+		return strings.Contains(string(message), "hello")
+	})
+	waitForMetric(t, manager, `remote_term_bytes_total{direction="in"}`, float64(len(input)))
+	waitForMetric(t, manager, "remote_term_connections_attached", 1)
+
+	_, samples := scrapeMetrics(t, manager)
+	if samples[`remote_term_bytes_total{direction="out"}`] < float64(len("hello")) {
+		t.Fatalf("Expected the output to be counted, got %v", samples)
+	}
+	if samples["remote_term_sessions_active"] != 1 || samples["remote_term_sessions_created_total"] != 1 {
+		t.Fatalf("Expected one active and one created session, got %v", samples)
+	}
+	if samples[`remote_term_auth_failures_total{reason="invalid_token"}`] != 1 {
+		t.Fatalf("Expected one auth failure for an invalid token, got %v", samples)
+	}
+
+	// Closed connections and ended sessions are counted
+	conn.Close()
+	waitForMetric(t, manager, "remote_term_connection_duration_seconds_count", 1)
+	manager.Terminate(resp.SessionID)
+
+	lines, samples := scrapeMetrics(t, manager)
+	if samples["remote_term_sessions_active"] != 0 || samples["remote_term_sessions_terminated_total"] != 1 {
+		t.Fatalf("Expected the session to be counted as terminated, got %v", samples)
+	}
+
+	// Every metric is declared before its samples, and the histogram is cumulative
+	declared := make(map[string]string)
+	var buckets []float64
+	for _, line := range lines {
+		if fields := strings.Fields(line); len(fields) == 4 && fields[1] == "TYPE" {
+			declared[fields[2]] = fields[3]
+			continue
+		}
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, _, _ := strings.Cut(line, " ")
+		name, _, _ = strings.Cut(name, "{")
+		base := strings.TrimSuffix(strings.TrimSuffix(strings.TrimSuffix(name, "_bucket"), "_sum"), "_count")
+		if declared[name] == "" && declared[base] != "histogram" {
+			t.Fatalf("Sample %q of an undeclared metric", line)
+		}
+		if strings.HasPrefix(line, "remote_term_connection_duration_seconds_bucket{le=") {
+			buckets = append(buckets, samples[strings.Fields(line)[0]])
+		}
+	}
+	if declared["remote_term_connection_duration_seconds"] != "histogram" || len(buckets) < 2 {
+		t.Fatalf("Expected a connection duration histogram, got %q", lines)
+	}
+	for i := 1; i < len(buckets); i++ {
+		if buckets[i] < buckets[i-1] {
+			t.Fatalf("Histogram buckets are not cumulative: %v", buckets)
+		}
+	}
+	if buckets[len(buckets)-1] != samples["remote_term_connection_duration_seconds_count"] ||
+		samples[`remote_term_connection_duration_seconds_bucket{le="+Inf"}`] != 1 {
+		t.Fatalf("Expected the +Inf bucket to match the count, got %v", samples)
+	}
+	if sum, ok := samples["remote_term_connection_duration_seconds_sum"]; !ok || sum <= 0 {
+		t.Fatalf("Expected a positive duration sum, got %v", samples)
+	}
+}
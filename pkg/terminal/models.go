@@ -3,9 +3,6 @@
 package terminal
 
 import (
-	"bytes"
-	"os"
-	"os/exec"
 	"sync"
 	"time"
 )
@@ -18,6 +15,28 @@ type AuthProvider interface {
 	ValidataAuthToken(token string) bool
 }
 
+// Principal identifies who authenticated a connection
+type Principal struct {
+	Name string `json:"name"`
+	Role string `json:"role"` // PrincipalAdmin, PrincipalUser or PrincipalViewer
+}
+
+// Principal roles
+const (
+	PrincipalAdmin  = "admin"  // May use every session
+	PrincipalUser   = "user"   // May create sessions and use their own
+	PrincipalViewer = "viewer" // May only watch existing sessions
+)
+
+// PrincipalAuthProvider is an AuthProvider that also tells who a token belongs to.
+// Sessions are then owned by the principal that created them: users may only
+// attach to their own sessions, viewers only watch, and admins may use any session.
+type PrincipalAuthProvider interface {
+	AuthProvider
+	// AuthenticatePrincipal returns the principal a token belongs to if the token is valid
+	AuthenticatePrincipal(token string) (*Principal, bool)
+}
+
 // TerminalOptions configures the behavior of the terminal session
 type TerminalOptions struct {
 	// Shell is the path to the shell executable (defaults to $SHELL or /bin/bash)
@@ -35,43 +54,148 @@ type TerminalOptions struct {
 	// SessionTimeout defines how long to keep a disconnected session alive (default: 10 minutes)
 	SessionTimeout time.Duration
 
+	// HangupGrace is how long the processes of a closing session get to exit after SIGHUP
+	// before they are sent SIGTERM (default: 2 seconds)
+	HangupGrace time.Duration
+
+	// TerminateGrace is how long the processes of a closing session get to exit after
+	// SIGTERM before they are killed (default: 2 seconds)
+	TerminateGrace time.Duration
+
+	// ScrollbackBytes limits how much output is kept for replay on reconnect (default: 1 MiB)
+	ScrollbackBytes int
+
+	// ScrollbackLines limits how many lines are kept for replay on reconnect (default: 0, no line limit)
+	ScrollbackLines int
+
+	// RecordingDir enables session recording when set: every session is written
+	// to an asciicast v2 file named <session ID>.cast in this directory
+	RecordingDir string
+
+	// RecordInput includes client keystrokes in recordings (default: false)
+	RecordInput bool
+
+	// Profiles is the allowlist of commands clients may start sessions with by name,
+	// in addition to Shell (default: none)
+	Profiles []Profile
+
+	// Targets is the allowlist of SSH hosts clients may open sessions on by name instead
+	// of running a local shell, see SSHTarget (default: none)
+	Targets []SSHTarget
+
+	// Docker lets clients open sessions in running containers, see Docker (default: nil, disabled)
+	Docker *Docker
+
+	// SerialPorts is the allowlist of serial ports clients may attach sessions to by name
+	// instead of running a local shell, see SerialPort (default: none)
+	SerialPorts []SerialPort
+
+	// UserMapper maps the principal creating a session to the OS user its shell runs as,
+	// e.g. UserMap(...). The shell then runs with that user's credentials, groups, home
+	// directory, login shell and login environment, which requires root or CAP_SETUID
+	// and CAP_SETGID. An empty username runs the shell as the server's own user, and an
+	// error prevents the principal from starting sessions. (default: nil, run every
+	// shell as the server's own user)
+	UserMapper func(principal *Principal) (string, error)
+
+	// Limits are rlimits applied to every shell (default: none)
+	Limits ResourceLimits
+
+	// Cgroup places every session in its own cgroup v2 with the given limits (default: nil, disabled)
+	Cgroup *CgroupLimits
+
+	// Sandbox runs every session in its own namespaces, see Sandbox (default: nil, disabled)
+	Sandbox *Sandbox
+
+	// PersistDir makes sessions survive server restarts: the terminal of every session
+	// is also held open by a small detached holder process with a control socket in this
+	// directory. SessionManager.Shutdown then hands sessions over to their holders instead
+	// of hanging them up, and SessionManager.Restore takes them over in the next server
+	// instance. Programs using it must call Init first thing in main. (default: empty, disabled)
+	PersistDir string
+
 	// AuthProvider is used to validate authentication tokens
 	AuthProvider AuthProvider
 }
 
 // Message represents the messages sent between client and server
 type Message struct {
-	Type      string `json:"type"`
-	Token     string `json:"token,omitempty"`
-	SessionID string `json:"session_id,omitempty"`
-	Data      string `json:"data,omitempty"`
-	Rows      uint16 `json:"rows,omitempty"`
-	Cols      uint16 `json:"cols,omitempty"`
+	Type       string `json:"type"`
+	Token      string `json:"token,omitempty"`
+	Share      string `json:"share,omitempty"` // Share link token, used by guests instead of Token
+	SessionID  string `json:"session_id,omitempty"`
+	Data       string `json:"data,omitempty"`
+	Rows       uint16 `json:"rows,omitempty"`
+	Cols       uint16 `json:"cols,omitempty"`
+	Protocol   string `json:"protocol,omitempty"`    // Protocol version requested in auth messages
+	Profile    string `json:"profile,omitempty"`     // Profile to start a new session with, instead of the shell
+	Target     string `json:"target,omitempty"`      // SSH target to open a new session on, instead of the local shell
+	Container  string `json:"container,omitempty"`   // Container to open a new session in, instead of the local shell
+	SerialPort string `json:"serial_port,omitempty"` // Serial port to attach to, instead of the local shell
 }
 
 // Response represents server responses sent to clients
 type Response struct {
-	Type      string `json:"type"`
-	Success   bool   `json:"success"`
-	Message   string `json:"message,omitempty"`
-	SessionID string `json:"session_id,omitempty"`
+	Type         string          `json:"type"`
+	Success      bool            `json:"success"`
+	Message      string          `json:"message,omitempty"`
+	SessionID    string          `json:"session_id,omitempty"`
+	Role         ParticipantRole `json:"role,omitempty"`         // Role granted to the client, in auth responses
+	Protocol     string          `json:"protocol,omitempty"`     // Protocol version in use, in auth responses
+	Share        *Share          `json:"share,omitempty"`        // Created share, in share responses
+	Participants []Participant   `json:"participants,omitempty"` // Attached connections, in presence messages
+	End          *SessionEnd     `json:"end,omitempty"`          // How the session ended, in session_ended messages
+}
+
+// SessionInfo is a point-in-time snapshot of a session's state
+type SessionInfo struct {
+	ID           string        `json:"id"`
+	Shell        string        `json:"shell"`
+	PID          int           `json:"pid"`
+	CreatedAt    time.Time     `json:"created_at"`
+	LastActive   time.Time     `json:"last_active"`
+	Connections  int           `json:"connections"`
+	Rows         uint16        `json:"rows"`
+	Cols         uint16        `json:"cols"`
+	Participants []Participant `json:"participants"`
+	Principal    *Principal    `json:"principal,omitempty"`
+	Profile      string        `json:"profile,omitempty"`
+	Target       string        `json:"target,omitempty"`      // SSH target the session runs on
+	Container    string        `json:"container,omitempty"`   // Name of the container the session runs in
+	SerialPort   string        `json:"serial_port,omitempty"` // Serial port the session is attached to
+	User         string        `json:"user,omitempty"`        // OS user the shell runs as
+	End          *SessionEnd   `json:"end,omitempty"`         // Exit status and resource usage, once the session has ended
 }
 
 // TerminalSession represents an active terminal session
 type TerminalSession struct {
 	ID           string
-	PTY          *os.File
-	Command      *exec.Cmd
+	Principal    *Principal // Who created the session, nil if the auth provider does not identify principals
+	Profile      string     // Name of the profile the session was started with, empty for the shell
+	Target       string     // Name of the SSH target the session runs on, empty for local sessions
+	Container    string     // Name of the container the session runs in, empty for local sessions
+	SerialPort   string     // Name of the serial port the session is attached to, empty for local sessions
+	User         string     // OS user the shell runs as, empty for the server's own user
+	Backend      Backend    // Terminal the session is attached to, a local PTY by default
 	Options      *TerminalOptions
-	OutputBuffer *bytes.Buffer
+	OutputBuffer *Scrollback
+	CreatedAt    time.Time
 	LastActive   time.Time
 	Connections  int
+	Rows         uint16
+	Cols         uint16
 	Lock         sync.Mutex
 	Done         chan struct{}
-}
 
-// Global session manager
-var (
-	sessions     = make(map[string]*TerminalSession)
-	sessionsLock sync.Mutex
-)
+	manager      *SessionManager                // Manager that owns the session
+	subscribers  map[*outputSubscriber]struct{} // Connections receiving live output
+	participants map[string]Participant         // Attached connections by participant ID
+	recorder     *recorder                      // Optional asciicast recorder
+	end          *SessionEnd                    // How the session ended, nil while it is running
+	holder       *ptyHolder                     // Process keeping the terminal open across restarts, if persistent
+	readerDone   chan struct{}                  // Closed once the terminal is no longer read
+	detached     bool                           // Set once the session was handed over to its holder
+	exclusive    bool                           // Set if the session accepts a single connection at a time
+	inputLock    sync.Mutex                     // Keeps writes to the backend one at a time and in order
+	closeOnce    sync.Once
+}
@@ -0,0 +1,164 @@
+package terminal
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+)
+
+// ErrNoUserMapping is returned when a principal may not start sessions because no OS user is mapped to it
+var ErrNoUserMapping = errors.New("no OS user mapped to principal")
+
+// passwdFile is where login shells are looked up
+const passwdFile = "/etc/passwd"
+
+// defaultLoginShell is used for users without a login shell in the passwd file
+const defaultLoginShell = "/bin/sh"
+
+// OSUser is the unprivileged operating system user a session's shell runs as
+type OSUser struct {
+	Username string
+	UID      uint32
+	GID      uint32
+	Groups   []uint32 // Supplementary group IDs
+	HomeDir  string
+	Shell    string // Login shell from the passwd file
+}
+
+// UserMap returns a TerminalOptions.UserMapper that maps principals to OS users by
+// principal name. The "*" entry, if present, maps every principal not listed by name.
+// Principals without a mapping may not start sessions.
+func UserMap(users map[string]string) func(*Principal) (string, error) {
+	return func(principal *Principal) (string, error) {
+		name := ""
+		if principal != nil {
+			name = principal.Name
+		}
+		if username, ok := users[name]; ok && name != "" {
+			return username, nil
+		}
+		if username, ok := users["*"]; ok {
+			return username, nil
+		}
+		return "", fmt.Errorf("%w %q", ErrNoUserMapping, name)
+	}
+}
+
+// LoadUserMap loads a user map from a JSON file holding an object of principal
+// names to OS usernames, e.g. {"alice": "alice", "*": "guest"}
+func LoadUserMap(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read user map: %v", err)
+	}
+
+	var users map[string]string
+	if err := json.Unmarshal(data, &users); err != nil {
+		return nil, fmt.Errorf("failed to parse user map: %v", err)
+	}
+	for principal, username := range users {
+		if _, err := LookupOSUser(username); err != nil {
+			return nil, fmt.Errorf("user map entry %q: %v", principal, err)
+		}
+	}
+	return users, nil
+}
+
+// resolveOSUser returns the OS user a session created by principal runs as,
+// or nil to run it as the server's own user
+func resolveOSUser(options *TerminalOptions, principal *Principal) (*OSUser, error) {
+	if options.UserMapper == nil {
+		return nil, nil
+	}
+	username, err := options.UserMapper(principal)
+	if err != nil {
+		return nil, err
+	}
+	if username == "" {
+		return nil, nil
+	}
+	return LookupOSUser(username)
+}
+
+// LookupOSUser looks up an OS user by name together with its groups and login shell
+func LookupOSUser(username string) (*OSUser, error) {
+	u, err := user.Lookup(username)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up user %q: %v", username, err)
+	}
+
+	uid, err := strconv.ParseUint(u.Uid, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("user %q has a non-numeric uid %q", username, u.Uid)
+	}
+	gid, err := strconv.ParseUint(u.Gid, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("user %q has a non-numeric gid %q", username, u.Gid)
+	}
+
+	groupIDs, err := u.GroupIds()
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up groups of user %q: %v", username, err)
+	}
+	groups := make([]uint32, 0, len(groupIDs))
+	for _, id := range groupIDs {
+		group, err := strconv.ParseUint(id, 10, 32)
+		if err != nil {
+			continue
+		}
+		groups = append(groups, uint32(group))
+	}
+
+	return &OSUser{
+		Username: u.Username,
+		UID:      uint32(uid),
+		GID:      uint32(gid),
+		Groups:   groups,
+		HomeDir:  u.HomeDir,
+		Shell:    loginShell(passwdFile, u.Username),
+	}, nil
+}
+
+// loginShell returns the login shell of a user from a passwd file
+// os/user does not expose it, so the file is read directly.
+func loginShell(path, username string) string {
+	file, err := os.Open(path)
+	if err != nil {
+		return defaultLoginShell
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		// name:password:uid:gid:gecos:home:shell
+		fields := strings.Split(scanner.Text(), ":")
+		if len(fields) == 7 && fields[0] == username && fields[6] != "" {
+			return fields[6]
+		}
+	}
+	return defaultLoginShell
+}
+
+// workingDir returns the user's home directory, or / if it does not exist, as login does
+func (u *OSUser) workingDir() string {
+	if info, err := os.Stat(u.HomeDir); err == nil && info.IsDir() {
+		return u.HomeDir
+	}
+	return "/"
+}
+
+// loginEnvironment returns the variables describing the user, to be appended to
+// a session's environment so that they override the server's own
+func (u *OSUser) loginEnvironment() []string {
+	return []string{
+		"HOME=" + u.workingDir(),
+		"USER=" + u.Username,
+		"LOGNAME=" + u.Username,
+		"SHELL=" + u.Shell,
+	}
+}
@@ -0,0 +1,16 @@
+//go:build !unix
+
+package terminal
+
+import (
+	"errors"
+	"os/exec"
+)
+
+// errOSUserUnsupported is returned when a shell is to run as an OS user on a platform other than Unix
+var errOSUserUnsupported = errors.New("running shells as OS users is only supported on Unix")
+
+// runAs fails, processes cannot be started as another user here
+func (u *OSUser) runAs(cmd *exec.Cmd) error {
+	return errOSUserUnsupported
+}
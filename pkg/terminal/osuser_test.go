@@ -0,0 +1,83 @@
+package terminal_test
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dansun78/go-remote-term/pkg/terminal"
+)
+
+func TestUserMap(t *testing.T) {
+	mapper := terminal.UserMap(map[string]string{"alice": "alice", "*": "guest"})
+
+	tests := []struct {
+		principal *terminal.Principal
+		want      string
+	}{
+		{&terminal.Principal{Name: "alice", Role: terminal.PrincipalUser}, "alice"},
+		{&terminal.Principal{Name: "bob", Role: terminal.PrincipalUser}, "guest"},
+		{nil, "guest"},
+	}
+	for _, tt := range tests {
+		if got, err := mapper(tt.principal); err != nil || got != tt.want {
+			t.Errorf("Expected %+v to map to %q, got %q, %v", tt.principal, tt.want, got, err)
+		}
+	}
+
+	strict := terminal.UserMap(map[string]string{"alice": "alice"})
+	if _, err := strict(&terminal.Principal{Name: "bob"}); !errors.Is(err, terminal.ErrNoUserMapping) {
+		t.Errorf("Expected ErrNoUserMapping for an unmapped principal, got %v", err)
+	}
+}
+
+func TestSessionRunsAsMappedUser(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("Switching users requires root")
+	}
+	nobody, err := terminal.LookupOSUser("nobody")
+	if err != nil {
+		t.Skipf("No nobody user: %v", err)
+	}
+
+	manager := terminal.NewSessionManager()
+	defer manager.Close()
+
+	opts := testOptions()
+	opts.UserMapper = terminal.UserMap(map[string]string{"alice": "nobody"})
+	opts.Profiles = []terminal.Profile{{
+		Name:    "whoami",
+		Command: []string{"/bin/sh", "-c", `echo "uid=$(id -u) user=$USER home=$HOME pwd=$(pwd)"`},
+	}}
+
+	if _, err := manager.NewWithProfile(opts, &terminal.Principal{Name: "bob"}, "whoami"); !errors.Is(err, terminal.ErrNoUserMapping) {
+		t.Errorf("Expected an unmapped principal to be refused, got %v", err)
+	}
+
+	session, err := manager.NewWithProfile(opts, &terminal.Principal{Name: "alice"}, "whoami")
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+	if session.Info().User != "nobody" {
+		t.Errorf("Expected session info to report user nobody, got %q", session.Info().User)
+	}
+
+	select {
+	case <-session.Done:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("Timed out waiting for the command to exit")
+	}
+
+	// nobody's home directory usually does not exist, in which case the shell starts in /
+	home := nobody.HomeDir
+	if info, err := os.Stat(home); err != nil || !info.IsDir() {
+		home = "/"
+	}
+	want := fmt.Sprintf("uid=%d user=nobody home=%s pwd=%s", nobody.UID, home, home)
+	if output := string(session.BufferedOutput()); !strings.Contains(output, want) {
+		t.Errorf("Expected output containing %q, got %q", want, output)
+	}
+}
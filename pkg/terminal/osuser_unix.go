@@ -0,0 +1,18 @@
+//go:build unix
+
+package terminal
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// runAs makes cmd run as the user, in the user's home directory
+func (u *OSUser) runAs(cmd *exec.Cmd) error {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Credential = &syscall.Credential{Uid: u.UID, Gid: u.GID, Groups: u.Groups}
+	cmd.Dir = u.workingDir()
+	return nil
+}
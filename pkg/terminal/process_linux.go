@@ -0,0 +1,81 @@
+package terminal
+
+import (
+	"os"
+	"strconv"
+	"strings"
+)
+
+// sessionProcesses returns the live processes of the session whose shell is leader:
+// every process in the shell's session, including background jobs in process groups of
+// their own, and every descendant of the shell that left the session with setsid
+func sessionProcesses(leader int) []int {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return nil
+	}
+
+	parents := make(map[int]int)
+	var members []int
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		data, err := os.ReadFile("/proc/" + entry.Name() + "/stat")
+		if err != nil {
+			continue // The process exited in the meantime
+		}
+
+		// The command name is in parentheses and may contain anything, so the
+		// fields are counted from the last closing parenthesis:
+		// state, ppid, pgrp, session, ...
+		stat := string(data)
+		fields := strings.Fields(stat[strings.LastIndexByte(stat, ')')+1:])
+		if len(fields) < 4 || fields[0] == "Z" || fields[0] == "X" {
+			continue // Zombies are dead already and wait to be reaped by their parent
+		}
+		parent, _ := strconv.Atoi(fields[1])
+		session, _ := strconv.Atoi(fields[3])
+
+		parents[pid] = parent
+		if session == leader {
+			members = append(members, pid)
+		}
+	}
+
+	// Add the descendants of the shell outside its session
+	inSession := make(map[int]bool, len(members))
+	for _, pid := range members {
+		inSession[pid] = true
+	}
+	for pid := range parents {
+		if !inSession[pid] && descendsFrom(parents, pid, leader) {
+			members = append(members, pid)
+		}
+	}
+	return members
+}
+
+// descendsFrom reports whether ancestor is among the ancestors of pid
+func descendsFrom(parents map[int]int, pid, ancestor int) bool {
+	// Bounded in case the table has a cycle from PIDs reused while it was read
+	for range parents {
+		parent, ok := parents[pid]
+		if !ok || parent <= 1 {
+			return false
+		}
+		if parent == ancestor {
+			return true
+		}
+		pid = parent
+	}
+	return false
+}
+
+// inContainer reports whether pid is a process of this host in the container, judged
+// by the container ID in its cgroup path
+func inContainer(pid int, containerID string) bool {
+	data, err := os.ReadFile("/proc/" + strconv.Itoa(pid) + "/cgroup")
+	return err == nil && containerID != "" && strings.Contains(string(data), containerID)
+}
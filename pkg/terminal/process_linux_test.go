@@ -0,0 +1,71 @@
+package terminal_test
+
+import (
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/dansun78/go-remote-term/pkg/terminal"
+)
+
+// processAlive reports whether pid is a running process rather than gone or a zombie
+func processAlive(pid int) bool {
+	data, err := os.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+	if err != nil {
+		return false
+	}
+	fields := strings.Fields(string(data)[strings.LastIndexByte(string(data), ')')+1:])
+	return len(fields) > 0 && fields[0] != "Z"
+}
+
+func TestCloseKillsSessionProcesses(t *testing.T) {
+	t.Parallel()
+
+	manager := terminal.NewSessionManager()
+	defer manager.Close()
+
+	opts := testOptions()
+	opts.HangupGrace = 200 * time.Millisecond
+	opts.TerminateGrace = 200 * time.Millisecond
+	opts.Profiles = []terminal.Profile{{
+		Name: "jobs",
+		Command: []string{"/bin/sh", "-c", `
+			nohup sleep 1000 >/dev/null 2>&1 &
+			echo "nohup=$!"
+			sh -c 'trap "" HUP TERM; while :; do sleep 1; done' >/dev/null 2>&1 &
+			echo "stubborn=$!"
+			setsid sleep 1000 >/dev/null 2>&1 &
+			echo "setsid=$!"
+			sleep 1000`},
+	}}
+
+	session, err := manager.NewWithProfile(opts, nil, "jobs")
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+
+	pattern := regexp.MustCompile(`(nohup|stubborn|setsid)=(\d+)`)
+	var matches [][]string
+	deadline := time.Now().Add(5 * time.Second)
+	for len(matches) < 3 && time.Now().Before(deadline) {
+		time.Sleep(20 * time.Millisecond)
+		matches = pattern.FindAllStringSubmatch(string(session.BufferedOutput()), -1)
+	}
+	if len(matches) < 3 {
+		t.Fatalf("Background jobs did not start: %q", session.BufferedOutput())
+	}
+
+	manager.Terminate(session.ID)
+
+	for _, match := range matches {
+		pid, _ := strconv.Atoi(match[2])
+		if processAlive(pid) {
+			t.Errorf("Expected the %s job (pid %d) to be gone after the session closed", match[1], pid)
+			syscall.Kill(pid, syscall.SIGKILL)
+		}
+	}
+}
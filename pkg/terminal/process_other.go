@@ -0,0 +1,20 @@
+//go:build unix && !linux
+
+package terminal
+
+import "syscall"
+
+// sessionProcesses returns the process group of the session whose shell is leader, as
+// its negated ID, while any process remains in it. Without /proc, background jobs in
+// process groups of their own and descendants that left the session are not found.
+func sessionProcesses(leader int) []int {
+	if syscall.Kill(-leader, 0) != nil {
+		return nil
+	}
+	return []int{-leader}
+}
+
+// inContainer reports false, without /proc the container of a process is unknown
+func inContainer(pid int, containerID string) bool {
+	return false
+}
@@ -0,0 +1,34 @@
+//go:build unix
+
+package terminal
+
+import (
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// signalProcesses sends sig to each of the processes returned by sessionProcesses
+func signalProcesses(pids []int, sig syscall.Signal) {
+	for _, pid := range pids {
+		syscall.Kill(pid, sig)
+	}
+}
+
+// signalForeground sends sig to the foreground process group of the terminal,
+// reporting whether the group was known
+func signalForeground(ptmx *os.File, sig syscall.Signal) (bool, error) {
+	conn, err := ptmx.SyscallConn()
+	if err != nil {
+		return false, nil
+	}
+	pgrp := 0
+	conn.Control(func(fd uintptr) {
+		pgrp, _ = unix.IoctlGetInt(int(fd), unix.TIOCGPGRP)
+	})
+	if pgrp <= 0 {
+		return false, nil
+	}
+	return true, syscall.Kill(-pgrp, sig)
+}
@@ -0,0 +1,25 @@
+package terminal
+
+import (
+	"os"
+	"syscall"
+)
+
+// sessionProcesses returns nothing, Windows has no process groups to find the
+// processes of a session by
+func sessionProcesses(leader int) []int {
+	return nil
+}
+
+// signalProcesses does nothing, as there are no session processes to signal
+func signalProcesses(pids []int, sig syscall.Signal) {}
+
+// signalForeground sends nothing, as terminals have no foreground process group here
+func signalForeground(ptmx *os.File, sig syscall.Signal) (bool, error) {
+	return false, nil
+}
+
+// inContainer reports false, containers run in a separate VM here
+func inContainer(pid int, containerID string) bool {
+	return false
+}
@@ -0,0 +1,153 @@
+package terminal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// ErrUnknownProfile is returned when a client requests a profile that is not in the allowlist
+var ErrUnknownProfile = errors.New("unknown profile")
+
+// Profile is a named command that clients may start sessions with instead of the
+// default shell. Clients only ever refer to profiles by name, so the set of
+// profiles in TerminalOptions.Profiles is an allowlist of what they can run.
+type Profile struct {
+	// Name identifies the profile in client requests
+	Name string
+
+	// Description is shown to users choosing a profile
+	Description string
+
+	// Command is the argv of the program, e.g. []string{"psql", "-h", "db"}
+	Command []string
+
+	// Dir is the working directory of the program (default: the server's working directory)
+	Dir string
+
+	// Environment is added to TerminalOptions.Environment, overriding variables set there
+	Environment []string
+
+	// SessionTimeout overrides TerminalOptions.SessionTimeout for sessions of this profile:
+	// how long they are kept alive once no client is connected. It does not limit how
+	// long the program runs.
+	SessionTimeout time.Duration
+}
+
+// profileEntry is a single profile in a profiles file
+type profileEntry struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description,omitempty"`
+	Command     []string `json:"command"`
+	Dir         string   `json:"dir,omitempty"`
+	Env         []string `json:"env,omitempty"`
+	IdleTimeout string   `json:"idle_timeout,omitempty"` // Go duration, e.g. "30m"
+}
+
+// profileSummary is what clients are told about a profile
+type profileSummary struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+}
+
+// LoadProfiles loads command profiles from a JSON file holding an array of entries:
+//
+//	[{"name": "psql", "description": "Database shell", "command": ["psql", "-h", "db"],
+//	  "dir": "/srv", "env": ["PGUSER=app"], "idle_timeout": "30m"}]
+//
+// Only name and command are required.
+func LoadProfiles(path string) ([]Profile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read profiles file: %v", err)
+	}
+
+	var entries []profileEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("failed to parse profiles file: %v", err)
+	}
+
+	profiles := make([]Profile, 0, len(entries))
+	names := make(map[string]bool, len(entries))
+	for i, entry := range entries {
+		if entry.Name == "" {
+			return nil, fmt.Errorf("profile %d in %s has no name", i+1, path)
+		}
+		if names[entry.Name] {
+			return nil, fmt.Errorf("duplicate profile name %q in %s", entry.Name, path)
+		}
+		if len(entry.Command) == 0 || entry.Command[0] == "" {
+			return nil, fmt.Errorf("profile %q has no command", entry.Name)
+		}
+		for _, variable := range entry.Env {
+			if !strings.Contains(variable, "=") {
+				return nil, fmt.Errorf("profile %q has invalid environment variable %q", entry.Name, variable)
+			}
+		}
+
+		profile := Profile{
+			Name:        entry.Name,
+			Description: entry.Description,
+			Command:     entry.Command,
+			Dir:         entry.Dir,
+			Environment: entry.Env,
+		}
+		if entry.IdleTimeout != "" {
+			timeout, err := time.ParseDuration(entry.IdleTimeout)
+			if err != nil || timeout <= 0 {
+				return nil, fmt.Errorf("profile %q has invalid idle timeout %q", entry.Name, entry.IdleTimeout)
+			}
+			profile.SessionTimeout = timeout
+		}
+
+		names[entry.Name] = true
+		profiles = append(profiles, profile)
+	}
+	return profiles, nil
+}
+
+// lookupProfile returns the profile with the given name from the allowlist in options
+// An empty name selects the default shell and returns nil.
+func lookupProfile(options *TerminalOptions, name string) (*Profile, error) {
+	if name == "" {
+		return nil, nil
+	}
+	for i := range options.Profiles {
+		if options.Profiles[i].Name == name {
+			return &options.Profiles[i], nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %q", ErrUnknownProfile, name)
+}
+
+// sessionOptions returns the options of a session started with the profile
+func (p *Profile) sessionOptions(options *TerminalOptions) *TerminalOptions {
+	opts := *options
+	opts.Shell = p.Command[0]
+	opts.Environment = append(append([]string(nil), options.Environment...), p.Environment...)
+	if p.SessionTimeout > 0 {
+		opts.SessionTimeout = p.SessionTimeout
+	}
+	return &opts
+}
+
+// ProfilesHandler serves the names and descriptions of the given profiles as JSON,
+// so that clients can offer them when starting a new session. Commands are not disclosed.
+func ProfilesHandler(profiles []Profile) http.Handler {
+	summaries := make([]profileSummary, 0, len(profiles))
+	for _, profile := range profiles {
+		summaries = append(summaries, profileSummary{Name: profile.Name, Description: profile.Description})
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeAPIError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+		writeJSON(w, http.StatusOK, summaries)
+	})
+}
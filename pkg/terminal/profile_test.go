@@ -0,0 +1,84 @@
+package terminal_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dansun78/go-remote-term/pkg/terminal"
+)
+
+func TestLoadProfiles(t *testing.T) {
+	tests := []struct {
+		name     string
+		contents string
+		wantErr  bool
+	}{
+		{"valid", `[{"name": "top", "command": ["top"], "env": ["A=b"], "idle_timeout": "30m"}]`, false},
+		{"missing command", `[{"name": "top"}]`, true},
+		{"duplicate name", `[{"name": "top", "command": ["top"]}, {"name": "top", "command": ["htop"]}]`, true},
+		{"invalid environment", `[{"name": "top", "command": ["top"], "env": ["A"]}]`, true},
+		{"invalid idle timeout", `[{"name": "top", "command": ["top"], "idle_timeout": "soon"}]`, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "profiles.json")
+			if err := os.WriteFile(path, []byte(tt.contents), 0600); err != nil {
+				t.Fatalf("Failed to write profiles file: %v", err)
+			}
+
+			profiles, err := terminal.LoadProfiles(path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Expected error %v, got %v", tt.wantErr, err)
+			}
+			if err == nil && (len(profiles) != 1 || profiles[0].SessionTimeout != 30*time.Minute) {
+				t.Errorf("Unexpected profiles: %+v", profiles)
+			}
+		})
+	}
+}
+
+func TestSessionWithProfile(t *testing.T) {
+	t.Parallel()
+
+	manager := terminal.NewSessionManager()
+	defer manager.Close()
+
+	dir := t.TempDir()
+	opts := testOptions()
+	opts.Profiles = []terminal.Profile{{
+		Name:        "greet",
+		Command:     []string{"/bin/sh", "-c", `echo "$GREETING from $(pwd)"; exit 7`},
+		Dir:         dir,
+		Environment: []string{"GREETING=hello"},
+	}}
+
+	if _, err := manager.NewWithProfile(opts, nil, "rm -rf"); !errors.Is(err, terminal.ErrUnknownProfile) {
+		t.Errorf("Expected ErrUnknownProfile for a profile outside the allowlist, got %v", err)
+	}
+
+	session, err := manager.NewWithProfile(opts, nil, "greet")
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+	if session.Profile != "greet" {
+		t.Errorf("Expected session profile greet, got %q", session.Profile)
+	}
+
+	select {
+	case <-session.Done:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("Timed out waiting for the profile command to exit")
+	}
+
+	if output := string(session.BufferedOutput()); !strings.Contains(output, "hello from "+dir) {
+		t.Errorf("Expected output of the profile command, got %q", output)
+	}
+	if end := session.End(); end == nil || end.ExitCode != 7 {
+		t.Errorf("Expected the profile command to exit with code 7, got %+v", end)
+	}
+}
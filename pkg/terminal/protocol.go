@@ -0,0 +1,93 @@
+package terminal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/gorilla/websocket"
+)
+
+// Versions of the WebSocket protocol spoken between clients and the server.
+//
+// The legacy protocol (v1) sends terminal output and JSON control messages as
+// text frames, and treats any client frame that parses as a JSON object as a
+// control message.
+//
+// Protocol v2 sends every message as a binary frame whose first byte is an
+// opcode: FrameData frames carry raw terminal bytes in either direction and
+// FrameControl frames carry a JSON Message (client to server) or Response
+// (server to client). Clients select it by requesting the Subprotocol
+// WebSocket subprotocol, or by setting "protocol": "v2" in their auth message,
+// in which case the auth response is already sent as a v2 frame.
+const (
+	ProtocolLegacy = "v1"
+	ProtocolV2     = "v2"
+
+	// Subprotocol is the WebSocket subprotocol that selects protocol v2
+	Subprotocol = "go-remote-term.v2"
+)
+
+// Opcodes of protocol v2 frames
+const (
+	FrameData    byte = 0x00 // Terminal input or output
+	FrameControl byte = 0x01 // JSON control message
+)
+
+// errInvalidFrame is returned for frames that do not follow the negotiated protocol
+var errInvalidFrame = errors.New("invalid frame")
+
+// EncodeFrame builds a protocol v2 frame from an opcode and its payload
+func EncodeFrame(opcode byte, payload []byte) []byte {
+	frame := make([]byte, 1+len(payload))
+	frame[0] = opcode
+	copy(frame[1:], payload)
+	return frame
+}
+
+// DecodeFrame splits a protocol v2 frame into its opcode and payload
+func DecodeFrame(frame []byte) (byte, []byte, error) {
+	if len(frame) == 0 {
+		return 0, nil, fmt.Errorf("%w: empty frame", errInvalidFrame)
+	}
+	switch frame[0] {
+	case FrameData, FrameControl:
+		return frame[0], frame[1:], nil
+	}
+	return 0, nil, fmt.Errorf("%w: unknown opcode 0x%02x", errInvalidFrame, frame[0])
+}
+
+// readMessage reads the next message from the client using the negotiated
+// protocol and returns either a control message or terminal input
+func (c *clientConn) readMessage() (*Message, []byte, error) {
+	messageType, data, err := c.conn.ReadMessage()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if c.protocol == ProtocolV2 {
+		if messageType != websocket.BinaryMessage {
+			return nil, nil, fmt.Errorf("%w: expected a binary frame", errInvalidFrame)
+		}
+		opcode, payload, err := DecodeFrame(data)
+		if err != nil {
+			return nil, nil, err
+		}
+		if opcode == FrameData {
+			return nil, payload, nil
+		}
+
+		var msg Message
+		if err := json.Unmarshal(payload, &msg); err != nil {
+			return nil, nil, fmt.Errorf("%w: malformed control message: %v", errInvalidFrame, err)
+		}
+		return &msg, nil, nil
+	}
+
+	// Legacy clients: a frame that parses as JSON is a control message
+	var msg Message
+	if err := json.Unmarshal(data, &msg); err == nil {
+		return &msg, nil, nil
+	}
+	return nil, data, nil
+}
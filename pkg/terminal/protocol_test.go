@@ -0,0 +1,99 @@
+package terminal_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dansun78/go-remote-term/pkg/terminal"
+	"github.com/gorilla/websocket"
+)
+
+// readFrame reads a protocol v2 frame from conn
+func readFrame(t *testing.T, conn *websocket.Conn) (byte, []byte) {
+	t.Helper()
+
+	messageType, data, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatalf("Failed to read frame: %v", err)
+	}
+	if messageType != websocket.BinaryMessage {
+		t.Fatalf("Expected a binary frame, got message type %d: %q", messageType, data)
+	}
+	opcode, payload, err := terminal.DecodeFrame(data)
+	if err != nil {
+		t.Fatalf("Failed to decode frame: %v", err)
+	}
+	return opcode, payload
+}
+
+func TestProtocolV2(t *testing.T) {
+	t.Parallel()
+
+	manager := terminal.NewSessionManager()
+	defer manager.Close()
+
+	opts := testOptions()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		terminal.HandleWebSocketWithOptions(w, r, manager, opts)
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name        string
+		subprotocol bool // Negotiate with the subprotocol rather than the auth message
+	}{
+		{"subprotocol", true},
+		{"auth message", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dialer := *websocket.DefaultDialer
+			if tt.subprotocol {
+				dialer.Subprotocols = []string{terminal.Subprotocol}
+			}
+			conn, _, err := dialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+			if err != nil {
+				t.Fatalf("Failed to dial: %v", err)
+			}
+			defer conn.Close()
+			conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+
+			if tt.subprotocol {
+				if conn.Subprotocol() != terminal.Subprotocol {
+					t.Fatalf("Expected subprotocol %q, got %q", terminal.Subprotocol, conn.Subprotocol())
+				}
+				auth, _ := json.Marshal(terminal.Message{Type: "auth", Token: "token"})
+				conn.WriteMessage(websocket.BinaryMessage, terminal.EncodeFrame(terminal.FrameControl, auth))
+			} else {
+				conn.WriteJSON(terminal.Message{Type: "auth", Token: "token", Protocol: terminal.ProtocolV2})
+			}
+
+			// The auth response is already a control frame
+			opcode, payload := readFrame(t, conn)
+			var resp terminal.Response
+			if opcode != terminal.FrameControl || json.Unmarshal(payload, &resp) != nil {
+				t.Fatalf("Expected an auth response control frame, got opcode %d: %q", opcode, payload)
+			}
+			if !resp.Success || resp.Protocol != terminal.ProtocolV2 {
+				t.Fatalf("Unexpected auth response: %+v", resp)
+			}
+
+			// Input that looks like a control message still reaches the shell
+			input := `echo '{"type": "terminate"}' | tr a-z A-Z` + "\n"
+			conn.WriteMessage(websocket.BinaryMessage, terminal.EncodeFrame(terminal.FrameData, []byte(input)))
+
+			var output strings.Builder
+			for !strings.Contains(output.String(), `{"TYPE": "TERMINATE"}`) {
+				opcode, payload := readFrame(t, conn)
+				if opcode == terminal.FrameData {
+					output.Write(payload)
+				}
+			}
+		})
+	}
+}
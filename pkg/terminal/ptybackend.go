@@ -0,0 +1,262 @@
+package terminal
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/creack/pty"
+)
+
+// ptyBackend is the default backend of sessions: a local command on a PTY, optionally
+// confined by rlimits, a cgroup and a sandbox from the session's options
+type ptyBackend struct {
+	id      string // ID of the session, naming its cgroup and sandbox
+	cmd     *exec.Cmd
+	options *TerminalOptions
+	owner   *OSUser // User the terminal device is handed to, nil for the server's own user
+
+	pty     *os.File
+	cgroup  *sessionCgroup  // Cgroup holding the session's processes, if enabled
+	sandbox *sessionSandbox // Namespaces the session runs in, if enabled
+	exited  chan struct{}   // Closed once the shell has been reaped, nil if it is not a child of this process
+	closed  chan struct{}   // Closed once the backend has been closed
+
+	closeOnce sync.Once
+}
+
+// newPTYBackend returns a backend running cmd for the session with the given ID
+func newPTYBackend(sessionID string, cmd *exec.Cmd, options *TerminalOptions, owner *OSUser) *ptyBackend {
+	return &ptyBackend{
+		id:      sessionID,
+		cmd:     cmd,
+		options: options,
+		owner:   owner,
+		closed:  make(chan struct{}),
+	}
+}
+
+// Start starts the command on a new PTY of the given size
+func (b *ptyBackend) Start(rows, cols uint16) error {
+	// Isolate the session in its own namespaces
+	if b.options.Sandbox != nil {
+		sandbox, err := b.options.Sandbox.prepare(b.cmd, b.id)
+		if err != nil {
+			return err
+		}
+		b.sandbox = sandbox
+	}
+
+	// Confine the session's process tree to its own cgroup from the start
+	if b.options.Cgroup != nil {
+		cgroup, err := newSessionCgroup(b.options.Cgroup, b.id)
+		if err != nil {
+			b.release()
+			return err
+		}
+		b.cgroup = cgroup
+		dir, err := cgroup.attach(b.cmd)
+		if err != nil {
+			b.release()
+			return err
+		}
+		defer dir.Close()
+	}
+
+	// Start the command with a pty, limiting the resources of the shell and everything it starts
+	ptmx, err := startWithLimits(b.cmd, b.options.Limits)
+	if err != nil {
+		b.release()
+		return fmt.Errorf("failed to start PTY: %v", err)
+	}
+
+	// Set terminal size to specified dimensions
+	pty.Setsize(ptmx, &pty.Winsize{
+		Rows: rows,
+		Cols: cols,
+		X:    0,
+		Y:    0,
+	})
+
+	// Persistent sessions must be able to stop reading their terminal without closing it
+	if b.options.PersistDir != "" {
+		if ptmx, err = pollable(ptmx); err != nil {
+			b.cmd.Process.Kill()
+			b.cmd.Wait()
+			b.release()
+			return fmt.Errorf("failed to prepare PTY: %v", err)
+		}
+	}
+	b.pty = ptmx
+
+	// Hand the terminal device to the user, as login does
+	if b.owner != nil {
+		if tty, ok := b.cmd.Stdin.(*os.File); ok {
+			if err := os.Chown(tty.Name(), int(b.owner.UID), int(b.owner.GID)); err != nil {
+				log.Printf("Failed to give terminal of session %s to %s: %v", b.id, b.owner.Username, err)
+			}
+		}
+	}
+
+	// Reap the shell when it exits so its exit status can be reported
+	b.exited = make(chan struct{})
+	go b.waitForExit()
+	return nil
+}
+
+// Read reads output of the PTY
+func (b *ptyBackend) Read(p []byte) (int, error) {
+	return b.pty.Read(p)
+}
+
+// Write sends input to the PTY
+func (b *ptyBackend) Write(p []byte) (int, error) {
+	return b.pty.Write(p)
+}
+
+// Resize changes the size of the PTY
+func (b *ptyBackend) Resize(rows, cols uint16) error {
+	if b.options.PersistDir != "" {
+		return resizePollable(b.pty, rows, cols)
+	}
+	return ResizeTerminal(b.pty, rows, cols)
+}
+
+// Signal sends sig to the foreground process group of the terminal, as the terminal
+// driver does for ^C, or to the shell if the group is unknown
+func (b *ptyBackend) Signal(sig syscall.Signal) error {
+	if sent, err := signalForeground(b.pty, sig); sent {
+		return err
+	}
+	return b.cmd.Process.Signal(sig)
+}
+
+// Close hangs up the shell and everything started from it, waits for them to exit and
+// releases the PTY, the cgroup and the sandbox
+func (b *ptyBackend) Close() error {
+	b.closeOnce.Do(func() {
+		// Interactive shells ignore SIGTERM but exit on SIGHUP
+		if b.cmd.Process != nil {
+			signalProcesses(sessionProcesses(b.cmd.Process.Pid), syscall.SIGHUP)
+		}
+		if b.pty != nil {
+			b.pty.Close()
+		}
+		if b.cmd.Process != nil {
+			b.awaitExit()
+		}
+		b.release()
+		close(b.closed)
+	})
+	return nil
+}
+
+// Wait waits for the shell to be reaped and returns its exit status and resource usage.
+// The exit status of shells started by a previous server instance is unknown.
+func (b *ptyBackend) Wait() ExitStatus {
+	if b.exited == nil {
+		<-b.closed
+		return unknownExit
+	}
+	select {
+	case <-b.exited:
+	case <-b.closed:
+		select {
+		case <-b.exited:
+		default:
+			return unknownExit
+		}
+	}
+	code, signal := exitStatus(b.cmd.ProcessState)
+	return ExitStatus{Code: code, Signal: signal, Usage: resourceUsage(b.cmd.ProcessState)}
+}
+
+// pid returns the process ID of the shell
+func (b *ptyBackend) pid() int {
+	if b.cmd.Process == nil {
+		return 0
+	}
+	return b.cmd.Process.Pid
+}
+
+// release kills whatever the shell left running in its cgroup, removes the cgroup and
+// discards the session's changes to its sandbox
+func (b *ptyBackend) release() {
+	if b.cgroup != nil {
+		if err := b.cgroup.remove(); err != nil {
+			log.Printf("Error removing cgroup of session %s: %v", b.id, err)
+		}
+		b.cgroup = nil
+	}
+	if b.sandbox != nil {
+		if err := b.sandbox.remove(); err != nil {
+			log.Printf("Error removing sandbox of session %s: %v", b.id, err)
+		}
+		b.sandbox = nil
+	}
+}
+
+// waitForExit reaps the shell process once it exits and signals b.exited
+// cmd.ProcessState may be read once b.exited is closed.
+func (b *ptyBackend) waitForExit() {
+	b.cmd.Wait()
+	close(b.exited)
+}
+
+// awaitExit waits for the shell and every process started from it to exit after being
+// hung up, escalating to SIGTERM and then SIGKILL for those that do not
+func (b *ptyBackend) awaitExit() {
+	leader := b.cmd.Process.Pid
+	escalation := []struct {
+		signal syscall.Signal
+		grace  time.Duration // How long processes got to exit after the previous signal
+	}{
+		{syscall.SIGTERM, durationOrDefault(b.options.HangupGrace, DefaultHangupGrace)},
+		{syscall.SIGKILL, durationOrDefault(b.options.TerminateGrace, DefaultTerminateGrace)},
+	}
+
+	var remaining []int
+	for _, step := range escalation {
+		if remaining = awaitProcesses(leader, step.grace); len(remaining) == 0 {
+			break
+		}
+		log.Printf("%d processes of session %s did not exit, sending %s", len(remaining), b.id, signalName(step.signal))
+		signalProcesses(remaining, step.signal)
+	}
+	if len(remaining) > 0 {
+		if remaining = awaitProcesses(leader, processExitTimeout); len(remaining) > 0 {
+			log.Printf("Session %s left processes running: %v", b.id, remaining)
+		}
+	}
+
+	// A shell restored from a previous server instance is not a child of this one
+	if b.exited == nil {
+		return
+	}
+	select {
+	case <-b.exited:
+	case <-time.After(processExitTimeout):
+		log.Printf("Shell of session %s could not be reaped", b.id)
+	}
+}
+
+// awaitProcesses waits up to timeout for the processes of the session led by leader to
+// exit and returns those still running
+func awaitProcesses(leader int, timeout time.Duration) []int {
+	deadline := time.Now().Add(timeout)
+	interval := 5 * time.Millisecond
+	for {
+		remaining := sessionProcesses(leader)
+		if len(remaining) == 0 || !time.Now().Before(deadline) {
+			return remaining
+		}
+		time.Sleep(interval)
+		if interval < 100*time.Millisecond {
+			interval *= 2
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package terminal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// recordingExtension is the file extension used for asciicast recordings
+const recordingExtension = ".cast"
+
+// asciicastHeader is the first line of an asciicast v2 file
+type asciicastHeader struct {
+	Version   int               `json:"version"`
+	Width     uint16            `json:"width"`
+	Height    uint16            `json:"height"`
+	Timestamp int64             `json:"timestamp"`
+	Env       map[string]string `json:"env,omitempty"`
+}
+
+// recorder writes a session's terminal activity to an asciicast v2 file
+// See https://docs.asciinema.org/manual/asciicast/v2/ for the format
+type recorder struct {
+	file        *os.File
+	start       time.Time
+	recordInput bool
+	lock        sync.Mutex
+
+	// Trailing bytes of an incomplete UTF-8 character, carried over to the next event
+	pendingOutput []byte
+	pendingInput  []byte
+}
+
+// newRecorder creates the recording file for a session and writes its header
+func newRecorder(sessionID string, options *TerminalOptions) (*recorder, error) {
+	if err := os.MkdirAll(options.RecordingDir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create recording directory: %v", err)
+	}
+
+	path := filepath.Join(options.RecordingDir, sessionID+recordingExtension)
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create recording file: %v", err)
+	}
+
+	r := &recorder{
+		file:        file,
+		start:       time.Now(),
+		recordInput: options.RecordInput,
+	}
+
+	header := asciicastHeader{
+		Version:   2,
+		Width:     options.InitialCols,
+		Height:    options.InitialRows,
+		Timestamp: r.start.Unix(),
+		Env: map[string]string{
+			"SHELL": options.Shell,
+			"TERM":  lookupEnv(options.Environment, "TERM"),
+		},
+	}
+	headerBytes, _ := json.Marshal(header)
+	if _, err := file.Write(append(headerBytes, '\n')); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to write recording header: %v", err)
+	}
+
+	return r, nil
+}
+
+// resumeRecorder reopens the recording of a session restored from its holder for
+// appending, keeping event times relative to start, when the recording began. A session
+// whose recording is missing, e.g. because recording was enabled in the meantime, starts
+// a new one.
+func resumeRecorder(sessionID string, options *TerminalOptions, start time.Time) (*recorder, error) {
+	if start.IsZero() {
+		return newRecorder(sessionID, options)
+	}
+	path := filepath.Join(options.RecordingDir, sessionID+recordingExtension)
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+	if errors.Is(err, fs.ErrNotExist) {
+		return newRecorder(sessionID, options)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to reopen recording file: %v", err)
+	}
+
+	return &recorder{
+		file:        file,
+		start:       start,
+		recordInput: options.RecordInput,
+	}, nil
+}
+
+// Output records data written by the terminal
+func (r *recorder) Output(data []byte) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	var text string
+	text, r.pendingOutput = completeUTF8(r.pendingOutput, data)
+	r.writeEvent("o", text)
+}
+
+// Input records data typed by a client, if input recording is enabled
+func (r *recorder) Input(data []byte) {
+	if !r.recordInput {
+		return
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	var text string
+	text, r.pendingInput = completeUTF8(r.pendingInput, data)
+	r.writeEvent("i", text)
+}
+
+// Resize records a change of the terminal dimensions
+func (r *recorder) Resize(rows, cols uint16) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.writeEvent("r", fmt.Sprintf("%dx%d", cols, rows))
+}
+
+// Close flushes any pending data and closes the recording file
+func (r *recorder) Close() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	// Flush incomplete characters rather than losing them
+	if len(r.pendingOutput) > 0 {
+		r.writeEvent("o", string(r.pendingOutput))
+		r.pendingOutput = nil
+	}
+	if len(r.pendingInput) > 0 {
+		r.writeEvent("i", string(r.pendingInput))
+		r.pendingInput = nil
+	}
+
+	return r.file.Close()
+}
+
+// writeEvent appends a single event line; the caller must hold r.lock
+func (r *recorder) writeEvent(code, data string) {
+	if data == "" {
+		return
+	}
+
+	elapsed := time.Since(r.start).Seconds()
+	event, _ := json.Marshal([]interface{}{elapsed, code, data})
+	r.file.Write(append(event, '\n'))
+}
+
+// completeUTF8 joins pending bytes with new data and splits off a trailing
+// incomplete UTF-8 character so that each event holds only whole characters
+func completeUTF8(pending, data []byte) (string, []byte) {
+	buf := append(pending, data...)
+
+	// Look back at most UTFMax bytes for the start of the last character
+	cut := len(buf)
+	for i := len(buf) - 1; i >= 0 && i >= len(buf)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(buf[i]) {
+			if !utf8.FullRune(buf[i:]) {
+				cut = i
+			}
+			break
+		}
+	}
+
+	if cut == len(buf) {
+		return string(buf), nil
+	}
+	return string(buf[:cut]), append([]byte(nil), buf[cut:]...)
+}
+
+// lookupEnv returns the value of key in a list of KEY=value environment entries
+func lookupEnv(env []string, key string) string {
+	for _, entry := range env {
+		if strings.HasPrefix(entry, key+"=") {
+			return entry[len(key)+1:]
+		}
+	}
+	return ""
+}
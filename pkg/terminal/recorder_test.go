@@ -0,0 +1,165 @@
+package terminal_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dansun78/go-remote-term/pkg/terminal"
+	"github.com/gorilla/websocket"
+)
+
+// castHeader is the first line of an asciicast v2 recording
+type castHeader struct {
+	Version int               `json:"version"`
+	Width   uint16            `json:"width"`
+	Height  uint16            `json:"height"`
+	Env     map[string]string `json:"env"`
+}
+
+// castEvent is an event line of an asciicast v2 recording
+type castEvent struct {
+	Time float64
+	Code string
+	Data string
+}
+
+// readRecording parses the asciicast v2 recording of a session
+func readRecording(t *testing.T, dir, sessionID string) (castHeader, []castEvent) {
+	t.Helper()
+
+	file, err := os.Open(filepath.Join(dir, sessionID+".cast"))
+	if err != nil {
+		t.Fatalf("Failed to open recording: %v", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	var header castHeader
+	if !scanner.Scan() || json.Unmarshal(scanner.Bytes(), &header) != nil {
+		t.Fatalf("Recording has no valid header")
+	}
+	var events []castEvent
+	for scanner.Scan() {
+		var fields []interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &fields); err != nil || len(fields) != 3 {
+			t.Fatalf("Invalid event %q: %v", scanner.Text(), err)
+		}
+		events = append(events, castEvent{fields[0].(float64), fields[1].(string), fields[2].(string)})
+	}
+	return header, events
+}
+
+// recordSession runs a shell with recording enabled and returns its recording
+func recordSession(t *testing.T, recordInput bool) (castHeader, []castEvent) {
+	t.Helper()
+
+	manager := terminal.NewSessionManager()
+	defer manager.Close()
+	opts := testOptions()
+	opts.RecordingDir = t.TempDir()
+	opts.RecordInput = recordInput
+	opts.InitialRows, opts.InitialCols = 30, 100
+	var env []string
+	for _, entry := range opts.Environment {
+		if !strings.HasPrefix(entry, "TERM=") {
+			env = append(env, entry)
+		}
+	}
+	opts.Environment = append(env, "TERM=xterm-256color")
+	terminal.SetAuthToken(opts, "secret")
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		terminal.HandleWebSocketWithOptions(w, r, manager, opts)
+	}))
+	defer server.Close()
+	conn, resp := dialTerminal(t, server, terminal.Message{Type: "auth", Token: "secret"})
+	defer conn.Close()
+	if !resp.Success {
+		t.Fatalf("Failed to start a session: %+v", resp)
+	}
+	var output strings.Builder
+	waitFor := func(want string) {
+		readUntil(t, conn, func(message []byte) bool {
+			output.Write(message)
+			return strings.Contains(output.String(), want)
+		})
+	}
+
+	// A multibyte character written in two parts is recorded whole
+	conn.WriteMessage(websocket.TextMessage, []byte(`printf 'caf\303'; sleep 0.2; printf '\251 rea''dy\n'`+"\n"))
+	waitFor("é ready")
+	conn.WriteJSON(terminal.Message{Type: "resize", Rows: 40, Cols: 120})
+
+	// Input ending in an incomplete character is still recorded when the session ends
+	conn.WriteMessage(websocket.TextMessage, []byte("echo do''ne\n\xe2\x82"))
+	waitFor("done")
+
+	manager.Terminate(resp.SessionID)
+	return readRecording(t, opts.RecordingDir, resp.SessionID)
+}
+
+func TestSessionRecording(t *testing.T) {
+	t.Parallel()
+
+	header, events := recordSession(t, false)
+	if header.Version != 2 || header.Width != 100 || header.Height != 30 {
+		t.Fatalf("Unexpected header %+v", header)
+	}
+	if header.Env["SHELL"] != "/bin/sh" || header.Env["TERM"] != "xterm-256color" {
+		t.Fatalf("Unexpected header env %v", header.Env)
+	}
+
+	var output strings.Builder
+	var resizes []string
+	split := false
+	last := 0.0
+	for i, event := range events {
+		if event.Time < last {
+			t.Fatalf("Events out of order: %+v", events)
+		}
+		last = event.Time
+		switch event.Code {
+		case "o":
+			// Only the echo of the incomplete input sent last is not valid UTF-8
+			if len(resizes) == 0 && strings.ContainsRune(event.Data, '�') {
+				t.Fatalf("Event %d holds a broken character: %q", i, event.Data)
+			}
+			if strings.HasSuffix(event.Data, "caf") && i+1 < len(events) && strings.HasPrefix(events[i+1].Data, "é") {
+				split = true
+			}
+			output.WriteString(event.Data)
+		case "r":
+			// The resize comes after the output it followed
+			if !strings.Contains(output.String(), "é ready") {
+				t.Fatalf("Resize recorded before the output preceding it: %+v", events)
+			}
+			resizes = append(resizes, event.Data)
+		default:
+			t.Fatalf("Unexpected %q event without input recording", event.Code)
+		}
+	}
+	if !split || !strings.Contains(output.String(), "café ready") || !strings.Contains(output.String(), "done") {
+		t.Fatalf("Expected the output with the split character in a single event, got %+v", events)
+	}
+	if len(resizes) != 1 || resizes[0] != "120x40" {
+		t.Fatalf("Expected a single resize to 120x40, got %q", resizes)
+	}
+
+	// Keystrokes are only recorded on request
+	_, events = recordSession(t, true)
+	var input []string
+	for _, event := range events {
+		if event.Code == "i" {
+			input = append(input, event.Data)
+		}
+	}
+	if len(input) != 3 || input[1] != "echo do''ne\n" || input[2] == "" {
+		t.Fatalf("Expected the input and its incomplete trailing character, got %q", input)
+	}
+}
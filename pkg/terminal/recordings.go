@@ -0,0 +1,148 @@
+package terminal
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// validRecordingID matches the session IDs recordings are named after
+var validRecordingID = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// RecordingInfo describes a recorded session
+type RecordingInfo struct {
+	ID         string    `json:"id"`
+	Size       int64     `json:"size"`
+	StartedAt  time.Time `json:"started_at"`
+	ModifiedAt time.Time `json:"modified_at"`
+	Width      uint16    `json:"width"`
+	Height     uint16    `json:"height"`
+}
+
+// ListRecordings returns the recordings stored in dir, most recent first
+func ListRecordings(dir string) ([]RecordingInfo, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []RecordingInfo{}, nil
+		}
+		return nil, fmt.Errorf("failed to read recording directory: %v", err)
+	}
+
+	recordings := []RecordingInfo{}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, recordingExtension) {
+			continue
+		}
+
+		fileInfo, err := entry.Info()
+		if err != nil {
+			continue
+		}
+
+		info := RecordingInfo{
+			ID:         strings.TrimSuffix(name, recordingExtension),
+			Size:       fileInfo.Size(),
+			ModifiedAt: fileInfo.ModTime(),
+		}
+
+		// Fill in details from the asciicast header when it can be read
+		if header, err := readRecordingHeader(filepath.Join(dir, name)); err == nil {
+			info.StartedAt = time.Unix(header.Timestamp, 0)
+			info.Width = header.Width
+			info.Height = header.Height
+		}
+
+		recordings = append(recordings, info)
+	}
+
+	sort.Slice(recordings, func(i, j int) bool {
+		return recordings[i].ModifiedAt.After(recordings[j].ModifiedAt)
+	})
+	return recordings, nil
+}
+
+// readRecordingHeader parses the header line of an asciicast file
+func readRecordingHeader(path string) (*asciicastHeader, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	line, err := bufio.NewReader(file).ReadBytes('\n')
+	if err != nil {
+		return nil, err
+	}
+
+	var header asciicastHeader
+	if err := json.Unmarshal(line, &header); err != nil {
+		return nil, err
+	}
+	return &header, nil
+}
+
+// RecordingsHandler serves the recordings stored in dir.
+// A request for the handler's root returns the list of recordings as JSON and
+// a request for /<id> streams that recording in asciicast format. Mount it with
+// http.StripPrefix and protect it with the application's authentication middleware.
+func RecordingsHandler(dir string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		id := strings.Trim(r.URL.Path, "/")
+
+		// List all recordings
+		if id == "" {
+			recordings, err := ListRecordings(dir)
+			if err != nil {
+				log.Printf("Failed to list recordings: %v", err)
+				http.Error(w, "Failed to list recordings", http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(recordings)
+			return
+		}
+
+		// Only plain session IDs are accepted so requests can't escape the directory
+		id = strings.TrimSuffix(id, recordingExtension)
+		if !validRecordingID.MatchString(id) {
+			http.Error(w, "Invalid recording ID", http.StatusBadRequest)
+			return
+		}
+
+		file, err := os.Open(filepath.Join(dir, id+recordingExtension))
+		if err != nil {
+			if os.IsNotExist(err) {
+				http.Error(w, "Recording not found", http.StatusNotFound)
+			} else {
+				http.Error(w, "Failed to open recording", http.StatusInternalServerError)
+			}
+			return
+		}
+		defer file.Close()
+
+		fileInfo, err := file.Stat()
+		if err != nil {
+			http.Error(w, "Failed to open recording", http.StatusInternalServerError)
+			return
+		}
+
+		// ServeContent handles range requests, so large recordings can be fetched in parts
+		w.Header().Set("Content-Type", "application/x-asciicast")
+		http.ServeContent(w, r, id+recordingExtension, fileInfo.ModTime(), file)
+	})
+}
@@ -0,0 +1,124 @@
+package terminal_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dansun78/go-remote-term/pkg/terminal"
+)
+
+// writeRecordings stores an older and a newer recording in a new directory, next to
+// files that are not recordings
+func writeRecordings(t *testing.T) string {
+	t.Helper()
+
+	parent := t.TempDir()
+	dir := filepath.Join(parent, "recordings")
+	os.Mkdir(dir, 0o700)
+	os.Mkdir(filepath.Join(dir, "nested.cast"), 0o700)
+	os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("not a recording"), 0o600)
+	os.WriteFile(filepath.Join(parent, "x.cast"), []byte("outside the directory"), 0o600)
+
+	now := time.Now()
+	os.WriteFile(filepath.Join(dir, "older.cast"), []byte(`{"version": 2, "width": 80, "height": 24, "timestamp": 1700000000}`+"\n"+
+		`[0.5, "o", "hello"]`+"\n"), 0o600)
+	os.Chtimes(filepath.Join(dir, "older.cast"), now.Add(-time.Hour), now.Add(-time.Hour))
+	os.WriteFile(filepath.Join(dir, "newer.cast"), []byte("not json\n"), 0o600)
+	os.Chtimes(filepath.Join(dir, "newer.cast"), now, now)
+	return dir
+}
+
+func TestListRecordings(t *testing.T) {
+	t.Parallel()
+
+	dir := writeRecordings(t)
+	recordings, err := terminal.ListRecordings(dir)
+	if err != nil {
+		t.Fatalf("Failed to list recordings: %v", err)
+	}
+	if len(recordings) != 2 || recordings[0].ID != "newer" || recordings[1].ID != "older" {
+		t.Fatalf("Expected the recordings most recent first, got %+v", recordings)
+	}
+
+	// Details come from the header when it can be parsed
+	older := recordings[1]
+	if older.Width != 80 || older.Height != 24 || older.StartedAt.Unix() != 1700000000 || older.Size == 0 {
+		t.Fatalf("Unexpected recording info %+v", older)
+	}
+	if newer := recordings[0]; newer.Width != 0 || !newer.StartedAt.IsZero() {
+		t.Fatalf("Expected no details for an invalid header, got %+v", newer)
+	}
+
+	// A directory that does not exist yet has no recordings
+	recordings, err = terminal.ListRecordings(filepath.Join(dir, "missing"))
+	if err != nil || len(recordings) != 0 {
+		t.Fatalf("Expected no recordings, got %+v: %v", recordings, err)
+	}
+}
+
+func TestRecordingsHandler(t *testing.T) {
+	t.Parallel()
+
+	dir := writeRecordings(t)
+	handler := http.StripPrefix("/recordings", terminal.RecordingsHandler(dir))
+	serve := func(method, target string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(method, target, nil))
+		return recorder
+	}
+
+	// The root lists the recordings
+	resp := serve(http.MethodGet, "/recordings/")
+	var recordings []terminal.RecordingInfo
+	if resp.Code != http.StatusOK || json.Unmarshal(resp.Body.Bytes(), &recordings) != nil || len(recordings) != 2 {
+		t.Fatalf("Failed to list recordings: %d %s", resp.Code, resp.Body.String())
+	}
+
+	// A recording is streamed by ID, with or without its extension
+	for _, target := range []string{"/recordings/older", "/recordings/older.cast"} {
+		resp = serve(http.MethodGet, target)
+		want, _ := os.ReadFile(filepath.Join(dir, "older.cast"))
+		if resp.Code != http.StatusOK || resp.Body.String() != string(want) {
+			t.Fatalf("Failed to stream %s: %d %q", target, resp.Code, resp.Body.String())
+		}
+		if contentType := resp.Header().Get("Content-Type"); contentType != "application/x-asciicast" {
+			t.Fatalf("Unexpected content type %q", contentType)
+		}
+	}
+
+	// Ranges can be fetched
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest(http.MethodGet, "/recordings/older", nil)
+	request.Header.Set("Range", "bytes=0-9")
+	handler.ServeHTTP(recorder, request)
+	if body, _ := io.ReadAll(recorder.Body); recorder.Code != http.StatusPartialContent || string(body) != `{"version"` {
+		t.Fatalf("Failed to fetch a range: %d %q", recorder.Code, body)
+	}
+
+	if resp = serve(http.MethodGet, "/recordings/unknown"); resp.Code != http.StatusNotFound {
+		t.Fatalf("Expected 404 for an unknown recording, got %d", resp.Code)
+	}
+
+	// IDs that could escape the directory are refused
+	for _, target := range []string{"/recordings/../x", "/recordings/%2e%2e/x", "/recordings/%2e%2e", "/recordings/a%2fb", "/recordings/nested/older"} {
+		if resp = serve(http.MethodGet, target); resp.Code != http.StatusBadRequest {
+			t.Fatalf("Expected 400 for %s, got %d %q", target, resp.Code, resp.Body.String())
+		}
+	}
+
+	// Recordings are read-only
+	for _, method := range []string{http.MethodPost, http.MethodPut, http.MethodDelete} {
+		if resp = serve(method, "/recordings/older"); resp.Code != http.StatusMethodNotAllowed {
+			t.Fatalf("Expected 405 for %s, got %d", method, resp.Code)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "older.cast")); err != nil {
+		t.Fatalf("Recording is gone: %v", err)
+	}
+}
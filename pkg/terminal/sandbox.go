@@ -0,0 +1,65 @@
+package terminal
+
+// Sandbox runs every session in its own Linux mount, PID and UTS namespaces, and
+// optionally its own network namespace, so that sessions can see neither each
+// other's processes nor, with RootDir or OverlayDir, each other's files.
+//
+// The sandbox is set up by the server binary itself, re-executed as a small init
+// helper inside the new namespaces before it executes the session's command.
+// Programs using Sandbox must therefore call Init at the start of main.
+type Sandbox struct {
+	// RootDir is the directory sessions see as / (default: the host's root directory).
+	// /dev is bound from the host and a fresh /proc is mounted inside it.
+	RootDir string
+
+	// OverlayDir enables a copy-on-write root: each session gets a writable overlay
+	// of RootDir (or of / if RootDir is empty), whose changes are kept in
+	// OverlayDir/<session ID> and discarded when the session ends
+	OverlayDir string
+
+	// Hostname is the host name sessions see (default: "sandbox")
+	Hostname string
+
+	// Network gives each session its own network namespace with only a loopback interface
+	Network bool
+
+	// UserNamespace runs each session in a user namespace in which the session's user
+	// (or the server's own user) is root, so sandboxes can be created without root
+	// privileges where the kernel allows unprivileged user namespaces. Without it the
+	// server needs CAP_SYS_ADMIN.
+	UserNamespace bool
+}
+
+// sandboxInitArg0 is the program name the server is re-executed with as sandbox init
+const sandboxInitArg0 = "go-remote-term-sandbox-init"
+
+// sandboxConfigEnv passes the sandboxConfig to the init helper
+const sandboxConfigEnv = "GO_REMOTE_TERM_SANDBOX"
+
+// defaultSandboxHostname is the host name of sandboxes without a configured one
+const defaultSandboxHostname = "sandbox"
+
+// sandboxConfig tells the init helper how to set up a session's sandbox
+type sandboxConfig struct {
+	Root     string            `json:"root,omitempty"`    // Directory to make the root, empty to keep /
+	Overlay  *overlayConfig    `json:"overlay,omitempty"` // Overlay to mount and make the root
+	Hostname string            `json:"hostname"`
+	Network  bool              `json:"network,omitempty"` // Bring up the loopback interface of a new network namespace
+	Dir      string            `json:"dir,omitempty"`     // Working directory inside the sandbox
+	User     *sandboxPrincipal `json:"user,omitempty"`    // Credentials to drop to once the sandbox is set up
+}
+
+// overlayConfig describes the overlay root of a session
+type overlayConfig struct {
+	Lower  string `json:"lower"`
+	Upper  string `json:"upper"`
+	Work   string `json:"work"`
+	Merged string `json:"merged"`
+}
+
+// sandboxPrincipal holds the credentials the session's command runs with inside the sandbox
+type sandboxPrincipal struct {
+	UID    uint32   `json:"uid"`
+	GID    uint32   `json:"gid"`
+	Groups []uint32 `json:"groups,omitempty"`
+}
@@ -0,0 +1,242 @@
+package terminal
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// sessionSandbox holds what the sandbox of a session leaves on the host
+type sessionSandbox struct {
+	overlayDir string // Per-session overlay layers, empty without an overlay
+}
+
+// prepare makes cmd start through the sandbox init helper in new namespaces
+func (s *Sandbox) prepare(cmd *exec.Cmd, sessionID string) (*sessionSandbox, error) {
+	config := sandboxConfig{
+		Root:     s.RootDir,
+		Hostname: s.Hostname,
+		Network:  s.Network,
+		Dir:      cmd.Dir,
+	}
+	if config.Hostname == "" {
+		config.Hostname = defaultSandboxHostname
+	}
+
+	sandbox := &sessionSandbox{}
+	if s.OverlayDir != "" {
+		sandbox.overlayDir = filepath.Join(s.OverlayDir, sessionID)
+		overlay := &overlayConfig{
+			Lower:  s.RootDir,
+			Upper:  filepath.Join(sandbox.overlayDir, "upper"),
+			Work:   filepath.Join(sandbox.overlayDir, "work"),
+			Merged: filepath.Join(sandbox.overlayDir, "merged"),
+		}
+		if overlay.Lower == "" {
+			overlay.Lower = "/"
+		}
+		for _, dir := range []string{overlay.Upper, overlay.Work, overlay.Merged} {
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				sandbox.remove()
+				return nil, fmt.Errorf("failed to create overlay: %v", err)
+			}
+		}
+		config.Root = ""
+		config.Overlay = overlay
+	}
+
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	attr := cmd.SysProcAttr
+	attr.Cloneflags |= syscall.CLONE_NEWNS | syscall.CLONE_NEWPID | syscall.CLONE_NEWUTS
+	if s.Network {
+		attr.Cloneflags |= syscall.CLONE_NEWNET
+	}
+
+	if s.UserNamespace {
+		// The session's user becomes root of the namespace, which lets init set it up
+		uid, gid := uint32(os.Geteuid()), uint32(os.Getegid())
+		if attr.Credential != nil {
+			uid, gid = attr.Credential.Uid, attr.Credential.Gid
+		}
+		attr.Cloneflags |= syscall.CLONE_NEWUSER
+		attr.UidMappings = []syscall.SysProcIDMap{{ContainerID: 0, HostID: int(uid), Size: 1}}
+		attr.GidMappings = []syscall.SysProcIDMap{{ContainerID: 0, HostID: int(gid), Size: 1}}
+		attr.GidMappingsEnableSetgroups = false
+	} else if attr.Credential != nil {
+		// Init needs root to set up the sandbox and drops to the session's user afterwards
+		config.User = &sandboxPrincipal{
+			UID:    attr.Credential.Uid,
+			GID:    attr.Credential.Gid,
+			Groups: attr.Credential.Groups,
+		}
+	}
+	attr.Credential = nil
+
+	data, err := json.Marshal(config)
+	if err != nil {
+		sandbox.remove()
+		return nil, fmt.Errorf("failed to encode sandbox configuration: %v", err)
+	}
+
+	// The command may only exist inside the sandbox, so it is looked up there by init
+	cmd.Path = "/proc/self/exe"
+	cmd.Args = append([]string{sandboxInitArg0}, cmd.Args...)
+	cmd.Err = nil
+	cmd.Dir = ""
+	cmd.Env = append(cmd.Env, sandboxConfigEnv+"="+string(data))
+	return sandbox, nil
+}
+
+// remove deletes the session's overlay layers
+func (s *sessionSandbox) remove() error {
+	if s.overlayDir == "" {
+		return nil
+	}
+	if err := os.RemoveAll(s.overlayDir); err != nil {
+		return fmt.Errorf("failed to remove overlay: %v", err)
+	}
+	return nil
+}
+
+// runSandboxInit sets up the sandbox described by the environment from inside its
+// namespaces and executes argv in it. It only returns on error.
+func runSandboxInit(argv []string) error {
+	var config sandboxConfig
+	if err := json.Unmarshal([]byte(os.Getenv(sandboxConfigEnv)), &config); err != nil {
+		return fmt.Errorf("invalid configuration: %v", err)
+	}
+	os.Unsetenv(sandboxConfigEnv)
+
+	// Keep the mounts below from propagating back to the host
+	if err := unix.Mount("", "/", "", unix.MS_REC|unix.MS_PRIVATE, ""); err != nil {
+		return fmt.Errorf("failed to make mounts private: %v", err)
+	}
+
+	root := config.Root
+	if config.Overlay != nil {
+		options := fmt.Sprintf("lowerdir=%s,upperdir=%s,workdir=%s",
+			config.Overlay.Lower, config.Overlay.Upper, config.Overlay.Work)
+		if err := unix.Mount("overlay", config.Overlay.Merged, "overlay", 0, options); err != nil {
+			return fmt.Errorf("failed to mount overlay: %v", err)
+		}
+		root = config.Overlay.Merged
+	}
+
+	if root != "" {
+		if err := enterRoot(root); err != nil {
+			return err
+		}
+	} else if err := mountProc("/proc"); err != nil {
+		return err
+	}
+
+	if err := unix.Sethostname([]byte(config.Hostname)); err != nil {
+		return fmt.Errorf("failed to set hostname: %v", err)
+	}
+	if config.Network {
+		if err := bringUpLoopback(); err != nil {
+			return err
+		}
+	}
+
+	if config.User != nil {
+		groups := make([]int, 0, len(config.User.Groups))
+		for _, group := range config.User.Groups {
+			groups = append(groups, int(group))
+		}
+		if err := unix.Setgroups(groups); err != nil {
+			return fmt.Errorf("failed to set groups: %v", err)
+		}
+		if err := unix.Setgid(int(config.User.GID)); err != nil {
+			return fmt.Errorf("failed to set gid: %v", err)
+		}
+		if err := unix.Setuid(int(config.User.UID)); err != nil {
+			return fmt.Errorf("failed to set uid: %v", err)
+		}
+	}
+
+	// Start in the requested directory if it exists inside the sandbox
+	if config.Dir == "" || os.Chdir(config.Dir) != nil {
+		os.Chdir("/")
+	}
+
+	path, err := exec.LookPath(argv[0])
+	if err != nil {
+		return err
+	}
+	return unix.Exec(path, argv, os.Environ())
+}
+
+// enterRoot makes dir the root directory, with the host's /dev and a fresh /proc
+func enterRoot(dir string) error {
+	// pivot_root needs the new root to be a mount point
+	if err := unix.Mount(dir, dir, "", unix.MS_BIND|unix.MS_REC, ""); err != nil {
+		return fmt.Errorf("failed to bind root directory: %v", err)
+	}
+
+	// Share the host's devices, including the session's terminal
+	dev := filepath.Join(dir, "dev")
+	if err := os.MkdirAll(dev, 0755); err != nil {
+		return fmt.Errorf("failed to create /dev: %v", err)
+	}
+	if err := unix.Mount("/dev", dev, "", unix.MS_BIND|unix.MS_REC, ""); err != nil {
+		return fmt.Errorf("failed to bind /dev: %v", err)
+	}
+
+	proc := filepath.Join(dir, "proc")
+	if err := os.MkdirAll(proc, 0555); err != nil {
+		return fmt.Errorf("failed to create /proc: %v", err)
+	}
+	if err := mountProc(proc); err != nil {
+		return err
+	}
+
+	// Stack the new root on top of the old one, then detach the old one
+	if err := os.Chdir(dir); err != nil {
+		return fmt.Errorf("failed to enter root directory: %v", err)
+	}
+	if err := unix.PivotRoot(".", "."); err != nil {
+		return fmt.Errorf("failed to pivot root: %v", err)
+	}
+	if err := unix.Unmount(".", unix.MNT_DETACH); err != nil {
+		return fmt.Errorf("failed to detach old root: %v", err)
+	}
+	return os.Chdir("/")
+}
+
+// mountProc mounts a procfs showing only the processes of the sandbox's PID namespace
+func mountProc(dir string) error {
+	if err := unix.Mount("proc", dir, "proc", unix.MS_NOSUID|unix.MS_NODEV|unix.MS_NOEXEC, ""); err != nil {
+		return fmt.Errorf("failed to mount /proc: %v", err)
+	}
+	return nil
+}
+
+// bringUpLoopback enables the loopback interface of a new network namespace
+func bringUpLoopback() error {
+	fd, err := unix.Socket(unix.AF_INET, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return fmt.Errorf("failed to open socket: %v", err)
+	}
+	defer unix.Close(fd)
+
+	ifreq, err := unix.NewIfreq("lo")
+	if err != nil {
+		return fmt.Errorf("failed to configure loopback: %v", err)
+	}
+	if err := unix.IoctlIfreq(fd, unix.SIOCGIFFLAGS, ifreq); err != nil {
+		return fmt.Errorf("failed to read loopback flags: %v", err)
+	}
+	ifreq.SetUint16(ifreq.Uint16() | unix.IFF_UP)
+	if err := unix.IoctlIfreq(fd, unix.SIOCSIFFLAGS, ifreq); err != nil {
+		return fmt.Errorf("failed to bring up loopback: %v", err)
+	}
+	return nil
+}
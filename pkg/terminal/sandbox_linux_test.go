@@ -0,0 +1,74 @@
+package terminal_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dansun78/go-remote-term/pkg/terminal"
+)
+
+// runSandboxed runs script in a sandboxed session and returns its output
+func runSandboxed(t *testing.T, sandbox *terminal.Sandbox, script string) string {
+	t.Helper()
+
+	manager := terminal.NewSessionManager()
+	defer manager.Close()
+
+	opts := testOptions()
+	opts.Sandbox = sandbox
+	opts.Profiles = []terminal.Profile{{Name: "script", Command: []string{"/bin/sh", "-c", script}}}
+
+	session, err := manager.NewWithProfile(opts, nil, "script")
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+
+	select {
+	case <-session.Done:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("Timed out waiting for the command to exit")
+	}
+	return string(session.BufferedOutput())
+}
+
+func TestSandboxNamespaces(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("Requires root")
+	}
+
+	// The host's processes, such as the test itself, are not visible in the sandbox's /proc
+	output := runSandboxed(t, &terminal.Sandbox{Network: true},
+		fmt.Sprintf(`test -e /proc/%d && echo visible; echo "pid=$$ host=$(hostname)"`, os.Getpid()))
+
+	if !strings.Contains(output, "pid=1 host=sandbox") {
+		t.Errorf("Expected the command to run in its own PID and UTS namespaces, got %q", output)
+	}
+	if strings.Contains(output, "visible") && os.Getpid() > 10 {
+		t.Errorf("Expected the host's processes to be hidden, got %q", output)
+	}
+}
+
+func TestSandboxOverlay(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("Requires root")
+	}
+	overlays := t.TempDir()
+	marker := filepath.Join(t.TempDir(), "marker")
+
+	output := runSandboxed(t, &terminal.Sandbox{OverlayDir: overlays},
+		`echo changed > `+marker+` && cat `+marker)
+
+	if !strings.Contains(output, "changed") {
+		t.Errorf("Expected the overlay to be writable, got %q", output)
+	}
+	if _, err := os.Stat(marker); !os.IsNotExist(err) {
+		t.Errorf("Expected writes in the sandbox to stay out of the host, got %v", err)
+	}
+	if entries, _ := os.ReadDir(overlays); len(entries) != 0 {
+		t.Errorf("Expected the overlay to be discarded when the session ends, found %d entries", len(entries))
+	}
+}
@@ -0,0 +1,32 @@
+//go:build !linux
+
+package terminal
+
+import (
+	"errors"
+	"os/exec"
+)
+
+// errSandboxUnsupported is returned when a sandbox is configured on a platform without namespaces
+var errSandboxUnsupported = errors.New("sandboxes are only supported on Linux")
+
+// sessionSandbox holds what the sandbox of a session leaves on the host
+type sessionSandbox struct {
+	overlayDir string
+}
+
+// prepare makes cmd start through the sandbox init helper in new namespaces
+func (s *Sandbox) prepare(cmd *exec.Cmd, sessionID string) (*sessionSandbox, error) {
+	return nil, errSandboxUnsupported
+}
+
+// remove deletes the session's overlay layers
+func (s *sessionSandbox) remove() error {
+	return nil
+}
+
+// runSandboxInit sets up the sandbox described by the environment from inside its
+// namespaces and executes argv in it. It only returns on error.
+func runSandboxInit(argv []string) error {
+	return errSandboxUnsupported
+}
@@ -0,0 +1,202 @@
+package terminal
+
+import (
+	"bytes"
+	"unicode/utf8"
+)
+
+// DefaultScrollbackBytes is the scrollback capacity used when none is configured
+const DefaultScrollbackBytes = 1024 * 1024
+
+// maxReplaySkip bounds how far into trimmed scrollback we look for a safe replay start
+const maxReplaySkip = 4096
+
+// resetAttributes is prepended to trimmed scrollback so that replay starts from
+// default text attributes rather than whatever was active at the cut
+const resetAttributes = "\x1b[0m"
+
+// Scrollback is a bounded ring buffer holding the most recent terminal output
+// of a session. It keeps at most a fixed number of bytes and, optionally, a
+// fixed number of lines; older output is discarded as new output arrives.
+// Scrollback is not safe for concurrent use; sessions guard it with their lock.
+type Scrollback struct {
+	maxBytes int
+	maxLines int
+
+	buf   []byte // Ring storage, grown on demand up to maxBytes
+	start int    // Index of the oldest byte in buf
+	size  int    // Number of valid bytes in buf
+
+	written  int64   // Total number of bytes ever written
+	newlines []int64 // Absolute offsets of the newlines still held in the buffer
+
+	trimmed     bool // Whether any output has been discarded
+	atLineStart bool // Whether the oldest byte held starts a line
+}
+
+// NewScrollback creates a scrollback buffer holding at most maxBytes bytes
+// and maxLines lines. A maxBytes of 0 uses DefaultScrollbackBytes and a
+// maxLines of 0 disables the line limit.
+func NewScrollback(maxBytes, maxLines int) *Scrollback {
+	if maxBytes <= 0 {
+		maxBytes = DefaultScrollbackBytes
+	}
+	if maxLines < 0 {
+		maxLines = 0
+	}
+	return &Scrollback{
+		maxBytes:    maxBytes,
+		maxLines:    maxLines,
+		atLineStart: true,
+	}
+}
+
+// Write appends terminal output, discarding the oldest output once a limit is reached
+func (s *Scrollback) Write(p []byte) (int, error) {
+	n := len(p)
+	if n == 0 {
+		return 0, nil
+	}
+
+	// Remember where lines end so the line limit and replay boundary can be enforced
+	for i, b := range p {
+		if b == '\n' {
+			s.newlines = append(s.newlines, s.written+int64(i))
+		}
+	}
+	s.written += int64(n)
+
+	// Only the tail of an oversized write can be kept, replacing everything held
+	oversized := len(p) > s.maxBytes
+	if oversized {
+		p = p[len(p)-s.maxBytes:]
+		s.start = 0
+		s.size = 0
+	}
+
+	if s.size+len(p) > len(s.buf) && len(s.buf) < s.maxBytes {
+		s.grow(s.size + len(p))
+	}
+
+	// Copy into the ring, wrapping around the end of the storage
+	capacity := len(s.buf)
+	end := (s.start + s.size) % capacity
+	copied := copy(s.buf[end:], p)
+	copy(s.buf, p[copied:])
+	s.size += len(p)
+
+	// Drop the oldest bytes if the ring overflowed
+	if s.size > capacity || oversized {
+		s.discard(max(s.size-capacity, 0))
+	}
+
+	s.enforceLineLimit()
+
+	return n, nil
+}
+
+// Len returns the number of bytes currently held
+func (s *Scrollback) Len() int {
+	return s.size
+}
+
+// Bytes returns a copy of the held output suitable for replaying to a client.
+// If older output has been discarded, the copy starts at a line or escape
+// sequence boundary so the client terminal is not left in a broken state.
+func (s *Scrollback) Bytes() []byte {
+	data := make([]byte, s.size)
+	s.copyTo(data)
+
+	if !s.trimmed {
+		return data
+	}
+
+	if !s.atLineStart {
+		data = data[safeReplayStart(data):]
+	}
+	return append([]byte(resetAttributes), data...)
+}
+
+// Reset discards all held output
+func (s *Scrollback) Reset() {
+	s.start = 0
+	s.size = 0
+	s.newlines = nil
+	s.trimmed = false
+	s.atLineStart = true
+}
+
+// grow enlarges the ring storage to hold at least need bytes, up to maxBytes
+func (s *Scrollback) grow(need int) {
+	capacity := max(need, 2*len(s.buf), 4096)
+	capacity = min(capacity, s.maxBytes)
+
+	buf := make([]byte, capacity)
+	s.copyTo(buf)
+	s.buf = buf
+	s.start = 0
+}
+
+// copyTo copies the held output, oldest first, into dst
+func (s *Scrollback) copyTo(dst []byte) {
+	if s.size == 0 {
+		return
+	}
+	if s.start+s.size <= len(s.buf) {
+		copy(dst, s.buf[s.start:s.start+s.size])
+		return
+	}
+	n := copy(dst, s.buf[s.start:])
+	copy(dst[n:], s.buf[:s.size-n])
+}
+
+// discard drops the n oldest bytes
+func (s *Scrollback) discard(n int) {
+	s.start = (s.start + n) % len(s.buf)
+	s.size -= n
+	s.trimmed = true
+
+	// Forget newlines that are no longer held, noting whether the cut
+	// landed right after one of them
+	oldest := s.written - int64(s.size)
+	s.atLineStart = false
+	dropped := 0
+	for dropped < len(s.newlines) && s.newlines[dropped] < oldest {
+		s.atLineStart = s.newlines[dropped] == oldest-1
+		dropped++
+	}
+	s.newlines = s.newlines[dropped:]
+}
+
+// enforceLineLimit drops whole lines from the front until at most maxLines remain
+func (s *Scrollback) enforceLineLimit() {
+	if s.maxLines == 0 || len(s.newlines) <= s.maxLines {
+		return
+	}
+
+	// Keep everything after the newline ending the oldest line to drop
+	cut := s.newlines[len(s.newlines)-s.maxLines-1] + 1
+	oldest := s.written - int64(s.size)
+	s.discard(int(cut - oldest))
+}
+
+// safeReplayStart returns the offset in data at which replay can safely begin
+// after older output was discarded: the start of the first complete line, or
+// failing that the start of the next escape sequence or UTF-8 character.
+func safeReplayStart(data []byte) int {
+	window := data[:min(len(data), maxReplaySkip)]
+
+	if i := bytes.IndexByte(window, '\n'); i >= 0 {
+		return i + 1
+	}
+	if i := bytes.IndexByte(window, 0x1b); i >= 0 {
+		return i
+	}
+
+	// Skip the continuation bytes of a character split by the cut
+	i := 0
+	for i < len(data) && i < utf8.UTFMax && !utf8.RuneStart(data[i]) {
+		i++
+	}
+	return i
+}
@@ -0,0 +1,76 @@
+package terminal_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/dansun78/go-remote-term/pkg/terminal"
+)
+
+func TestScrollbackKeepsEverythingUnderLimit(t *testing.T) {
+	sb := terminal.NewScrollback(64, 0)
+	sb.Write([]byte("hello "))
+	sb.Write([]byte("world\n"))
+
+	if got := string(sb.Bytes()); got != "hello world\n" {
+		t.Errorf("Expected %q, got %q", "hello world\n", got)
+	}
+}
+
+func TestScrollbackByteLimit(t *testing.T) {
+	sb := terminal.NewScrollback(16, 0)
+	for i := 0; i < 10; i++ {
+		sb.Write([]byte("line\n"))
+	}
+
+	if sb.Len() != 16 {
+		t.Errorf("Expected 16 bytes held, got %d", sb.Len())
+	}
+
+	// The oldest held byte is in the middle of a line, so replay must skip to
+	// the next line and reset attributes first
+	got := string(sb.Bytes())
+	if got != "\x1b[0mline\nline\nline\n" {
+		t.Errorf("Unexpected replay %q", got)
+	}
+}
+
+func TestScrollbackOversizedWrite(t *testing.T) {
+	sb := terminal.NewScrollback(8, 0)
+	sb.Write([]byte(strings.Repeat("a", 20) + "\nbcdefg"))
+
+	if got := string(sb.Bytes()); got != "\x1b[0mbcdefg" {
+		t.Errorf("Unexpected replay %q", got)
+	}
+}
+
+func TestScrollbackLineLimit(t *testing.T) {
+	sb := terminal.NewScrollback(0, 2)
+	sb.Write([]byte("one\ntwo\nthree\nfour"))
+
+	if got := string(sb.Bytes()); got != "\x1b[0mtwo\nthree\nfour" {
+		t.Errorf("Unexpected replay %q", got)
+	}
+}
+
+func TestScrollbackReplaySkipsPartialEscapeSequence(t *testing.T) {
+	sb := terminal.NewScrollback(12, 0)
+	sb.Write([]byte("ab\x1b[31mred\x1b[0mtext"))
+
+	// The cut lands inside the first escape sequence, so replay starts at the next one
+	got := string(sb.Bytes())
+	if got != "\x1b[0m\x1b[0mtext" {
+		t.Errorf("Unexpected replay %q", got)
+	}
+}
+
+func TestScrollbackReplaySkipsPartialUTF8(t *testing.T) {
+	sb := terminal.NewScrollback(5, 0)
+	sb.Write([]byte("xééé"))
+
+	// Holding 5 of 7 bytes cuts the first two-byte character in half
+	got := string(sb.Bytes())
+	if got != "\x1b[0méé" {
+		t.Errorf("Unexpected replay %q", got)
+	}
+}
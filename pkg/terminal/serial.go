@@ -0,0 +1,236 @@
+package terminal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+)
+
+// ErrUnknownSerialPort is returned when a client requests a serial port that is not configured
+var ErrUnknownSerialPort = errors.New("unknown serial port")
+
+// DefaultBaudRate is the baud rate of serial ports that do not set one
+const DefaultBaudRate = 115200
+
+// Parity settings of serial ports
+const (
+	ParityNone = "none"
+	ParityEven = "even"
+	ParityOdd  = "odd"
+)
+
+// Flow control settings of serial ports
+const (
+	FlowControlNone     = "none"
+	FlowControlHardware = "hardware" // RTS/CTS
+	FlowControlSoftware = "software" // XON/XOFF
+)
+
+// SerialPort is a tty device, such as the console of an embedded board on /dev/ttyUSB0,
+// that sessions can be attached to instead of running a shell. Like targets, ports are
+// only referred to by name by clients, so TerminalOptions.SerialPorts is an allowlist.
+//
+// A port has at most one session at a time, which every client opening the port
+// attaches to. Shared ports accept any number of connections, subject to the ownership
+// of the session like any other; exclusive ports accept a single connection at a time
+// and lock the device against being opened by other programs while the session runs.
+type SerialPort struct {
+	// Name identifies the port in client requests
+	Name string
+
+	// Description is shown to users choosing a port
+	Description string
+
+	// Device is the path of the tty device, e.g. "/dev/ttyUSB0"
+	Device string
+
+	// BaudRate is the line speed (default: 115200)
+	BaudRate int
+
+	// DataBits is the character size, 5 to 8 (default: 8)
+	DataBits int
+
+	// Parity is ParityNone, ParityEven or ParityOdd (default: none)
+	Parity string
+
+	// StopBits is 1 or 2 (default: 1)
+	StopBits int
+
+	// FlowControl is FlowControlNone, FlowControlHardware or FlowControlSoftware (default: none)
+	FlowControl string
+
+	// Exclusive allows a single connection to the port at a time (default: false, shared)
+	Exclusive bool
+}
+
+// serialPortEntry is a single port in a serial ports file
+type serialPortEntry struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Device      string `json:"device"`
+	BaudRate    int    `json:"baud_rate,omitempty"`
+	DataBits    int    `json:"data_bits,omitempty"`
+	Parity      string `json:"parity,omitempty"`
+	StopBits    int    `json:"stop_bits,omitempty"`
+	FlowControl string `json:"flow_control,omitempty"`
+	Exclusive   bool   `json:"exclusive,omitempty"`
+}
+
+// serialPortSummary is what clients are told about a serial port
+type serialPortSummary struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Exclusive   bool   `json:"exclusive,omitempty"`
+}
+
+// LoadSerialPorts loads serial ports from a JSON file holding an array of entries:
+//
+//	[{"name": "board1", "description": "Gateway console", "device": "/dev/ttyUSB0",
+//	  "baud_rate": 115200, "data_bits": 8, "parity": "none", "stop_bits": 1,
+//	  "flow_control": "none", "exclusive": false}]
+//
+// Only name and device are required, the other settings default to 115200 8N1 without
+// flow control, shared between connections.
+func LoadSerialPorts(path string) ([]SerialPort, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read serial ports file: %v", err)
+	}
+
+	var entries []serialPortEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("failed to parse serial ports file: %v", err)
+	}
+
+	ports := make([]SerialPort, 0, len(entries))
+	names := make(map[string]bool, len(entries))
+	for i, entry := range entries {
+		if entry.Name == "" {
+			return nil, fmt.Errorf("serial port %d in %s has no name", i+1, path)
+		}
+		if names[entry.Name] {
+			return nil, fmt.Errorf("duplicate serial port name %q in %s", entry.Name, path)
+		}
+
+		port := SerialPort{
+			Name:        entry.Name,
+			Description: entry.Description,
+			Device:      entry.Device,
+			BaudRate:    entry.BaudRate,
+			DataBits:    entry.DataBits,
+			Parity:      entry.Parity,
+			StopBits:    entry.StopBits,
+			FlowControl: entry.FlowControl,
+			Exclusive:   entry.Exclusive,
+		}
+		if err := port.validate(); err != nil {
+			return nil, err
+		}
+
+		names[entry.Name] = true
+		ports = append(ports, port)
+	}
+	return ports, nil
+}
+
+// validate checks the settings of the port, leaving the baud rates the device supports
+// to be checked when it is opened
+func (p *SerialPort) validate() error {
+	if p.Device == "" {
+		return fmt.Errorf("serial port %q needs a device", p.Name)
+	}
+	if p.BaudRate < 0 {
+		return fmt.Errorf("serial port %q has invalid baud rate %d", p.Name, p.BaudRate)
+	}
+	if bits := p.dataBits(); bits < 5 || bits > 8 {
+		return fmt.Errorf("serial port %q has invalid data bits %d, expected 5 to 8", p.Name, bits)
+	}
+	if parity := p.parity(); parity != ParityNone && parity != ParityEven && parity != ParityOdd {
+		return fmt.Errorf("serial port %q has invalid parity %q, expected none, even or odd", p.Name, parity)
+	}
+	if bits := p.stopBits(); bits != 1 && bits != 2 {
+		return fmt.Errorf("serial port %q has invalid stop bits %d, expected 1 or 2", p.Name, bits)
+	}
+	switch p.flowControl() {
+	case FlowControlNone, FlowControlHardware, FlowControlSoftware:
+	default:
+		return fmt.Errorf("serial port %q has invalid flow control %q, expected none, hardware or software", p.Name, p.FlowControl)
+	}
+	return nil
+}
+
+// baudRate returns the line speed of the port
+func (p *SerialPort) baudRate() int {
+	if p.BaudRate == 0 {
+		return DefaultBaudRate
+	}
+	return p.BaudRate
+}
+
+// dataBits returns the character size of the port
+func (p *SerialPort) dataBits() int {
+	if p.DataBits == 0 {
+		return 8
+	}
+	return p.DataBits
+}
+
+// parity returns the parity of the port
+func (p *SerialPort) parity() string {
+	if p.Parity == "" {
+		return ParityNone
+	}
+	return p.Parity
+}
+
+// stopBits returns the number of stop bits of the port
+func (p *SerialPort) stopBits() int {
+	if p.StopBits == 0 {
+		return 1
+	}
+	return p.StopBits
+}
+
+// flowControl returns the flow control of the port
+func (p *SerialPort) flowControl() string {
+	if p.FlowControl == "" {
+		return FlowControlNone
+	}
+	return p.FlowControl
+}
+
+// lookupSerialPort returns the serial port with the given name from the allowlist in options
+func lookupSerialPort(options *TerminalOptions, name string) (*SerialPort, error) {
+	for i := range options.SerialPorts {
+		if options.SerialPorts[i].Name == name {
+			return &options.SerialPorts[i], nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %q", ErrUnknownSerialPort, name)
+}
+
+// SerialPortsHandler serves the names and descriptions of the given serial ports as
+// JSON, so that clients can offer them when starting a new session. Devices are not disclosed.
+func SerialPortsHandler(ports []SerialPort) http.Handler {
+	summaries := make([]serialPortSummary, 0, len(ports))
+	for _, port := range ports {
+		summaries = append(summaries, serialPortSummary{Name: port.Name, Description: port.Description, Exclusive: port.Exclusive})
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeAPIError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+		writeJSON(w, http.StatusOK, summaries)
+	})
+}
+
+// sessionOptions returns the options of sessions on the port, based on options
+func (p *SerialPort) sessionOptions(options *TerminalOptions) *TerminalOptions {
+	opts := *options
+	opts.Shell = "serial://" + p.Device
+	return &opts
+}
@@ -0,0 +1,179 @@
+package terminal
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// baudRates maps the supported line speeds to their termios constants
+var baudRates = map[int]uint32{
+	50: unix.B50, 75: unix.B75, 110: unix.B110, 134: unix.B134, 150: unix.B150,
+	200: unix.B200, 300: unix.B300, 600: unix.B600, 1200: unix.B1200, 1800: unix.B1800,
+	2400: unix.B2400, 4800: unix.B4800, 9600: unix.B9600, 19200: unix.B19200,
+	38400: unix.B38400, 57600: unix.B57600, 115200: unix.B115200, 230400: unix.B230400,
+	460800: unix.B460800, 500000: unix.B500000, 576000: unix.B576000, 921600: unix.B921600,
+	1000000: unix.B1000000, 1152000: unix.B1152000, 1500000: unix.B1500000,
+	2000000: unix.B2000000, 2500000: unix.B2500000, 3000000: unix.B3000000,
+	3500000: unix.B3500000, 4000000: unix.B4000000,
+}
+
+// characterSizes maps data bits to their termios constants
+var characterSizes = map[int]uint32{5: unix.CS5, 6: unix.CS6, 7: unix.CS7, 8: unix.CS8}
+
+// serialBackend attaches a session to the tty device of a serial port. Nothing runs
+// on the terminal as far as the session knows, so it only ends when it is closed or
+// the device goes away.
+type serialBackend struct {
+	port   *SerialPort
+	device *os.File
+	closed chan struct{} // Closed once the backend has been closed
+
+	closeOnce sync.Once
+}
+
+// newSerialBackend returns a backend attached to port
+func newSerialBackend(port *SerialPort) *serialBackend {
+	return &serialBackend{port: port, closed: make(chan struct{})}
+}
+
+// Start opens the device and configures its line settings. The size of the terminal
+// is unknown to the device and ignored.
+func (b *serialBackend) Start(rows, cols uint16) error {
+	p := b.port
+	// Opening without waiting for carrier, and without making the device the
+	// controlling terminal of the server
+	fd, err := unix.Open(p.Device, unix.O_RDWR|unix.O_NOCTTY|unix.O_NONBLOCK|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return fmt.Errorf("failed to open serial port %q: %v", p.Name, err)
+	}
+	if err := configureSerialPort(fd, p); err != nil {
+		unix.Close(fd)
+		return err
+	}
+	if p.Exclusive {
+		if err := unix.IoctlSetInt(fd, unix.TIOCEXCL, 0); err != nil {
+			unix.Close(fd)
+			return fmt.Errorf("failed to lock serial port %q: %v", p.Name, err)
+		}
+	}
+
+	// The descriptor stays non-blocking so that closing the file interrupts reads
+	b.device = os.NewFile(uintptr(fd), p.Device)
+	return nil
+}
+
+// configureSerialPort puts the tty device fd in raw mode with the line settings of port
+func configureSerialPort(fd int, port *SerialPort) error {
+	baud, ok := baudRates[port.baudRate()]
+	if !ok {
+		return fmt.Errorf("serial port %q has unsupported baud rate %d", port.Name, port.baudRate())
+	}
+	termios, err := unix.IoctlGetTermios(fd, unix.TCGETS)
+	if err != nil {
+		return fmt.Errorf("failed to read settings of serial port %q: %v", port.Name, err)
+	}
+
+	// Raw mode, as cfmakeraw does
+	termios.Iflag &^= unix.IGNBRK | unix.BRKINT | unix.PARMRK | unix.ISTRIP | unix.INLCR | unix.IGNCR |
+		unix.ICRNL | unix.IXON | unix.IXOFF | unix.IXANY | unix.INPCK
+	termios.Oflag &^= unix.OPOST
+	termios.Lflag &^= unix.ECHO | unix.ECHONL | unix.ICANON | unix.ISIG | unix.IEXTEN
+	termios.Cflag &^= unix.CBAUD | unix.CSIZE | unix.PARENB | unix.PARODD | unix.CSTOPB | unix.CRTSCTS
+	termios.Cflag |= unix.CREAD | unix.CLOCAL | baud | characterSizes[port.dataBits()]
+	termios.Ispeed, termios.Ospeed = baud, baud
+	termios.Cc[unix.VMIN] = 1
+	termios.Cc[unix.VTIME] = 0
+
+	switch port.parity() {
+	case ParityEven:
+		termios.Cflag |= unix.PARENB
+		termios.Iflag |= unix.INPCK
+	case ParityOdd:
+		termios.Cflag |= unix.PARENB | unix.PARODD
+		termios.Iflag |= unix.INPCK
+	}
+	if port.stopBits() == 2 {
+		termios.Cflag |= unix.CSTOPB
+	}
+	switch port.flowControl() {
+	case FlowControlHardware:
+		termios.Cflag |= unix.CRTSCTS
+	case FlowControlSoftware:
+		termios.Iflag |= unix.IXON | unix.IXOFF
+	}
+
+	if err := unix.IoctlSetTermios(fd, unix.TCSETS, termios); err != nil {
+		return fmt.Errorf("failed to configure serial port %q: %v", port.Name, err)
+	}
+	// Drop whatever the device received before it was configured
+	unix.IoctlSetInt(fd, unix.TCFLSH, unix.TCIFLUSH)
+	return nil
+}
+
+// Read reads what the device received
+func (b *serialBackend) Read(p []byte) (int, error) {
+	return b.device.Read(p)
+}
+
+// Write sends input to the device
+func (b *serialBackend) Write(p []byte) (int, error) {
+	return b.device.Write(p)
+}
+
+// Resize does nothing, serial lines have no terminal size
+func (b *serialBackend) Resize(rows, cols uint16) error {
+	return nil
+}
+
+// Signal is not supported, there is no process to signal on a serial line
+func (b *serialBackend) Signal(sig syscall.Signal) error {
+	return ErrSignalUnsupported
+}
+
+// Break sends a break condition on the line once the pending output has been sent
+func (b *serialBackend) Break() error {
+	conn, err := b.device.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var breakErr error
+	if err := conn.Control(func(fd uintptr) {
+		breakErr = unix.IoctlSetInt(int(fd), unix.TCSBRK, 0)
+	}); err != nil {
+		return err
+	}
+	if breakErr != nil {
+		return fmt.Errorf("failed to send break to serial port %q: %v", b.port.Name, breakErr)
+	}
+	return nil
+}
+
+// Close unlocks and closes the device
+func (b *serialBackend) Close() error {
+	var err error
+	b.closeOnce.Do(func() {
+		if b.device != nil {
+			if b.port.Exclusive {
+				if conn, connErr := b.device.SyscallConn(); connErr == nil {
+					conn.Control(func(fd uintptr) {
+						unix.IoctlSetInt(int(fd), unix.TIOCNXCL, 0)
+					})
+				}
+			}
+			err = b.device.Close()
+		}
+		close(b.closed)
+	})
+	return err
+}
+
+// Wait waits for the backend to be closed. Nothing the session could observe runs on
+// the device, so the exit status is unknown.
+func (b *serialBackend) Wait() ExitStatus {
+	<-b.closed
+	return unknownExit
+}
@@ -0,0 +1,235 @@
+package terminal_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/creack/pty"
+	"github.com/dansun78/go-remote-term/pkg/terminal"
+	"github.com/gorilla/websocket"
+	"golang.org/x/sys/unix"
+)
+
+// openBoard opens a pseudo-terminal pair standing in for a board on a serial line:
+// the server opens the tty as the device, and the test plays the board on the master
+func openBoard(t *testing.T) (board *os.File, device *os.File) {
+	t.Helper()
+
+	board, device, err := pty.Open()
+	if err != nil {
+		t.Fatalf("Failed to open pseudo-terminal: %v", err)
+	}
+	t.Cleanup(func() {
+		board.Close()
+		device.Close()
+	})
+	return board, device
+}
+
+// readBoard reads what the server sent to the board until it contains want
+func readBoard(t *testing.T, board *os.File, want string) {
+	t.Helper()
+
+	board.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var received []byte
+	buf := make([]byte, 1024)
+	for !strings.Contains(string(received), want) {
+		n, err := board.Read(buf)
+		if err != nil {
+			t.Fatalf("Timed out waiting for %q on the board, got %q: %v", want, received, err)
+		}
+		received = append(received, buf[:n]...)
+	}
+}
+
+// waitForConnections waits until the session has the given number of connections
+func waitForConnections(t *testing.T, session *terminal.TerminalSession, want int) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for session.Info().Connections != want {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected %d connections, got %d", want, session.Info().Connections)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestSerialPortSession(t *testing.T) {
+	t.Parallel()
+
+	board, device := openBoard(t)
+	_, lockedDevice := openBoard(t)
+
+	// Unset settings default to 115200 8N1 without flow control
+	path := filepath.Join(t.TempDir(), "serial.json")
+	os.WriteFile(path, []byte(`[
+		{"name": "gateway", "device": "`+device.Name()+`", "baud_rate": 9600, "stop_bits": 2,
+		 "flow_control": "hardware"},
+		{"name": "locked", "device": "`+lockedDevice.Name()+`", "exclusive": true}
+	]`), 0o600)
+	ports, err := terminal.LoadSerialPorts(path)
+	if err != nil {
+		t.Fatalf("Failed to load serial ports: %v", err)
+	}
+	os.WriteFile(path, []byte(`[{"name": "bad", "device": "/dev/ttyS0", "parity": "mark"}]`), 0o600)
+	if _, err := terminal.LoadSerialPorts(path); err == nil {
+		t.Fatal("Loaded a serial port with invalid parity")
+	}
+
+	manager := terminal.NewSessionManager()
+	defer manager.Close()
+	opts := testOptions()
+	terminal.SetAuthToken(opts, "secret")
+	opts.SerialPorts = ports
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		terminal.HandleWebSocketWithOptions(w, r, manager, opts)
+	}))
+	defer server.Close()
+
+	if _, _, err := manager.OpenSerialPort(opts, nil, "nowhere"); !errors.Is(err, terminal.ErrUnknownSerialPort) {
+		t.Fatalf("Expected ErrUnknownSerialPort, got %v", err)
+	}
+
+	conn, resp := dialTerminal(t, server, terminal.Message{Type: "auth", Token: "secret", SerialPort: "gateway"})
+	defer conn.Close()
+	if !resp.Success {
+		t.Fatalf("Failed to attach to serial port: %+v", resp)
+	}
+	session, _ := manager.Get(resp.SessionID)
+	if info := session.Info(); info.SerialPort != "gateway" || info.Shell != "serial://"+device.Name() {
+		t.Fatalf("Unexpected session info %+v", info)
+	}
+
+	// The device is in raw mode with the port's line settings. Pseudo-terminals always
+	// use 8 data bits without parity, so only the others can be checked.
+	termios, err := unix.IoctlGetTermios(int(device.Fd()), unix.TCGETS)
+	if err != nil {
+		t.Fatalf("Failed to read device settings: %v", err)
+	}
+	if termios.Cflag&unix.CBAUD != unix.B9600 || termios.Cflag&unix.CSTOPB == 0 {
+		t.Fatalf("Expected 9600 baud and 2 stop bits, got cflag %#o", termios.Cflag)
+	}
+	if termios.Cflag&unix.CRTSCTS == 0 || termios.Iflag&unix.IXON != 0 {
+		t.Fatalf("Expected hardware flow control, got cflag %#o iflag %#o", termios.Cflag, termios.Iflag)
+	}
+	if termios.Lflag&(unix.ICANON|unix.ECHO) != 0 {
+		t.Fatalf("Device is not in raw mode, lflag %#o", termios.Lflag)
+	}
+
+	// Output of the board reaches the browser and input reaches the board
+	board.Write([]byte("U-Boot 2024.01\r\n"))
+	readUntil(t, conn, func(message []byte) bool {
+		return strings.Contains(string(message), "U-Boot 2024.01")
+	})
+	conn.WriteMessage(websocket.TextMessage, []byte("printenv\r"))
+	readBoard(t, board, "printenv\r")
+
+	// Writers send breaks with a control message
+	conn.WriteJSON(terminal.Message{Type: "break"})
+	readUntil(t, conn, func(message []byte) bool {
+		var reply terminal.Response
+		if json.Unmarshal(message, &reply) != nil || reply.Type != "break_response" {
+			return false
+		}
+		if !reply.Success {
+			t.Fatalf("Failed to send break: %s", reply.Message)
+		}
+		return true
+	})
+
+	// Shared ports have a single session, which later clients join
+	other, resp := dialTerminal(t, server, terminal.Message{Type: "auth", Token: "secret", SerialPort: "gateway"})
+	defer other.Close()
+	if !resp.Success || resp.SessionID != session.ID {
+		t.Fatalf("Expected to join session %s, got %+v", session.ID, resp)
+	}
+	waitForConnections(t, session, 2)
+
+	// Exclusive ports accept one connection at a time and lock the device
+	first, resp := dialTerminal(t, server, terminal.Message{Type: "auth", Token: "secret", SerialPort: "locked"})
+	if !resp.Success {
+		t.Fatalf("Failed to attach to exclusive serial port: %+v", resp)
+	}
+	locked, _ := manager.Get(resp.SessionID)
+	if exclusive, _ := unix.IoctlGetInt(int(lockedDevice.Fd()), unix.TIOCGEXCL); exclusive != 1 {
+		t.Fatal("Exclusive serial port is not locked")
+	}
+	second, resp := dialTerminal(t, server, terminal.Message{Type: "auth", Token: "secret", SerialPort: "locked"})
+	second.Close()
+	if resp.Success || !strings.Contains(resp.Message, "in use") {
+		t.Fatalf("Second connection to an exclusive serial port was not refused: %+v", resp)
+	}
+
+	// Once the first connection is gone the session is free again
+	first.Close()
+	waitForConnections(t, locked, 0)
+	second, resp = dialTerminal(t, server, terminal.Message{Type: "auth", Token: "secret", SerialPort: "locked"})
+	defer second.Close()
+	if !resp.Success || resp.SessionID != locked.ID {
+		t.Fatalf("Expected to attach to session %s, got %+v", locked.ID, resp)
+	}
+
+	// Ending the session unlocks the device
+	manager.Terminate(locked.ID)
+	if exclusive, _ := unix.IoctlGetInt(int(lockedDevice.Fd()), unix.TIOCGEXCL); exclusive != 0 {
+		t.Fatal("Serial port is still locked after its session ended")
+	}
+}
+
+// principalTokens authenticates tokens as the principals they map to
+type principalTokens map[string]*terminal.Principal
+
+func (p principalTokens) ValidataAuthToken(token string) bool {
+	return p[token] != nil
+}
+
+func (p principalTokens) AuthenticatePrincipal(token string) (*terminal.Principal, bool) {
+	principal, ok := p[token]
+	return principal, ok
+}
+
+func TestSerialPortViewers(t *testing.T) {
+	t.Parallel()
+
+	_, device := openBoard(t)
+	manager := terminal.NewSessionManager()
+	defer manager.Close()
+	opts := testOptions()
+	opts.AuthProvider = principalTokens{
+		"admin":  {Name: "alice", Role: terminal.PrincipalAdmin},
+		"viewer": {Name: "bob", Role: terminal.PrincipalViewer},
+	}
+	opts.SerialPorts = []terminal.SerialPort{{Name: "gateway", Device: device.Name()}}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		terminal.HandleWebSocketWithOptions(w, r, manager, opts)
+	}))
+	defer server.Close()
+
+	// Viewers may not open a port without a session
+	conn, resp := dialTerminal(t, server, terminal.Message{Type: "auth", Token: "viewer", SerialPort: "gateway"})
+	conn.Close()
+	if resp.Success || !strings.Contains(resp.Message, "viewers") || len(manager.List()) != 0 {
+		t.Fatalf("Viewer started a serial port session: %+v", resp)
+	}
+
+	// but may watch the session once someone else started it
+	owner, resp := dialTerminal(t, server, terminal.Message{Type: "auth", Token: "admin", SerialPort: "gateway"})
+	defer owner.Close()
+	if !resp.Success || resp.Role != terminal.RoleOwner {
+		t.Fatalf("Failed to open serial port: %+v", resp)
+	}
+	sessionID := resp.SessionID
+	viewer, resp := dialTerminal(t, server, terminal.Message{Type: "auth", Token: "viewer", SerialPort: "gateway"})
+	defer viewer.Close()
+	if !resp.Success || resp.SessionID != sessionID || resp.Role != terminal.RoleReadOnly {
+		t.Fatalf("Expected to watch session %s, got %+v", sessionID, resp)
+	}
+}
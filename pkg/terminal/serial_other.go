@@ -0,0 +1,61 @@
+//go:build !linux
+
+package terminal
+
+import (
+	"errors"
+	"syscall"
+)
+
+// errSerialUnsupported is returned when a serial port is opened on a platform other than Linux
+var errSerialUnsupported = errors.New("serial ports are only supported on Linux")
+
+// serialBackend attaches a session to the tty device of a serial port
+type serialBackend struct {
+	port *SerialPort
+}
+
+// newSerialBackend returns a backend attached to port
+func newSerialBackend(port *SerialPort) *serialBackend {
+	return &serialBackend{port: port}
+}
+
+// Start opens the device and configures its line settings
+func (b *serialBackend) Start(rows, cols uint16) error {
+	return errSerialUnsupported
+}
+
+// Read reads what the device received
+func (b *serialBackend) Read(p []byte) (int, error) {
+	return 0, errSerialUnsupported
+}
+
+// Write sends input to the device
+func (b *serialBackend) Write(p []byte) (int, error) {
+	return 0, errSerialUnsupported
+}
+
+// Resize does nothing, serial lines have no terminal size
+func (b *serialBackend) Resize(rows, cols uint16) error {
+	return nil
+}
+
+// Signal is not supported, there is no process to signal on a serial line
+func (b *serialBackend) Signal(sig syscall.Signal) error {
+	return ErrSignalUnsupported
+}
+
+// Break sends a break condition on the line
+func (b *serialBackend) Break() error {
+	return errSerialUnsupported
+}
+
+// Close closes the device
+func (b *serialBackend) Close() error {
+	return nil
+}
+
+// Wait returns the unknown exit status of the device
+func (b *serialBackend) Wait() ExitStatus {
+	return unknownExit
+}
@@ -1,9 +1,6 @@
 package terminal
 
 import (
-	"bytes"
-	"encoding/json"
-	"fmt"
 	"io"
 	"log"
 	"os"
@@ -15,131 +12,272 @@ import (
 	"github.com/google/uuid"
 )
 
-// init starts a background routine to cleanup expired sessions
-func init() {
-	go func() {
-		for {
-			time.Sleep(1 * time.Minute)
-			cleanupExpiredSessions()
+// close terminates the session's shell process and releases its PTY, then tells
+// every attached connection how the session ended. end carries the reason; the
+// exit status of the shell is filled in once it has been reaped.
+// It is safe to call close more than once; only the first reason is kept.
+func (session *TerminalSession) close(end SessionEnd) {
+	session.closeOnce.Do(func() {
+		// Hang up the shell and everything started from it, as a real terminal would when
+		// disconnected, and collect its exit status once it is gone
+		if session.Backend != nil {
+			if err := session.Backend.Close(); err != nil {
+				log.Printf("Error closing terminal of session %s: %v", session.ID, err)
+			}
+			status := session.Backend.Wait()
+			end.ExitCode, end.Signal, end.Usage = status.Code, status.Signal, status.Usage
 		}
-	}()
-}
 
-// cleanupExpiredSessions removes sessions that have been inactive longer than their timeout
-func cleanupExpiredSessions() {
-	sessionsLock.Lock()
-	defer sessionsLock.Unlock()
+		// Let go of the terminal held for persistence
+		if session.holder != nil {
+			if err := session.holder.release(); err != nil {
+				log.Printf("Error releasing holder of session %s: %v", session.ID, err)
+			}
+		}
+		end.EndedAt = time.Now()
 
-	now := time.Now()
-	for id, session := range sessions {
 		session.Lock.Lock()
-		lastActive := session.LastActive
-		connections := session.Connections
+		session.end = &end
 		session.Lock.Unlock()
 
-		// If session has no active connections and has exceeded timeout
-		if connections == 0 && now.Sub(lastActive) > session.Options.SessionTimeout {
-			log.Printf("Cleaning up expired session %s (inactive for %v)", id, now.Sub(lastActive))
-			closeSession(id)
+		// Announce the end in-band; connections deliver it before hanging up on Done
+		session.broadcastControl(sessionEndedResponse(session.ID, end))
+		if end.Usage != nil {
+			log.Printf("Session %s ended: %s (%s)", session.ID, end.Describe(), end.Usage)
+		} else {
+			log.Printf("Session %s ended: %s", session.ID, end.Describe())
 		}
-	}
-}
 
-// closeSession terminates and removes a session
-func closeSession(sessionID string) {
-	sessionsLock.Lock()
-	defer sessionsLock.Unlock()
+		// Finish the recording, if any
+		if session.recorder != nil {
+			if err := session.recorder.Close(); err != nil {
+				log.Printf("Error closing recording for session %s: %v", session.ID, err)
+			}
+		}
 
-	session, exists := sessions[sessionID]
-	if !exists {
-		return
+		// Signal done channel
+		close(session.Done)
+	})
+}
+
+// Info returns a snapshot of the session's state
+func (session *TerminalSession) Info() SessionInfo {
+	session.Lock.Lock()
+	defer session.Lock.Unlock()
+
+	info := SessionInfo{
+		ID:           session.ID,
+		Shell:        session.Options.Shell,
+		CreatedAt:    session.CreatedAt,
+		LastActive:   session.LastActive,
+		Connections:  session.Connections,
+		Rows:         session.Rows,
+		Cols:         session.Cols,
+		Participants: session.participantsLocked(),
+		Principal:    session.Principal,
+		Profile:      session.Profile,
+		Target:       session.Target,
+		Container:    session.Container,
+		SerialPort:   session.SerialPort,
+		User:         session.User,
+	}
+	if backend, ok := session.Backend.(*ptyBackend); ok {
+		info.PID = backend.pid()
 	}
+	if session.end != nil {
+		end := *session.end
+		info.End = &end
+	}
+	return info
+}
 
-	// Signal the terminal process to terminate
-	if session.Command != nil && session.Command.Process != nil {
-		session.Command.Process.Signal(syscall.SIGTERM)
+// metrics returns the metrics collector of the session's manager, or nil if it has none
+func (session *TerminalSession) metrics() *sessionMetrics {
+	if session.manager == nil {
+		return nil
 	}
+	return session.manager.metrics
+}
+
+// BufferedOutput returns the scrollback currently held for the session
+func (session *TerminalSession) BufferedOutput() []byte {
+	session.Lock.Lock()
+	defer session.Lock.Unlock()
+
+	return session.OutputBuffer.Bytes()
+}
 
-	// Close the PTY if it exists
-	if session.PTY != nil {
-		session.PTY.Close()
+// Resize changes the terminal dimensions of the session
+func (session *TerminalSession) Resize(rows, cols uint16) error {
+	if err := session.Backend.Resize(rows, cols); err != nil {
+		return err
 	}
 
-	// Signal done channel
-	close(session.Done)
+	session.Lock.Lock()
+	session.Rows = rows
+	session.Cols = cols
+	session.Lock.Unlock()
 
-	// Remove from sessions map
-	delete(sessions, sessionID)
+	if session.recorder != nil {
+		session.recorder.Resize(rows, cols)
+	}
+	return nil
+}
+
+// Signal sends sig to the program running in the session's terminal
+func (session *TerminalSession) Signal(sig syscall.Signal) error {
+	return session.Backend.Signal(sig)
 }
 
-// terminateSession explicitly terminates a session by ID
-func terminateSession(sessionID string) bool {
-	if sessionID == "" {
-		return false
+// SendBreak sends a break condition to the session's terminal, or returns
+// ErrBreakUnsupported if its backend cannot send one
+func (session *TerminalSession) SendBreak() error {
+	breaker, ok := session.Backend.(Breaker)
+	if !ok {
+		return ErrBreakUnsupported
 	}
+	return breaker.Break()
+}
+
+// writeInput writes client input to the terminal and records it. The session is not
+// locked while writing, so a backend that stops accepting input blocks only its writers.
+func (session *TerminalSession) writeInput(data []byte) error {
+	session.inputLock.Lock()
+	defer session.inputLock.Unlock()
 
-	sessionsLock.Lock()
-	_, exists := sessions[sessionID]
-	sessionsLock.Unlock()
+	n, err := session.Backend.Write(data)
+	session.metrics().input(n)
 
-	if !exists {
-		return false
+	session.Lock.Lock()
+	defer session.Lock.Unlock()
+	session.LastActive = time.Now()
+	if err == nil && session.recorder != nil {
+		session.recorder.Input(data)
 	}
+	return err
+}
 
-	log.Printf("Explicitly terminating session %s at user request", sessionID)
-	closeSession(sessionID)
-	return true
+// sessionSpec describes what a new session runs and as whom, beyond its options
+type sessionSpec struct {
+	profile *Profile // Command to run instead of the shell, nil for the shell
+	user    *OSUser  // User to run as, nil for the server's own user
 }
 
 // createNewSession initializes a new terminal session
-func createNewSession(options *TerminalOptions) (*TerminalSession, error) {
+func createNewSession(options *TerminalOptions, spec sessionSpec) (*TerminalSession, error) {
 	// Generate a unique session ID
 	sessionID := uuid.New().String()
 
+	// Sessions of a mapped user get a login environment and its login shell
+	if spec.user != nil {
+		opts := *options
+		opts.Shell = spec.user.Shell
+		opts.Environment = append(append([]string(nil), options.Environment...), spec.user.loginEnvironment()...)
+		options = &opts
+	}
+
+	// Run the profile's command instead of the shell if one was requested
+	argv := []string{options.Shell}
+	if spec.profile != nil {
+		options = spec.profile.sessionOptions(options)
+		argv = spec.profile.Command
+	}
+
 	// Create a new shell command with the specified options
-	cmd := exec.Command(options.Shell)
+	cmd := exec.Command(argv[0], argv[1:]...)
 	cmd.Env = options.Environment
-
-	// Start the command with a pty
-	ptmx, err := pty.Start(cmd)
-	if err != nil {
-		return nil, fmt.Errorf("failed to start PTY: %v", err)
+	if spec.user != nil {
+		if err := spec.user.runAs(cmd); err != nil {
+			return nil, err
+		}
+	}
+	if spec.profile != nil && spec.profile.Dir != "" {
+		cmd.Dir = spec.profile.Dir
 	}
 
-	// Set terminal size to specified dimensions
-	pty.Setsize(ptmx, &pty.Winsize{
-		Rows: options.InitialRows,
-		Cols: options.InitialCols,
-		X:    0,
-		Y:    0,
-	})
+	// Start the command on a local PTY
+	backend := newPTYBackend(sessionID, cmd, options, spec.user)
+	if err := backend.Start(options.InitialRows, options.InitialCols); err != nil {
+		return nil, err
+	}
 
 	// Initialize the terminal session
-	session := &TerminalSession{
-		ID:           sessionID,
-		PTY:          ptmx,
-		Command:      cmd,
-		Options:      options,
-		OutputBuffer: new(bytes.Buffer),
-		LastActive:   time.Now(),
-		Connections:  0,
-		Done:         make(chan struct{}),
+	session := newSession(sessionID, options, backend)
+	if spec.user != nil {
+		session.User = spec.user.Username
 	}
 
 	// Configure the terminal
-	configureTerminal(session)
+	// Setup commands are typed into the PTY, so only the shell gets them
+	if spec.profile == nil {
+		configureTerminal(session)
+	} else {
+		session.Profile = spec.profile.Name
+	}
 
-	// Store in the global sessions map
-	sessionsLock.Lock()
-	sessions[sessionID] = session
-	sessionsLock.Unlock()
+	// Keep the terminal open in a holder process that outlives the server
+	if options.PersistDir != "" {
+		holder, err := startPtyHolder(options.PersistDir, sessionID, backend.pty, backend.pid())
+		if err != nil {
+			session.close(SessionEnd{Reason: EndReasonTerminated})
+			return nil, err
+		}
+		session.holder = holder
+	}
 
-	// Start output buffer routine
-	go bufferTerminalOutput(session)
+	// Start recording once setup output has been discarded
+	if err := session.startRecording(); err != nil {
+		return nil, err
+	}
+	return session, nil
+}
 
+// createBackendSession initializes a new session on the terminal of backend
+func createBackendSession(options *TerminalOptions, backend Backend) (*TerminalSession, error) {
+	if err := backend.Start(options.InitialRows, options.InitialCols); err != nil {
+		return nil, err
+	}
+
+	session := newSession(uuid.New().String(), options, backend)
+	if err := session.startRecording(); err != nil {
+		return nil, err
+	}
 	return session, nil
 }
 
+// newSession returns a session on the started terminal of backend
+func newSession(sessionID string, options *TerminalOptions, backend Backend) *TerminalSession {
+	now := time.Now()
+	return &TerminalSession{
+		ID:           sessionID,
+		Backend:      backend,
+		Options:      options,
+		OutputBuffer: NewScrollback(options.ScrollbackBytes, options.ScrollbackLines),
+		CreatedAt:    now,
+		LastActive:   now,
+		Connections:  0,
+		Rows:         options.InitialRows,
+		Cols:         options.InitialCols,
+		Done:         make(chan struct{}),
+		readerDone:   make(chan struct{}),
+	}
+}
+
+// startRecording starts recording the session if options.RecordingDir is set. The session
+// is closed if the recording cannot be created.
+func (session *TerminalSession) startRecording() error {
+	if session.Options.RecordingDir == "" {
+		return nil
+	}
+	rec, err := newRecorder(session.ID, session.Options)
+	if err != nil {
+		session.close(SessionEnd{Reason: EndReasonTerminated})
+		return err
+	}
+	session.recorder = rec
+	return nil
+}
+
 // configureTerminal sets up the terminal with proper settings
 func configureTerminal(session *TerminalSession) {
 	// Small delay to allow terminal to initialize
@@ -157,22 +295,22 @@ func configureTerminal(session *TerminalSession) {
 
 	// Clear any pending input
 	discardBuf := make([]byte, 1024)
-	session.PTY.Read(discardBuf)
+	session.Backend.Read(discardBuf)
 
 	// Send setup commands safely with delay between them
 	for _, cmd := range setupCommands {
-		_, err := session.PTY.Write([]byte(cmd))
+		_, err := session.Backend.Write([]byte(cmd))
 		if err != nil {
 			log.Println("Error writing setup command:", err)
 		}
 		time.Sleep(50 * time.Millisecond)
 
 		// Discard output from the commands
-		session.PTY.Read(discardBuf)
+		session.Backend.Read(discardBuf)
 	}
 
 	// Explicitly send a newline to force prompt display
-	_, err := session.PTY.Write([]byte("\n"))
+	_, err := session.Backend.Write([]byte("\n"))
 	if err != nil {
 		log.Println("Error triggering prompt:", err)
 	}
@@ -181,70 +319,58 @@ func configureTerminal(session *TerminalSession) {
 	time.Sleep(100 * time.Millisecond)
 }
 
-// bufferTerminalOutput continuously reads from the PTY and adds it to the buffer
+// bufferTerminalOutput continuously reads from the terminal and adds it to the buffer
 func bufferTerminalOutput(session *TerminalSession) {
+	if session.readerDone != nil {
+		defer close(session.readerDone)
+	}
+
 	buf := make([]byte, 1024)
 	for {
 		select {
 		case <-session.Done:
 			return
 		default:
-			n, err := session.PTY.Read(buf)
+			n, err := session.Backend.Read(buf)
 			if err != nil {
+				// The session was handed over to its holder and lives on
+				session.Lock.Lock()
+				detached := session.detached
+				session.Lock.Unlock()
+				if detached {
+					return
+				}
+
 				if err != io.EOF {
 					log.Printf("Error reading from PTY (session %s): %v", session.ID, err)
 				} else {
 					log.Printf("Shell exited for session %s (EOF detected)", session.ID)
 				}
 
-				// When we get EOF or any other error, the shell has likely exited
-				// Send notification first, then terminate the session
-				go func(sessionID string, s *TerminalSession) {
-					log.Printf("Broadcasting shell exit notification for session %s", sessionID)
-
-					// Create termination notification message
-					notification := Response{
-						Type:      "session_ended",
-						Success:   false,
-						Message:   "Shell process has exited",
-						SessionID: sessionID,
-					}
-
-					notificationBytes, _ := json.Marshal(notification)
-
-					// Send the notification through the session's output buffer
-					// while it still exists
-					s.Lock.Lock()
-					connections := s.Connections
-
-					if connections > 0 && s.OutputBuffer != nil {
-						log.Printf("Broadcasting to %d connections", connections)
-						// We'll wrap our JSON in a special marker so it's recognized as JSON
-						// This is needed because we're adding it to the output buffer
-						wrappedMessage := append([]byte("\n<JSON>"), notificationBytes...)
-						wrappedMessage = append(wrappedMessage, []byte("</JSON>\n")...)
-						s.OutputBuffer.Write(wrappedMessage)
-					}
-					s.Lock.Unlock()
-
-					// Give some time for notification to be sent before terminating session
-					time.Sleep(500 * time.Millisecond)
-
-					// Now terminate the session
-					log.Printf("Automatically terminating session %s due to shell exit", sessionID)
-					terminateSession(sessionID)
-				}(session.ID, session)
-
+				// When we get EOF or any other error, the shell has likely exited.
+				// Ending the session reaps it and tells attached clients its exit status.
+				// If the PTY was closed because the session is already ending, this does nothing.
+				if session.manager != nil {
+					session.manager.closeSession(session.ID, SessionEnd{Reason: EndReasonExited})
+				} else {
+					session.close(SessionEnd{Reason: EndReasonExited})
+				}
 				return
 			}
 
+			session.metrics().output(n)
+
 			// Process the output to remove problematic control sequences
 			output := processTerminalOutput(buf[:n])
 
-			// Add to the buffer and update last active time
+			// Add to the buffer, push to attached connections and update last active time
 			if len(output) > 0 {
 				session.Lock.Lock()
 				session.OutputBuffer.Write(output)
+				session.publish(output)
+				if session.recorder != nil {
+					session.recorder.Output(output)
+				}
 				session.LastActive = time.Now()
 				session.Lock.Unlock()
 			}
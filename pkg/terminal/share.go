@@ -0,0 +1,289 @@
+package terminal
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+)
+
+// ParticipantRole is the access level of a connection attached to a session
+type ParticipantRole string
+
+const (
+	// RoleOwner is granted to connections authenticated with a token that controls the session
+	RoleOwner ParticipantRole = "owner"
+	// RoleReadWrite is granted by a read-write share link: the guest may type and resize
+	RoleReadWrite ParticipantRole = "read-write"
+	// RoleReadOnly is granted by a read-only share link and to viewers: they may only watch
+	RoleReadOnly ParticipantRole = "read-only"
+)
+
+// canWrite reports whether connections with the role may send input to the terminal
+func (r ParticipantRole) canWrite() bool {
+	return r == RoleOwner || r == RoleReadWrite
+}
+
+// sessionRole returns the role a principal gets when attaching to a session,
+// or false if the principal may not attach to it
+// A nil principal comes from an auth provider that does not identify principals and may do anything.
+func (p *Principal) sessionRole(session *TerminalSession) (ParticipantRole, bool) {
+	switch {
+	case p == nil || p.Role == PrincipalAdmin:
+		return RoleOwner, true
+	case p.Role == PrincipalViewer:
+		return RoleReadOnly, true
+	case p.Role == PrincipalUser && session.Principal != nil && session.Principal.Name == p.Name:
+		return RoleOwner, true
+	}
+	return "", false
+}
+
+// canCreateSessions reports whether the principal may start new sessions
+func (p *Principal) canCreateSessions() bool {
+	return p == nil || p.Role == PrincipalAdmin || p.Role == PrincipalUser
+}
+
+// ErrCreateDenied is returned when a principal that may only watch sessions would start one
+var ErrCreateDenied = errors.New("viewers can only watch existing sessions")
+
+// ErrSessionNotFound is returned when an operation refers to a session the manager does not own
+var ErrSessionNotFound = errors.New("session not found")
+
+// ErrInvalidShareRole is returned when a share link is requested for a role other than read-only or read-write
+var ErrInvalidShareRole = errors.New("share role must be read-only or read-write")
+
+// ErrSessionBusy is returned when connecting to a session attached exclusively by another connection
+var ErrSessionBusy = errors.New("session is in use by another connection")
+
+// Share grants access to a single session to whoever holds its token
+type Share struct {
+	Token     string          `json:"token"`
+	SessionID string          `json:"session_id"`
+	Role      ParticipantRole `json:"role"`
+	CreatedAt time.Time       `json:"created_at"`
+	ExpiresAt *time.Time      `json:"expires_at,omitempty"` // Nil if the share lasts as long as the session
+}
+
+// expired reports whether the share can no longer be used
+func (s *Share) expired(now time.Time) bool {
+	return s.ExpiresAt != nil && now.After(*s.ExpiresAt)
+}
+
+// Participant describes a connection attached to a session
+type Participant struct {
+	ID          string          `json:"id"`
+	Name        string          `json:"name"`
+	Role        ParticipantRole `json:"role"`
+	ConnectedAt time.Time       `json:"connected_at"`
+}
+
+// CreateShare creates a share link token granting role access to a session.
+// A ttl of zero keeps the share valid until the session ends or the share is revoked.
+func (m *SessionManager) CreateShare(sessionID string, role ParticipantRole, ttl time.Duration) (*Share, error) {
+	if role != RoleReadOnly && role != RoleReadWrite {
+		return nil, ErrInvalidShareRole
+	}
+
+	token, err := generateShareToken()
+	if err != nil {
+		return nil, err
+	}
+
+	share := &Share{
+		Token:     token,
+		SessionID: sessionID,
+		Role:      role,
+		CreatedAt: time.Now(),
+	}
+	if ttl > 0 {
+		expiresAt := share.CreatedAt.Add(ttl)
+		share.ExpiresAt = &expiresAt
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if _, exists := m.sessions[sessionID]; !exists {
+		return nil, ErrSessionNotFound
+	}
+	if m.shares == nil {
+		m.shares = make(map[string]*Share)
+	}
+	m.shares[token] = share
+
+	log.Printf("Created %s share for session %s", role, sessionID)
+
+	copied := *share
+	return &copied, nil
+}
+
+// LookupShare returns the share with the given token if it exists and has not expired
+func (m *SessionManager) LookupShare(token string) (*Share, bool) {
+	if token == "" {
+		return nil, false
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	share, exists := m.shares[token]
+	if !exists {
+		return nil, false
+	}
+	if share.expired(time.Now()) {
+		delete(m.shares, token)
+		return nil, false
+	}
+
+	copied := *share
+	return &copied, true
+}
+
+// Shares returns the unexpired shares of a session, oldest first
+func (m *SessionManager) Shares(sessionID string) []*Share {
+	now := time.Now()
+
+	m.lock.Lock()
+	list := make([]*Share, 0)
+	for token, share := range m.shares {
+		if share.expired(now) {
+			delete(m.shares, token)
+			continue
+		}
+		if share.SessionID == sessionID {
+			copied := *share
+			list = append(list, &copied)
+		}
+	}
+	m.lock.Unlock()
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].CreatedAt.Before(list[j].CreatedAt)
+	})
+	return list
+}
+
+// RevokeShare invalidates a share token so it can no longer be used to attach.
+// Connections that already joined through it stay attached.
+// Returns false if the share does not exist.
+func (m *SessionManager) RevokeShare(token string) bool {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if _, exists := m.shares[token]; !exists {
+		return false
+	}
+	delete(m.shares, token)
+	return true
+}
+
+// removeSharesLocked drops every share of a session
+// The caller must hold m.lock.
+func (m *SessionManager) removeSharesLocked(sessionID string) {
+	for token, share := range m.shares {
+		if share.SessionID == sessionID {
+			delete(m.shares, token)
+		}
+	}
+}
+
+// generateShareToken returns a random URL-safe share token
+func generateShareToken() (string, error) {
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate share token: %v", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// Participants returns the connections attached to the session, in the order they joined
+func (session *TerminalSession) Participants() []Participant {
+	session.Lock.Lock()
+	defer session.Lock.Unlock()
+
+	return session.participantsLocked()
+}
+
+// participantsLocked returns the attached connections in the order they joined
+// The caller must hold session.Lock.
+func (session *TerminalSession) participantsLocked() []Participant {
+	list := make([]Participant, 0, len(session.participants))
+	for _, participant := range session.participants {
+		list = append(list, participant)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].ConnectedAt.Before(list[j].ConnectedAt)
+	})
+	return list
+}
+
+// join attaches a connection to the session: it is counted, subscribed to live output
+// and announced to the other participants. It returns the buffered output to replay to
+// the connection before the live output. The buffer snapshot and the subscription
+// happen under the session lock together, so output produced in between is neither
+// lost nor duplicated, and the presence update is queued behind the replay.
+// Sessions attached exclusively refuse a connection while another is attached.
+func (session *TerminalSession) join(participant Participant) ([]byte, *outputSubscriber, error) {
+	session.Lock.Lock()
+	defer session.Lock.Unlock()
+
+	if session.exclusive && session.Connections > 0 {
+		return nil, nil, ErrSessionBusy
+	}
+	session.Connections++
+	var bufferContents []byte
+	if session.OutputBuffer.Len() > 0 {
+		bufferContents = session.OutputBuffer.Bytes()
+	}
+	sub := session.subscribe()
+	session.addParticipant(participant)
+	return bufferContents, sub, nil
+}
+
+// leave detaches a connection that joined the session and tells the others,
+// returning how many connections remain
+func (session *TerminalSession) leave(participant Participant, sub *outputSubscriber) int {
+	session.unsubscribe(sub)
+
+	session.Lock.Lock()
+	session.Connections--
+	session.LastActive = time.Now()
+	session.removeParticipant(participant.ID)
+	remaining := session.Connections
+	session.Lock.Unlock()
+
+	session.metrics().connectionClosed(time.Since(participant.ConnectedAt))
+	return remaining
+}
+
+// addParticipant registers an attached connection and tells everyone who is now present
+// The caller must hold session.Lock.
+func (session *TerminalSession) addParticipant(participant Participant) {
+	if session.participants == nil {
+		session.participants = make(map[string]Participant)
+	}
+	session.participants[participant.ID] = participant
+	session.publishPresence()
+}
+
+// removeParticipant unregisters a detached connection and tells everyone who is left
+// The caller must hold session.Lock.
+func (session *TerminalSession) removeParticipant(id string) {
+	delete(session.participants, id)
+	session.publishPresence()
+}
+
+// publishPresence sends the current participant list to every attached connection
+// The caller must hold session.Lock.
+func (session *TerminalSession) publishPresence() {
+	session.publishEvent(sessionEvent{Control: &Response{
+		Type:         "presence",
+		Success:      true,
+		SessionID:    session.ID,
+		Participants: session.participantsLocked(),
+	}})
+}
@@ -0,0 +1,99 @@
+package terminal_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dansun78/go-remote-term/pkg/terminal"
+	"github.com/gorilla/websocket"
+)
+
+func TestShareReadOnlyGuest(t *testing.T) {
+	t.Parallel()
+
+	manager := terminal.NewSessionManager()
+	defer manager.Close()
+
+	opts := testOptions()
+	terminal.SetAuthToken(opts, "secret")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		terminal.HandleWebSocketWithOptions(w, r, manager, opts)
+	}))
+	defer server.Close()
+
+	owner, resp := dialTerminal(t, server, terminal.Message{Type: "auth", Token: "secret"})
+	defer owner.Close()
+	if !resp.Success || resp.Role != terminal.RoleOwner {
+		t.Fatalf("Unexpected owner auth response: %+v", resp)
+	}
+	sessionID := resp.SessionID
+
+	share, err := manager.CreateShare(sessionID, terminal.RoleReadOnly, time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to create share: %v", err)
+	}
+
+	guest, resp := dialTerminal(t, server, terminal.Message{Type: "auth", Share: share.Token})
+	if !resp.Success || resp.Role != terminal.RoleReadOnly || resp.SessionID != sessionID {
+		t.Fatalf("Unexpected guest auth response: %+v", resp)
+	}
+
+	// The guest is told that both participants are attached
+	readUntil(t, guest, func(message []byte) bool {
+		var presence terminal.Response
+		return json.Unmarshal(message, &presence) == nil && presence.Type == "presence" &&
+			len(presence.Participants) == 2
+	})
+
+	// Input from the read-only guest is dropped
+	guest.WriteMessage(websocket.TextMessage, []byte("echo guest-input\n"))
+	guest.Close()
+
+	session, _ := manager.Get(sessionID)
+	deadline := time.Now().Add(5 * time.Second)
+	for session.Info().Connections > 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Guest connection was not closed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	owner.WriteMessage(websocket.TextMessage, []byte("echo owner-input\n"))
+	var output strings.Builder
+	readUntil(t, owner, func(message []byte) bool {
+		output.Write(message)
+		return strings.Contains(output.String(), "\nowner-input")
+	})
+	if strings.Contains(output.String(), "guest-input") {
+		t.Errorf("Input from a read-only guest reached the terminal: %q", output.String())
+	}
+
+	// Share links do not outlive their session
+	manager.Terminate(sessionID)
+	if _, ok := manager.LookupShare(share.Token); ok {
+		t.Errorf("Expected share to be removed with its session")
+	}
+}
+
+func TestShareRejectsInvalidRole(t *testing.T) {
+	t.Parallel()
+
+	manager := terminal.NewSessionManager()
+	defer manager.Close()
+
+	session, err := manager.New(testOptions())
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+
+	if _, err := manager.CreateShare(session.ID, terminal.RoleOwner, 0); err != terminal.ErrInvalidShareRole {
+		t.Errorf("Expected ErrInvalidShareRole, got %v", err)
+	}
+	if _, err := manager.CreateShare("missing", terminal.RoleReadOnly, 0); err != terminal.ErrSessionNotFound {
+		t.Errorf("Expected ErrSessionNotFound, got %v", err)
+	}
+}
@@ -0,0 +1,647 @@
+package terminal
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"golang.org/x/crypto/ssh"
+)
+
+// Reasons recorded in the auth failure metric for SSH clients, in addition to the token ones
+const (
+	authFailureUnknownKey = "unknown_key"
+)
+
+// Permissions extensions carrying the principal an SSH connection authenticated as
+const (
+	sshPrincipalName = "go-remote-term-principal-name"
+	sshPrincipalRole = "go-remote-term-principal-role"
+)
+
+// SSHServer serves terminal sessions to native SSH clients. Clients authenticate with
+// a token as their password, validated by Options.AuthProvider like the tokens of
+// WebSocket clients, or with a key listed in AuthorizedKeys. The SSH user name is ignored.
+//
+// A shell request starts a new session running the shell, and the exec commands
+// "profile <name>", "target <name>", "container <name>", "serial <name>" and
+// "attach <session ID>" start a session running a profile, on an SSH target, in a
+// container or on a serial port, or attach to an existing session, e.g. one started from
+// a browser, which then share the shell. Break requests are passed on to the session:
+//
+//	ssh -t -p 2222 host attach 6f1c...
+//
+// Sessions are created in and looked up from Manager, just like those of WebSocket
+// clients, and keep running when the SSH client disconnects.
+type SSHServer struct {
+	Manager        *SessionManager
+	Options        *TerminalOptions
+	HostKey        ssh.Signer
+	AuthorizedKeys *AuthorizedKeys // Keys clients may authenticate with, nil to only accept tokens
+
+	lock      sync.Mutex
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+	closed    bool
+}
+
+// NewSSHServer creates an SSH server for the sessions of manager
+func NewSSHServer(manager *SessionManager, options *TerminalOptions, hostKey ssh.Signer) *SSHServer {
+	return &SSHServer{
+		Manager: manager,
+		Options: options,
+		HostKey: hostKey,
+	}
+}
+
+// ErrSSHServerClosed is returned by Serve once the server has been closed
+var ErrSSHServerClosed = errors.New("ssh: server closed")
+
+// Serve accepts SSH connections on listener until the server is closed
+func (s *SSHServer) Serve(listener net.Listener) error {
+	if !s.track(listener, nil) {
+		listener.Close()
+		return ErrSSHServerClosed
+	}
+	defer s.untrack(listener, nil)
+
+	config := s.serverConfig()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			s.lock.Lock()
+			closed := s.closed
+			s.lock.Unlock()
+			if closed {
+				return ErrSSHServerClosed
+			}
+			return err
+		}
+		if !s.track(nil, conn) {
+			conn.Close()
+			return ErrSSHServerClosed
+		}
+		go func() {
+			defer s.untrack(nil, conn)
+			s.handleConn(conn, config)
+		}()
+	}
+}
+
+// Close stops accepting connections and disconnects every SSH client. Sessions keep
+// running; shut them down with the manager.
+func (s *SSHServer) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.closed = true
+	for listener := range s.listeners {
+		listener.Close()
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+	return nil
+}
+
+// track registers a listener or connection to be closed by Close, unless the server is already closed
+func (s *SSHServer) track(listener net.Listener, conn net.Conn) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.closed {
+		return false
+	}
+	if listener != nil {
+		if s.listeners == nil {
+			s.listeners = make(map[net.Listener]struct{})
+		}
+		s.listeners[listener] = struct{}{}
+	}
+	if conn != nil {
+		if s.conns == nil {
+			s.conns = make(map[net.Conn]struct{})
+		}
+		s.conns[conn] = struct{}{}
+	}
+	return true
+}
+
+// untrack forgets a listener or connection that has been closed
+func (s *SSHServer) untrack(listener net.Listener, conn net.Conn) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	delete(s.listeners, listener)
+	delete(s.conns, conn)
+}
+
+// serverConfig builds the SSH configuration authenticating clients with tokens and keys
+func (s *SSHServer) serverConfig() *ssh.ServerConfig {
+	metrics := s.Manager.metrics
+
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(meta ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			token := string(password)
+			if token == "" {
+				metrics.authFailure(authFailureMissingToken)
+				return nil, errors.New("missing token")
+			}
+			if provider, ok := s.Options.AuthProvider.(PrincipalAuthProvider); ok {
+				principal, valid := provider.AuthenticatePrincipal(token)
+				if !valid {
+					log.Printf("SSH authentication of %s failed: Invalid token", meta.RemoteAddr())
+					metrics.authFailure(authFailureInvalidToken)
+					return nil, errors.New("invalid token")
+				}
+				return principalPermissions(principal), nil
+			}
+			if s.Options.AuthProvider != nil && !s.Options.AuthProvider.ValidataAuthToken(token) {
+				log.Printf("SSH authentication of %s failed: Invalid token", meta.RemoteAddr())
+				metrics.authFailure(authFailureInvalidToken)
+				return nil, errors.New("invalid token")
+			}
+			return principalPermissions(nil), nil
+		},
+	}
+	if s.AuthorizedKeys != nil {
+		config.PublicKeyCallback = func(meta ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			principal, ok := s.AuthorizedKeys.Lookup(key)
+			if !ok {
+				metrics.authFailure(authFailureUnknownKey)
+				return nil, errors.New("unknown key")
+			}
+			return principalPermissions(principal), nil
+		}
+	}
+	config.AddHostKey(s.HostKey)
+	return config
+}
+
+// principalPermissions records the principal a connection authenticated as
+func principalPermissions(principal *Principal) *ssh.Permissions {
+	if principal == nil {
+		return &ssh.Permissions{}
+	}
+	return &ssh.Permissions{Extensions: map[string]string{
+		sshPrincipalName: principal.Name,
+		sshPrincipalRole: principal.Role,
+	}}
+}
+
+// permissionsPrincipal returns the principal recorded by principalPermissions
+func permissionsPrincipal(permissions *ssh.Permissions) *Principal {
+	if permissions == nil {
+		return nil
+	}
+	name, ok := permissions.Extensions[sshPrincipalName]
+	if !ok {
+		return nil
+	}
+	return &Principal{Name: name, Role: permissions.Extensions[sshPrincipalRole]}
+}
+
+// handleConn runs the SSH handshake on a new connection and serves its session channels
+func (s *SSHServer) handleConn(netConn net.Conn, config *ssh.ServerConfig) {
+	conn, channels, requests, err := ssh.NewServerConn(netConn, config)
+	if err != nil {
+		log.Printf("SSH handshake with %s failed: %v", netConn.RemoteAddr(), err)
+		netConn.Close()
+		return
+	}
+	defer conn.Close()
+	go ssh.DiscardRequests(requests)
+
+	principal := permissionsPrincipal(conn.Permissions)
+	name := remoteHostOf(conn.RemoteAddr())
+	if principal != nil {
+		name = principal.Name
+	}
+
+	for newChannel := range channels {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "only session channels are supported")
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			log.Printf("Failed to accept SSH channel from %s: %v", name, err)
+			continue
+		}
+		go s.handleChannel(channel, requests, principal, name)
+	}
+}
+
+// remoteHostOf returns the host of a network address, without the port
+func remoteHostOf(addr net.Addr) string {
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
+
+// SSH request payloads, see RFC 4254
+type (
+	sshPtyRequest struct {
+		Term   string
+		Cols   uint32
+		Rows   uint32
+		Width  uint32
+		Height uint32
+		Modes  string
+	}
+	sshWindowChange struct {
+		Cols   uint32
+		Rows   uint32
+		Width  uint32
+		Height uint32
+	}
+	sshExecRequest struct {
+		Command string
+	}
+	sshExitStatus struct {
+		Status uint32
+	}
+	sshExitSignal struct {
+		Signal     string
+		CoreDumped bool
+		Error      string
+		Lang       string
+	}
+)
+
+// handleChannel serves the requests of an SSH session channel: the terminal size,
+// then the shell or exec request selecting the session to attach the channel to
+func (s *SSHServer) handleChannel(channel ssh.Channel, requests <-chan *ssh.Request, principal *Principal, name string) {
+	var rows, cols uint16
+	var session *TerminalSession
+	var role ParticipantRole
+
+	// The request channel is closed once the client closes the channel
+	closed := make(chan struct{})
+	defer close(closed)
+
+	for req := range requests {
+		switch req.Type {
+		case "pty-req":
+			var ptyReq sshPtyRequest
+			if err := ssh.Unmarshal(req.Payload, &ptyReq); err != nil {
+				req.Reply(false, nil)
+				continue
+			}
+			rows, cols = uint16(ptyReq.Rows), uint16(ptyReq.Cols)
+			req.Reply(true, nil)
+
+		case "window-change":
+			var change sshWindowChange
+			if err := ssh.Unmarshal(req.Payload, &change); err != nil {
+				continue
+			}
+			rows, cols = uint16(change.Rows), uint16(change.Cols)
+			// Read-only viewers follow the writers' size
+			if session != nil && role.canWrite() && rows > 0 && cols > 0 {
+				session.Resize(rows, cols)
+			}
+
+		case "shell", "exec":
+			if session != nil {
+				req.Reply(false, nil)
+				continue
+			}
+			var command string
+			if req.Type == "exec" {
+				var execReq sshExecRequest
+				if err := ssh.Unmarshal(req.Payload, &execReq); err != nil {
+					req.Reply(false, nil)
+					continue
+				}
+				command = execReq.Command
+			}
+			req.Reply(true, nil)
+
+			var end *SessionEnd
+			var err error
+			session, role, end, err = s.openSession(principal, name, command)
+			switch {
+			case end != nil:
+				fmt.Fprintf(channel.Stderr(), "Session has ended: %s\r\n", end.Describe())
+				sendSSHExit(channel, *end)
+				channel.Close()
+			case err != nil:
+				fmt.Fprintf(channel.Stderr(), "%v\r\n", err)
+				channel.SendRequest("exit-status", false, ssh.Marshal(sshExitStatus{Status: 1}))
+				channel.Close()
+			default:
+				if role.canWrite() && rows > 0 && cols > 0 {
+					session.Resize(rows, cols)
+				}
+				participant := Participant{
+					ID:          uuid.New().String(),
+					Name:        name,
+					Role:        role,
+					ConnectedAt: time.Now(),
+				}
+				go serveSSHChannel(channel, closed, session, participant)
+			}
+
+		case "break":
+			// Breaks are only sent by writers, see RFC 4335
+			ok := session != nil && role.canWrite() && session.SendBreak() == nil
+			if req.WantReply {
+				req.Reply(ok, nil)
+			}
+
+		default:
+			// Environment variables, subsystems and agent forwarding are not supported
+			if req.WantReply {
+				req.Reply(false, nil)
+			}
+		}
+	}
+}
+
+// openSession starts or looks up the session an SSH shell or exec request asks for.
+// It returns how the session ended instead if the client asked for a session that has just ended.
+func (s *SSHServer) openSession(principal *Principal, name, command string) (*TerminalSession, ParticipantRole, *SessionEnd, error) {
+	verb, argument, _ := strings.Cut(strings.TrimSpace(command), " ")
+	argument = strings.TrimSpace(argument)
+
+	switch verb {
+	case "attach":
+		if argument == "" {
+			return nil, "", nil, errors.New("usage: attach <session ID>")
+		}
+		session, exists := s.Manager.Get(argument)
+		if !exists {
+			if end, ended := s.Manager.Ended(argument); ended {
+				return nil, "", end, nil
+			}
+			return nil, "", nil, fmt.Errorf("session %s not found", argument)
+		}
+		role, allowed := principal.sessionRole(session)
+		if !allowed {
+			log.Printf("Denied SSH client %s access to session %s", name, session.ID)
+			return nil, "", nil, errors.New("permission denied: session belongs to another user")
+		}
+		log.Printf("SSH client %s attaching to session %s as %s", name, session.ID, role)
+		return session, role, nil, nil
+
+	case "serial":
+		if argument == "" {
+			return nil, "", nil, errors.New("usage: serial <name>")
+		}
+		// Viewers may watch the port's session, but not start one
+		session, created, err := s.Manager.OpenSerialPort(s.Options, principal, argument)
+		if errors.Is(err, ErrCreateDenied) {
+			log.Printf("Denied SSH client %s opening serial port %s", name, argument)
+			return nil, "", nil, errors.New("permission denied: viewers can only watch existing sessions")
+		}
+		if err != nil {
+			return nil, "", nil, fmt.Errorf("failed to create terminal: %v", err)
+		}
+		if created {
+			log.Printf("Created new terminal session %s on serial port %s for SSH client %s", session.ID, session.SerialPort, name)
+			return session, RoleOwner, nil, nil
+		}
+		// A serial port has a single session, which later clients attach to
+		role, allowed := principal.sessionRole(session)
+		if !allowed {
+			log.Printf("Denied SSH client %s access to serial port %s", name, session.SerialPort)
+			return nil, "", nil, errors.New("permission denied: serial port is in use by another user")
+		}
+		log.Printf("SSH client %s attaching to session %s on serial port %s as %s", name, session.ID, session.SerialPort, role)
+		return session, role, nil, nil
+
+	case "", "profile", "target", "container":
+		if !principal.canCreateSessions() {
+			log.Printf("Denied SSH client %s creating a session", name)
+			return nil, "", nil, errors.New("permission denied: viewers can only watch existing sessions")
+		}
+		var session *TerminalSession
+		var err error
+		switch {
+		case verb != "" && verb != "profile" && argument == "":
+			return nil, "", nil, fmt.Errorf("usage: %s <name>", verb)
+		case verb == "target":
+			session, err = s.Manager.NewOnTarget(s.Options, principal, argument)
+		case verb == "container":
+			session, err = s.Manager.NewInContainer(s.Options, principal, argument)
+		default:
+			session, err = s.Manager.NewWithProfile(s.Options, principal, argument)
+		}
+		if err != nil {
+			return nil, "", nil, fmt.Errorf("failed to create terminal: %v", err)
+		}
+		log.Printf("Created new terminal session %s for SSH client %s", session.ID, name)
+		return session, RoleOwner, nil, nil
+	}
+	return nil, "", nil, fmt.Errorf("unknown command %q, expected \"attach <session ID>\", \"profile <name>\", \"target <name>\", \"container <name>\" or \"serial <name>\"", verb)
+}
+
+// serveSSHChannel relays a session to an SSH channel until the client closes the
+// channel or the session ends
+func serveSSHChannel(channel ssh.Channel, closed <-chan struct{}, session *TerminalSession, participant Participant) {
+	defer channel.Close()
+
+	replay, sub, err := session.join(participant)
+	if err != nil {
+		fmt.Fprintf(channel.Stderr(), "Failed to attach to session: %v\r\n", err)
+		channel.SendRequest("exit-status", false, ssh.Marshal(sshExitStatus{Status: 1}))
+		return
+	}
+	if len(replay) > 0 {
+		channel.Write(replay)
+	}
+
+	// SSH channel input to terminal. The end of the input does not detach the client,
+	// which keeps receiving output until it closes the channel.
+	go func() {
+		buf := make([]byte, 32*1024)
+		for {
+			n, err := channel.Read(buf)
+			// Input from read-only viewers is dropped
+			if n > 0 && participant.Role.canWrite() {
+				if err := session.writeInput(buf[:n]); err != nil {
+					log.Println("Error writing to PTY:", err)
+					return
+				}
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	// Terminal output to the SSH channel
+	for ended := false; !ended; {
+		select {
+		case <-closed:
+			ended = true
+		case <-session.Done:
+			// Deliver whatever was queued before the session ended, then hang up
+			for drained := false; !drained; {
+				select {
+				case event, ok := <-sub.C:
+					drained = !ok || !writeSSHEvent(channel, event)
+				default:
+					drained = true
+				}
+			}
+			ended = true
+		case event, ok := <-sub.C:
+			// A dropped subscriber is disconnected, like WebSocket clients are
+			ended = !ok || !writeSSHEvent(channel, event)
+		}
+	}
+
+	remaining := session.leave(participant, sub)
+	log.Printf("SSH connection of %s closed for session %s, remaining connections: %d",
+		participant.Name, session.ID, remaining)
+}
+
+// writeSSHEvent writes a session event to an SSH channel and reports whether the
+// channel should stay attached
+func writeSSHEvent(channel ssh.Channel, event sessionEvent) bool {
+	if event.Control == nil {
+		_, err := channel.Write(event.Output)
+		return err == nil
+	}
+
+	switch event.Control.Type {
+	case "session_ended":
+		fmt.Fprintf(channel, "\r\n[%s]\r\n", event.Control.Message)
+		if event.Control.End != nil {
+			sendSSHExit(channel, *event.Control.End)
+		}
+		return false
+	case "server_shutdown", "server_restart":
+		fmt.Fprintf(channel, "\r\n[%s]\r\n", event.Control.Message)
+	}
+	return true
+}
+
+// sendSSHExit tells the SSH client how the session's shell exited
+func sendSSHExit(channel ssh.Channel, end SessionEnd) {
+	if end.Signal != "" {
+		channel.SendRequest("exit-signal", false, ssh.Marshal(sshExitSignal{
+			Signal: strings.TrimPrefix(end.Signal, "SIG"),
+			Error:  end.Describe(),
+		}))
+		return
+	}
+	status := uint32(end.ExitCode)
+	if end.ExitCode < 0 {
+		status = 255
+	}
+	channel.SendRequest("exit-status", false, ssh.Marshal(sshExitStatus{Status: status}))
+}
+
+// AuthorizedKeys holds the public keys SSH clients may authenticate with, loaded from
+// an authorized_keys file. The comment of each key names the principal it belongs to
+// and an optional role="admin|user|viewer" option sets the principal's role (default: user):
+//
+//	role="admin" ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAA... alice
+type AuthorizedKeys struct {
+	path string
+	lock sync.RWMutex
+	keys map[string]*Principal // By marshaled public key
+}
+
+// LoadAuthorizedKeys loads an authorized_keys file
+func LoadAuthorizedKeys(path string) (*AuthorizedKeys, error) {
+	keys := &AuthorizedKeys{path: path}
+	if err := keys.Reload(); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// Reload re-reads the authorized_keys file. On error the previously loaded keys stay in effect.
+func (k *AuthorizedKeys) Reload() error {
+	data, err := os.ReadFile(k.path)
+	if err != nil {
+		return fmt.Errorf("failed to read authorized keys: %v", err)
+	}
+
+	keys := make(map[string]*Principal)
+	for i, line := range bytes.Split(data, []byte("\n")) {
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		key, comment, options, _, err := ssh.ParseAuthorizedKey(line)
+		if err != nil {
+			return fmt.Errorf("failed to parse line %d of %s: %v", i+1, k.path, err)
+		}
+		if comment == "" {
+			return fmt.Errorf("key on line %d of %s has no comment naming its principal", i+1, k.path)
+		}
+
+		principal := &Principal{Name: comment, Role: PrincipalUser}
+		for _, option := range options {
+			if value, ok := strings.CutPrefix(option, "role="); ok {
+				principal.Role = strings.Trim(value, `"`)
+			}
+		}
+		switch principal.Role {
+		case PrincipalAdmin, PrincipalUser, PrincipalViewer:
+		default:
+			return fmt.Errorf("key on line %d of %s has unknown role %q", i+1, k.path, principal.Role)
+		}
+		keys[string(key.Marshal())] = principal
+	}
+
+	k.lock.Lock()
+	k.keys = keys
+	k.lock.Unlock()
+	return nil
+}
+
+// Lookup returns the principal a public key belongs to
+func (k *AuthorizedKeys) Lookup(key ssh.PublicKey) (*Principal, bool) {
+	k.lock.RLock()
+	defer k.lock.RUnlock()
+
+	principal, ok := k.keys[string(key.Marshal())]
+	return principal, ok
+}
+
+// LoadHostKey reads the SSH host key at path, generating and saving a new ed25519
+// key there if the file does not exist yet
+func LoadHostKey(path string) (ssh.Signer, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		_, private, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate host key: %v", err)
+		}
+		block, err := ssh.MarshalPrivateKey(private, "")
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode host key: %v", err)
+		}
+		data = pem.EncodeToMemory(block)
+		if err := os.WriteFile(path, data, 0600); err != nil {
+			return nil, fmt.Errorf("failed to save host key: %v", err)
+		}
+		log.Printf("Generated SSH host key %s", path)
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read host key: %v", err)
+	}
+
+	signer, err := ssh.ParsePrivateKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse host key: %v", err)
+	}
+	return signer, nil
+}
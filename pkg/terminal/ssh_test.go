@@ -0,0 +1,239 @@
+package terminal_test
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dansun78/go-remote-term/pkg/terminal"
+	"github.com/gorilla/websocket"
+	"golang.org/x/crypto/ssh"
+)
+
+// startSSHServer serves the sessions of manager over SSH on a local port
+func startSSHServer(t *testing.T, manager *terminal.SessionManager, opts *terminal.TerminalOptions, keys *terminal.AuthorizedKeys) string {
+	t.Helper()
+
+	hostKey, err := terminal.LoadHostKey(filepath.Join(t.TempDir(), "host_key"))
+	if err != nil {
+		t.Fatalf("Failed to create host key: %v", err)
+	}
+	server := terminal.NewSSHServer(manager, opts, hostKey)
+	server.AuthorizedKeys = keys
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	go server.Serve(listener)
+	t.Cleanup(func() { server.Close() })
+	return listener.Addr().String()
+}
+
+// syncBuffer collects the output of an SSH session while it is being written
+type syncBuffer struct {
+	lock sync.Mutex
+	buf  bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.buf.String()
+}
+
+// waitForSSHOutput waits until the collected output contains want
+func waitForSSHOutput(t *testing.T, output *syncBuffer, want string) {
+	t.Helper()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for !strings.Contains(output.String(), want) {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %q, got %q", want, output.String())
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestSSHShell(t *testing.T) {
+	t.Parallel()
+
+	manager := terminal.NewSessionManager()
+	defer manager.Close()
+	opts := testOptions()
+	terminal.SetAuthToken(opts, "secret")
+	addr := startSSHServer(t, manager, opts, nil)
+
+	config := &ssh.ClientConfig{
+		User:            "anyone",
+		Auth:            []ssh.AuthMethod{ssh.Password("wrong")},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		Timeout:         10 * time.Second,
+	}
+	if _, err := ssh.Dial("tcp", addr, config); err == nil {
+		t.Fatal("SSH login with a wrong token succeeded")
+	}
+
+	config.Auth = []ssh.AuthMethod{ssh.Password("secret")}
+	client, err := ssh.Dial("tcp", addr, config)
+	if err != nil {
+		t.Fatalf("Failed to log in with the token: %v", err)
+	}
+	defer client.Close()
+
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatalf("Failed to open session: %v", err)
+	}
+	var output syncBuffer
+	session.Stdout = &output
+	session.Stdin = strings.NewReader("stty size\nexit 7\n")
+	if err := session.RequestPty("xterm", 30, 100, ssh.TerminalModes{}); err != nil {
+		t.Fatalf("Failed to request a PTY: %v", err)
+	}
+	if err := session.Shell(); err != nil {
+		t.Fatalf("Failed to start shell: %v", err)
+	}
+
+	var exitErr *ssh.ExitError
+	if err := session.Wait(); !errors.As(err, &exitErr) || exitErr.ExitStatus() != 7 {
+		t.Fatalf("Expected exit status 7, got %v", err)
+	}
+	if !strings.Contains(output.String(), "30 100") {
+		t.Fatalf("Terminal size was not applied, output: %q", output.String())
+	}
+	if len(manager.List()) != 0 {
+		t.Fatal("Session still running after its shell exited")
+	}
+}
+
+func TestSSHAttachToBrowserSession(t *testing.T) {
+	t.Parallel()
+
+	manager := terminal.NewSessionManager()
+	defer manager.Close()
+	opts := testOptions()
+	terminal.SetAuthToken(opts, "secret")
+
+	// Authorize one admin key and one user key
+	dir := t.TempDir()
+	var lines []string
+	signers := map[string]ssh.Signer{}
+	for _, entry := range []struct{ name, options string }{{"alice", `role="admin" `}, {"bob", ""}} {
+		_, private, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatalf("Failed to generate key: %v", err)
+		}
+		signer, err := ssh.NewSignerFromKey(private)
+		if err != nil {
+			t.Fatalf("Failed to create signer: %v", err)
+		}
+		signers[entry.name] = signer
+		key := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(signer.PublicKey())))
+		lines = append(lines, entry.options+key+" "+entry.name)
+	}
+	keysFile := filepath.Join(dir, "authorized_keys")
+	if err := os.WriteFile(keysFile, []byte("# Test keys\n"+strings.Join(lines, "\n")+"\n"), 0600); err != nil {
+		t.Fatalf("Failed to write authorized keys: %v", err)
+	}
+	keys, err := terminal.LoadAuthorizedKeys(keysFile)
+	if err != nil {
+		t.Fatalf("Failed to load authorized keys: %v", err)
+	}
+	addr := startSSHServer(t, manager, opts, keys)
+
+	// Start a session from a browser
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		terminal.HandleWebSocketWithOptions(w, r, manager, opts)
+	}))
+	defer server.Close()
+	browser, resp := dialTerminal(t, server, terminal.Message{Type: "auth", Token: "secret"})
+	defer browser.Close()
+	if !resp.Success {
+		t.Fatalf("Browser authentication failed: %+v", resp)
+	}
+
+	dial := func(name, command string) (*ssh.Client, *ssh.Session, io.Writer, *syncBuffer) {
+		client, err := ssh.Dial("tcp", addr, &ssh.ClientConfig{
+			User:            name,
+			Auth:            []ssh.AuthMethod{ssh.PublicKeys(signers[name])},
+			HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+			Timeout:         10 * time.Second,
+		})
+		if err != nil {
+			t.Fatalf("Failed to log in as %s: %v", name, err)
+		}
+		session, err := client.NewSession()
+		if err != nil {
+			t.Fatalf("Failed to open session: %v", err)
+		}
+		output := &syncBuffer{}
+		session.Stdout = output
+		session.Stderr = output
+		stdin, err := session.StdinPipe()
+		if err != nil {
+			t.Fatalf("Failed to get stdin: %v", err)
+		}
+		if err := session.RequestPty("xterm", 24, 80, ssh.TerminalModes{}); err != nil {
+			t.Fatalf("Failed to request a PTY: %v", err)
+		}
+		if err := session.Start(command); err != nil {
+			t.Fatalf("Failed to run %q: %v", command, err)
+		}
+		return client, session, stdin, output
+	}
+
+	// Users may not attach to sessions of other principals
+	bobClient, bobSession, _, bobOutput := dial("bob", "attach "+resp.SessionID)
+	defer bobClient.Close()
+	bobSession.Wait()
+	if !strings.Contains(bobOutput.String(), "permission denied") {
+		t.Fatalf("User attached to another principal's session, output: %q", bobOutput.String())
+	}
+
+	// Admins share the shell with the browser
+	aliceClient, aliceSession, stdin, aliceOutput := dial("alice", "attach "+resp.SessionID)
+	defer aliceClient.Close()
+	stdin.Write([]byte("echo shared-$((6*7))\n"))
+	readUntil(t, browser, func(message []byte) bool {
+		return strings.Contains(string(message), "shared-42")
+	})
+	waitForSSHOutput(t, aliceOutput, "shared-42")
+
+	browser.WriteMessage(websocket.TextMessage, []byte("echo browser-$((6*7))\n"))
+	waitForSSHOutput(t, aliceOutput, "browser-42")
+
+	// Window changes resize the shared terminal
+	if err := aliceSession.WindowChange(40, 120); err != nil {
+		t.Fatalf("Failed to change window size: %v", err)
+	}
+	session, _ := manager.Get(resp.SessionID)
+	deadline := time.Now().Add(5 * time.Second)
+	for info := session.Info(); info.Rows != 40 || info.Cols != 120; info = session.Info() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Terminal was not resized, size is %dx%d", info.Rows, info.Cols)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	if got := len(session.Participants()); got != 2 {
+		t.Fatalf("Expected the browser and the SSH client as participants, got %d", got)
+	}
+}
@@ -0,0 +1,371 @@
+package terminal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// ErrUnknownTarget is returned when a client requests a target that is not configured
+var ErrUnknownTarget = errors.New("unknown target")
+
+// sshTargetDialTimeout limits how long connecting to a target may take
+const sshTargetDialTimeout = 15 * time.Second
+
+// SSHTarget is a remote host that sessions can be opened on over SSH instead of running
+// a local shell, turning the server into a web bastion. Like profiles, targets are only
+// referred to by name by clients, so TerminalOptions.Targets is an allowlist.
+type SSHTarget struct {
+	// Name identifies the target in client requests
+	Name string
+
+	// Description is shown to users choosing a target
+	Description string
+
+	// Address is the host and port of the SSH server, e.g. "db1.internal:22"
+	Address string
+
+	// User is the remote user to log in as
+	User string
+
+	// IdentityFile is the private key to authenticate with
+	IdentityFile string
+
+	// KnownHostsFile is the known_hosts file the host key of the target is verified against
+	KnownHostsFile string
+
+	// Command runs instead of the remote user's login shell (default: empty, the login shell)
+	Command string
+
+	// SessionTimeout overrides TerminalOptions.SessionTimeout for sessions on this target:
+	// how long they are kept alive once no client is connected
+	SessionTimeout time.Duration
+}
+
+// targetEntry is a single target in a targets file
+type targetEntry struct {
+	Name           string `json:"name"`
+	Description    string `json:"description,omitempty"`
+	Address        string `json:"address"`
+	User           string `json:"user"`
+	IdentityFile   string `json:"identity_file"`
+	KnownHostsFile string `json:"known_hosts_file"`
+	Command        string `json:"command,omitempty"`
+	IdleTimeout    string `json:"idle_timeout,omitempty"` // Go duration, e.g. "30m"
+}
+
+// targetSummary is what clients are told about a target
+type targetSummary struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+}
+
+// LoadTargets loads SSH targets from a JSON file holding an array of entries:
+//
+//	[{"name": "db1", "description": "Primary database", "address": "db1.internal:22",
+//	  "user": "ops", "identity_file": "/etc/go-remote-term/id_ed25519",
+//	  "known_hosts_file": "/etc/go-remote-term/known_hosts", "command": "", "idle_timeout": "30m"}]
+//
+// The port defaults to 22; description, command and idle_timeout are optional.
+func LoadTargets(path string) ([]SSHTarget, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read targets file: %v", err)
+	}
+
+	var entries []targetEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("failed to parse targets file: %v", err)
+	}
+
+	targets := make([]SSHTarget, 0, len(entries))
+	names := make(map[string]bool, len(entries))
+	for i, entry := range entries {
+		if entry.Name == "" {
+			return nil, fmt.Errorf("target %d in %s has no name", i+1, path)
+		}
+		if names[entry.Name] {
+			return nil, fmt.Errorf("duplicate target name %q in %s", entry.Name, path)
+		}
+		if entry.Address == "" || entry.User == "" {
+			return nil, fmt.Errorf("target %q needs an address and a user", entry.Name)
+		}
+		if entry.IdentityFile == "" || entry.KnownHostsFile == "" {
+			return nil, fmt.Errorf("target %q needs an identity file and a known hosts file", entry.Name)
+		}
+
+		address := entry.Address
+		if _, _, err := net.SplitHostPort(address); err != nil {
+			address = net.JoinHostPort(address, "22")
+		}
+		target := SSHTarget{
+			Name:           entry.Name,
+			Description:    entry.Description,
+			Address:        address,
+			User:           entry.User,
+			IdentityFile:   entry.IdentityFile,
+			KnownHostsFile: entry.KnownHostsFile,
+			Command:        entry.Command,
+		}
+		if entry.IdleTimeout != "" {
+			timeout, err := time.ParseDuration(entry.IdleTimeout)
+			if err != nil || timeout <= 0 {
+				return nil, fmt.Errorf("target %q has invalid idle timeout %q", entry.Name, entry.IdleTimeout)
+			}
+			target.SessionTimeout = timeout
+		}
+
+		names[entry.Name] = true
+		targets = append(targets, target)
+	}
+	return targets, nil
+}
+
+// lookupTarget returns the target with the given name from the allowlist in options
+// An empty name selects a local session and returns nil.
+func lookupTarget(options *TerminalOptions, name string) (*SSHTarget, error) {
+	if name == "" {
+		return nil, nil
+	}
+	for i := range options.Targets {
+		if options.Targets[i].Name == name {
+			return &options.Targets[i], nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %q", ErrUnknownTarget, name)
+}
+
+// TargetsHandler serves the names and descriptions of the given targets as JSON, so
+// that clients can offer them when starting a new session. Addresses and users are not disclosed.
+func TargetsHandler(targets []SSHTarget) http.Handler {
+	summaries := make([]targetSummary, 0, len(targets))
+	for _, target := range targets {
+		summaries = append(summaries, targetSummary{Name: target.Name, Description: target.Description})
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeAPIError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+		writeJSON(w, http.StatusOK, summaries)
+	})
+}
+
+// clientConfig builds the SSH client configuration of the target, with its key and known hosts
+func (t *SSHTarget) clientConfig() (*ssh.ClientConfig, error) {
+	key, err := os.ReadFile(t.IdentityFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read identity file of target %q: %v", t.Name, err)
+	}
+	signer, err := ssh.ParsePrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse identity file of target %q: %v", t.Name, err)
+	}
+	hostKeyCallback, err := knownhosts.New(t.KnownHostsFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load known hosts of target %q: %v", t.Name, err)
+	}
+
+	return &ssh.ClientConfig{
+		User:            t.User,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         sshTargetDialTimeout,
+	}, nil
+}
+
+// sessionOptions returns the options of sessions on the target, based on options
+func (t *SSHTarget) sessionOptions(options *TerminalOptions) *TerminalOptions {
+	opts := *options
+	opts.Shell = fmt.Sprintf("ssh://%s@%s", t.User, t.Address)
+	if t.SessionTimeout > 0 {
+		opts.SessionTimeout = t.SessionTimeout
+	}
+	return &opts
+}
+
+// sshBackend runs the shell of a session on an SSH target
+type sshBackend struct {
+	target      *SSHTarget
+	environment []string // Variables requested for the remote shell, which servers may refuse
+
+	client  *ssh.Client
+	session *ssh.Session
+	stdin   io.WriteCloser
+	stdout  io.Reader
+
+	exited chan struct{} // Closed once the remote shell's exit status is known
+	status ExitStatus
+	closed chan struct{} // Closed once the backend has been closed
+
+	closeOnce sync.Once
+}
+
+// newSSHBackend returns a backend running a shell on target
+func newSSHBackend(target *SSHTarget, environment []string) *sshBackend {
+	return &sshBackend{
+		target:      target,
+		environment: remoteEnvironment(environment),
+		exited:      make(chan struct{}),
+		status:      unknownExit,
+		closed:      make(chan struct{}),
+	}
+}
+
+// Start connects to the target and starts a shell on a remote terminal of the given size
+func (b *sshBackend) Start(rows, cols uint16) error {
+	t := b.target
+	config, err := t.clientConfig()
+	if err != nil {
+		return err
+	}
+	client, err := ssh.Dial("tcp", t.Address, config)
+	if err != nil {
+		return fmt.Errorf("failed to connect to target %q: %v", t.Name, err)
+	}
+
+	session, err := client.NewSession()
+	if err != nil {
+		client.Close()
+		return fmt.Errorf("failed to open session on target %q: %v", t.Name, err)
+	}
+	fail := func(format string, err error) error {
+		session.Close()
+		client.Close()
+		return fmt.Errorf(format, t.Name, err)
+	}
+
+	// Servers commonly refuse most variables, which is not an error
+	for _, variable := range b.environment {
+		if name, value, ok := strings.Cut(variable, "="); ok && name != "" {
+			session.Setenv(name, value)
+		}
+	}
+	if b.stdin, err = session.StdinPipe(); err != nil {
+		return fail("failed to open input of target %q: %v", err)
+	}
+	if b.stdout, err = session.StdoutPipe(); err != nil {
+		return fail("failed to open output of target %q: %v", err)
+	}
+	if err := session.RequestPty("xterm-256color", int(rows), int(cols), ssh.TerminalModes{ssh.ECHO: 1}); err != nil {
+		return fail("failed to allocate a terminal on target %q: %v", err)
+	}
+	if t.Command != "" {
+		err = session.Start(t.Command)
+	} else {
+		err = session.Shell()
+	}
+	if err != nil {
+		return fail("failed to start shell on target %q: %v", err)
+	}
+	b.client = client
+	b.session = session
+
+	// Collect the exit status of the remote shell
+	go func() {
+		err := session.Wait()
+		var exitErr *ssh.ExitError
+		switch {
+		case err == nil:
+			b.status.Code = 0
+		case errors.As(err, &exitErr):
+			if exitErr.Signal() != "" {
+				b.status.Signal = "SIG" + exitErr.Signal()
+			} else {
+				b.status.Code = exitErr.ExitStatus()
+			}
+		}
+		close(b.exited)
+	}()
+	return nil
+}
+
+// Read reads output of the remote terminal
+func (b *sshBackend) Read(p []byte) (int, error) {
+	return b.stdout.Read(p)
+}
+
+// Write sends input to the remote terminal
+func (b *sshBackend) Write(p []byte) (int, error) {
+	return b.stdin.Write(p)
+}
+
+// Resize changes the size of the remote terminal
+func (b *sshBackend) Resize(rows, cols uint16) error {
+	return b.session.WindowChange(int(rows), int(cols))
+}
+
+// Signal sends sig to the remote shell. Servers may ignore signals.
+func (b *sshBackend) Signal(sig syscall.Signal) error {
+	name := signalName(sig)
+	if !strings.HasPrefix(name, "SIG") {
+		return ErrSignalUnsupported
+	}
+	return b.session.Signal(ssh.Signal(strings.TrimPrefix(name, "SIG")))
+}
+
+// Break sends a break to the remote terminal, see RFC 4335
+func (b *sshBackend) Break() error {
+	payload := ssh.Marshal(struct{ Length uint32 }{uint32(breakLength / time.Millisecond)})
+	ok, err := b.session.SendRequest("break", true, payload)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrBreakUnsupported
+	}
+	return nil
+}
+
+// Close ends the remote shell, unless it has exited already, and disconnects from the target
+func (b *sshBackend) Close() error {
+	b.closeOnce.Do(func() {
+		if b.client == nil {
+			close(b.closed)
+			return
+		}
+		select {
+		case <-b.exited:
+		default:
+			// Ask the shell to hang up as a terminal would, then close the channel,
+			// which makes the server hang it up anyway
+			b.session.Signal(ssh.SIGHUP)
+			b.session.Close()
+			select {
+			case <-b.exited:
+			case <-time.After(processExitTimeout):
+			}
+		}
+		b.client.Close()
+		close(b.closed)
+	})
+	return nil
+}
+
+// Wait waits for the remote shell to exit and returns its exit status, which is
+// unknown if the connection was lost
+func (b *sshBackend) Wait() ExitStatus {
+	select {
+	case <-b.exited:
+		return b.status
+	case <-b.closed:
+		select {
+		case <-b.exited:
+			return b.status
+		default:
+			return unknownExit
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package terminal_test
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dansun78/go-remote-term/pkg/terminal"
+	"github.com/gorilla/websocket"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func TestSSHTargetSession(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+
+	// The bastion logs in to the remote host with its own key
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	block, err := ssh.MarshalPrivateKey(private, "")
+	if err != nil {
+		t.Fatalf("Failed to marshal key: %v", err)
+	}
+	identityFile := filepath.Join(dir, "id_ed25519")
+	if err := os.WriteFile(identityFile, pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatalf("Failed to write identity file: %v", err)
+	}
+	signer, err := ssh.NewSignerFromKey(private)
+	if err != nil {
+		t.Fatalf("Failed to create signer: %v", err)
+	}
+	keysFile := filepath.Join(dir, "authorized_keys")
+	authorized := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(signer.PublicKey()))) + " bastion\n"
+	if err := os.WriteFile(keysFile, []byte(authorized), 0600); err != nil {
+		t.Fatalf("Failed to write authorized keys: %v", err)
+	}
+	keys, err := terminal.LoadAuthorizedKeys(keysFile)
+	if err != nil {
+		t.Fatalf("Failed to load authorized keys: %v", err)
+	}
+
+	// An SSH server in this process stands in for the remote host
+	remoteManager := terminal.NewSessionManager()
+	defer remoteManager.Close()
+	hostKey, err := terminal.LoadHostKey(filepath.Join(dir, "host_key"))
+	if err != nil {
+		t.Fatalf("Failed to create host key: %v", err)
+	}
+	remote := terminal.NewSSHServer(remoteManager, testOptions(), hostKey)
+	remote.AuthorizedKeys = keys
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	go remote.Serve(listener)
+	defer remote.Close()
+	addr := listener.Addr().String()
+
+	// The remote host is known by its key; the impostor target claims a different one
+	writeKnownHosts := func(name string, key ssh.PublicKey) string {
+		path := filepath.Join(dir, name)
+		line := knownhosts.Line([]string{knownhosts.Normalize(addr)}, key)
+		if err := os.WriteFile(path, []byte(line+"\n"), 0600); err != nil {
+			t.Fatalf("Failed to write known hosts: %v", err)
+		}
+		return path
+	}
+	_, otherKey, _ := ed25519.GenerateKey(rand.Reader)
+	otherSigner, _ := ssh.NewSignerFromKey(otherKey)
+
+	manager := terminal.NewSessionManager()
+	defer manager.Close()
+	opts := testOptions()
+	terminal.SetAuthToken(opts, "secret")
+	opts.Targets = []terminal.SSHTarget{
+		{Name: "remote", Address: addr, User: "ops", IdentityFile: identityFile,
+			KnownHostsFile: writeKnownHosts("known_hosts", hostKey.PublicKey())},
+		{Name: "impostor", Address: addr, User: "ops", IdentityFile: identityFile,
+			KnownHostsFile: writeKnownHosts("known_hosts_other", otherSigner.PublicKey())},
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		terminal.HandleWebSocketWithOptions(w, r, manager, opts)
+	}))
+	defer server.Close()
+
+	// A host whose key does not match known hosts is refused
+	conn, resp := dialTerminal(t, server, terminal.Message{Type: "auth", Token: "secret", Target: "impostor"})
+	conn.Close()
+	if resp.Success || !strings.Contains(resp.Message, "key mismatch") {
+		t.Fatalf("Expected a host key mismatch, got %+v", resp)
+	}
+	conn, resp = dialTerminal(t, server, terminal.Message{Type: "auth", Token: "secret", Target: "missing"})
+	conn.Close()
+	if resp.Success {
+		t.Fatal("Session created on an unknown target")
+	}
+
+	conn, resp = dialTerminal(t, server, terminal.Message{Type: "auth", Token: "secret", Target: "remote"})
+	defer conn.Close()
+	if !resp.Success {
+		t.Fatalf("Failed to open session on target: %+v", resp)
+	}
+	session, _ := manager.Get(resp.SessionID)
+	if info := session.Info(); info.Target != "remote" || info.PID != 0 {
+		t.Fatalf("Unexpected session info %+v", info)
+	}
+
+	// Input and output travel through the remote shell
+	conn.WriteMessage(websocket.TextMessage, []byte("echo remote-$((6*7))\n"))
+	readUntil(t, conn, func(message []byte) bool {
+		return strings.Contains(string(message), "remote-42")
+	})
+	remoteSessions := remoteManager.List()
+	if len(remoteSessions) != 1 {
+		t.Fatalf("Expected one session on the remote host, got %d", len(remoteSessions))
+	}
+
+	// Resizing the session resizes the remote terminal
+	if err := session.Resize(40, 120); err != nil {
+		t.Fatalf("Failed to resize: %v", err)
+	}
+	remoteSession, _ := remoteManager.Get(remoteSessions[0].ID)
+	deadline := time.Now().Add(5 * time.Second)
+	for info := remoteSession.Info(); info.Rows != 40 || info.Cols != 120; info = remoteSession.Info() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Remote terminal was not resized, size is %dx%d", info.Rows, info.Cols)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	// The exit status of the remote shell ends the session
+	conn.WriteMessage(websocket.TextMessage, []byte("exit 3\n"))
+	readUntil(t, conn, func(message []byte) bool {
+		return strings.Contains(string(message), "session_ended")
+	})
+	end := session.End()
+	if end == nil || end.Reason != terminal.EndReasonExited || end.ExitCode != 3 {
+		t.Fatalf("Expected the session to end with exit code 3, got %+v", end)
+	}
+}
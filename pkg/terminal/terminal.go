@@ -3,12 +3,14 @@
 package terminal
 
 import (
+	"fmt"
 	"net/http"
 	"os"
 	"time"
 )
 
 // HandleWebSocket handles WebSocket connections for terminal sessions with default options
+// Sessions are kept in the package's default session manager
 func HandleWebSocket(w http.ResponseWriter, r *http.Request) {
 	opts := DefaultOptions()
 	// This assumes token is stored in context by middleware elsewhere
@@ -16,7 +18,31 @@ func HandleWebSocket(w http.ResponseWriter, r *http.Request) {
 	if token, ok := r.Context().Value("auth_token").(string); ok {
 		SetAuthToken(opts, token)
 	}
-	HandleWebSocketWithOptions(w, r, opts)
+	HandleWebSocketWithOptions(w, r, DefaultSessionManager(), opts)
+}
+
+// Init runs the helper process the program was re-executed as, if it was: the init
+// of a session's Sandbox, which executes the session's command, or the holder of a
+// persistent session's terminal (see TerminalOptions.PersistDir). Helpers never
+// return. Otherwise Init returns immediately. Programs using either feature must call
+// it first thing in main, and tests in TestMain.
+func Init() {
+	if len(os.Args) < 2 {
+		return
+	}
+
+	switch os.Args[0] {
+	case sandboxInitArg0:
+		err := runSandboxInit(os.Args[1:])
+		fmt.Fprintf(os.Stderr, "sandbox: %v\r\n", err)
+		os.Exit(126)
+	case ptyHolderArg0:
+		if err := runPtyHolder(os.Args[1:]); err != nil {
+			fmt.Fprintf(os.Stderr, "pty holder: %v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
 }
 
 // DefaultOptions returns the default terminal options
@@ -27,10 +53,11 @@ func DefaultOptions() *TerminalOptions {
 	}
 
 	return &TerminalOptions{
-		Shell:          shell,
-		InitialRows:    24,
-		InitialCols:    80,
-		SessionTimeout: 10 * time.Minute, // Keep sessions alive for 10 minutes by default
+		Shell:           shell,
+		InitialRows:     24,
+		InitialCols:     80,
+		SessionTimeout:  10 * time.Minute, // Keep sessions alive for 10 minutes by default
+		ScrollbackBytes: DefaultScrollbackBytes,
 		Environment: []string{
 			"TERM=xterm-256color",                // Use xterm-256color instead of dumb for better control sequence support
 			"PS1=\\w $ ",                         // Simple prompt without color codes
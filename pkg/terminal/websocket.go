@@ -2,12 +2,15 @@ package terminal
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"sync"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 )
 
@@ -19,7 +22,9 @@ var AllowedOrigins = []string{
 
 // Default WebSocket upgrader with improved CORS settings
 // Applications can use their own upgrader by setting terminal.Upgrader
+// Custom upgraders should list Subprotocol to let clients select protocol v2 with it
 var Upgrader = websocket.Upgrader{
+	Subprotocols: []string{Subprotocol},
 	CheckOrigin: func(r *http.Request) bool {
 		origin := r.Header.Get("Origin")
 
@@ -46,7 +51,8 @@ func SetAllowedOrigins(origins []string) {
 }
 
 // HandleWebSocketWithOptions handles WebSocket connections for terminal sessions with custom options
-func HandleWebSocketWithOptions(w http.ResponseWriter, r *http.Request, options *TerminalOptions) {
+// Sessions are created in and looked up from the given manager
+func HandleWebSocketWithOptions(w http.ResponseWriter, r *http.Request, manager *SessionManager, options *TerminalOptions) {
 	conn, err := Upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Println("Failed to upgrade connection:", err)
@@ -54,29 +60,67 @@ func HandleWebSocketWithOptions(w http.ResponseWriter, r *http.Request, options
 	}
 	defer conn.Close()
 
+	// The protocol is v2 if the client requested its subprotocol, and may still
+	// be upgraded by the auth message
+	client := &clientConn{conn: conn, protocol: ProtocolLegacy}
+	if conn.Subprotocol() == Subprotocol {
+		client.protocol = ProtocolV2
+	}
+
 	// Validate authentication
-	authenticated, errMsg, authMsg := validateClientAuth(conn, options)
+	authenticated, errMsg, authMsg, auth := validateClientAuth(client, options, manager)
 	if !authenticated {
-		sendErrorResponse(conn, errMsg)
+		sendErrorResponse(client, errMsg)
 		return
 	}
 
 	// At this point user is authenticated
 	msg := authMsg // From validateClientAuth
+	principal := auth.Principal
+
+	// Connections are logged and shown to other participants under the principal's name
+	name := remoteHost(r)
+	if principal != nil {
+		name = principal.Name
+	}
 
 	var session *TerminalSession
 	var isNewSession bool
-
-	// Check if client is requesting reconnection to existing session
-	if msg.SessionID != "" {
-		sessionsLock.Lock()
-		existingSession, exists := sessions[msg.SessionID]
-		sessionsLock.Unlock()
-
+	role := RoleOwner
+
+	if auth.Share != nil {
+		// Share links only ever attach to the shared session
+		existingSession, exists := manager.Get(auth.Share.SessionID)
+		if !exists {
+			if end, ended := manager.Ended(auth.Share.SessionID); ended {
+				client.writeControl(sessionEndedResponse(auth.Share.SessionID, *end))
+				return
+			}
+			sendErrorResponse(client, "Shared session has ended")
+			return
+		}
+		session = existingSession
+		role = auth.Share.Role
+		log.Printf("%s joining shared session %s as %s", name, session.ID, role)
+	} else if msg.SessionID != "" {
+		// Check if client is requesting reconnection to existing session
+		existingSession, exists := manager.Get(msg.SessionID)
 		if exists {
+			sessionRole, allowed := principal.sessionRole(existingSession)
+			if !allowed {
+				log.Printf("Denied %s access to session %s", name, existingSession.ID)
+				sendErrorResponse(client, "Permission denied: session belongs to another user")
+				return
+			}
 			session = existingSession
+			role = sessionRole
 			isNewSession = false
-			log.Printf("Reconnecting to existing session: %s", session.ID)
+			log.Printf("%s reconnecting to existing session %s as %s", name, session.ID, role)
+		} else if end, ended := manager.Ended(msg.SessionID); ended {
+			// Tell clients that reconnect shortly after the session ended how it ended
+			log.Printf("%s reconnecting to ended session %s", name, msg.SessionID)
+			client.writeControl(sessionEndedResponse(msg.SessionID, *end))
+			return
 		} else {
 			log.Printf("Requested session %s not found, creating new session", msg.SessionID)
 			isNewSession = true
@@ -87,45 +131,192 @@ func HandleWebSocketWithOptions(w http.ResponseWriter, r *http.Request, options
 
 	// Create new session if needed
 	if isNewSession {
-		newSession, err := createNewSession(options)
+		// Opening a serial port joins its session if it has one, which viewers may watch
+		if !principal.canCreateSessions() && msg.SerialPort == "" {
+			log.Printf("Denied %s creating a session", name)
+			sendErrorResponse(client, "Permission denied: viewers can only watch existing sessions")
+			return
+		}
+		requested := 0
+		for _, choice := range []string{msg.Profile, msg.Target, msg.Container, msg.SerialPort} {
+			if choice != "" {
+				requested++
+			}
+		}
+		if requested > 1 {
+			sendErrorResponse(client, "Failed to create terminal: a session runs either a profile, on a target, in a container or on a serial port")
+			return
+		}
+		var newSession *TerminalSession
+		var err error
+		created := true
+		if msg.SerialPort != "" {
+			newSession, created, err = manager.OpenSerialPort(options, principal, msg.SerialPort)
+			if errors.Is(err, ErrCreateDenied) {
+				log.Printf("Denied %s opening serial port %s", name, msg.SerialPort)
+				sendErrorResponse(client, "Permission denied: viewers can only watch existing sessions")
+				return
+			}
+		} else if msg.Container != "" {
+			newSession, err = manager.NewInContainer(options, principal, msg.Container)
+		} else if msg.Target != "" {
+			newSession, err = manager.NewOnTarget(options, principal, msg.Target)
+		} else {
+			newSession, err = manager.NewWithProfile(options, principal, msg.Profile)
+		}
 		if err != nil {
-			sendErrorResponse(conn, fmt.Sprintf("Failed to create terminal: %v", err))
+			sendErrorResponse(client, fmt.Sprintf("Failed to create terminal: %v", err))
 			return
 		}
 		session = newSession
-		log.Printf("Created new terminal session: %s", session.ID)
+		if !created {
+			// A serial port has a single session, which later clients attach to
+			sessionRole, allowed := principal.sessionRole(session)
+			if !allowed {
+				log.Printf("Denied %s access to serial port %s", name, session.SerialPort)
+				sendErrorResponse(client, "Permission denied: serial port is in use by another user")
+				return
+			}
+			role = sessionRole
+			log.Printf("%s attaching to session %s on serial port %s as %s", name, session.ID, session.SerialPort, role)
+		} else if session.Profile != "" {
+			log.Printf("Created new terminal session %s running profile %s for %s", session.ID, session.Profile, name)
+		} else if session.Target != "" {
+			log.Printf("Created new terminal session %s on target %s for %s", session.ID, session.Target, name)
+		} else if session.Container != "" {
+			log.Printf("Created new terminal session %s in container %s for %s", session.ID, session.Container, name)
+		} else if session.SerialPort != "" {
+			log.Printf("Created new terminal session %s on serial port %s for %s", session.ID, session.SerialPort, name)
+		} else {
+			log.Printf("Created new terminal session %s for %s", session.ID, name)
+		}
+	}
+
+	participant := Participant{
+		ID:          uuid.New().String(),
+		Name:        name,
+		Role:        role,
+		ConnectedAt: time.Now(),
+	}
+
+	// Count the connection and subscribe it to live output, unless the session is
+	// attached exclusively by another connection
+	bufferContents, sub, err := session.join(participant)
+	if err != nil {
+		log.Printf("Denied %s access to session %s: %v", name, session.ID, err)
+		sendErrorResponse(client, fmt.Sprintf("Failed to attach to session: %v", err))
+		return
 	}
 
 	// Send successful authentication response with session ID
-	if err := sendAuthSuccess(conn, session.ID); err != nil {
+	if err := sendAuthSuccess(client, session.ID, role); err != nil {
 		log.Println("Failed to send auth response:", err)
+		session.leave(participant, sub)
 		return
 	}
 
-	// Increment connection count
-	session.Lock.Lock()
-	session.Connections++
-
-	// If reconnecting, send buffer contents
-	if !isNewSession && session.OutputBuffer.Len() > 0 {
-		bufferContents := session.OutputBuffer.Bytes()
-		session.Lock.Unlock()
-
-		// Send current buffer contents to client for session continuity
-		err := conn.WriteMessage(websocket.TextMessage, bufferContents)
-		if err != nil {
+	// Send current buffer contents to client for session continuity
+	// For new sessions this is whatever the shell printed before the client attached
+	if len(bufferContents) > 0 {
+		if err := client.writeOutput(bufferContents); err != nil {
 			log.Printf("Error sending buffer to client: %v", err)
 		}
-	} else {
-		session.Lock.Unlock()
 	}
 
 	// Handle WebSocket connection for this session
-	handleTerminalConnection(conn, session, isNewSession)
+	handleTerminalConnection(client, session, sub, participant)
+}
+
+// remoteHost returns the address of the client that made the request, without the port
+func remoteHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// clientConn wraps a WebSocket connection attached to a session
+// WebSocket connections support only one concurrent writer, so all writes go through it
+type clientConn struct {
+	conn      *websocket.Conn
+	protocol  string // ProtocolLegacy or ProtocolV2
+	writeLock sync.Mutex
+}
+
+// writeOutput sends terminal output to the client
+func (c *clientConn) writeOutput(data []byte) error {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+
+	if c.protocol == ProtocolV2 {
+		return c.conn.WriteMessage(websocket.BinaryMessage, EncodeFrame(FrameData, data))
+	}
+	return c.conn.WriteMessage(websocket.TextMessage, data)
+}
+
+// writeControl sends a control message to the client
+func (c *clientConn) writeControl(resp Response) error {
+	respBytes, _ := json.Marshal(resp)
+
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+
+	if c.protocol == ProtocolV2 {
+		return c.conn.WriteMessage(websocket.BinaryMessage, EncodeFrame(FrameControl, respBytes))
+	}
+	return c.conn.WriteMessage(websocket.TextMessage, respBytes)
+}
+
+// writeEvent sends a session event to the client, merging any output queued behind it
+func (c *clientConn) writeEvent(sub *outputSubscriber, event sessionEvent) error {
+	if event.Control != nil {
+		return c.writeControl(*event.Control)
+	}
+
+	// Coalesce any chunks that queued up while we were writing
+	data, next := drainPending(sub, event.Output)
+	if err := c.writeOutput(data); err != nil {
+		return err
+	}
+	if next != nil {
+		return c.writeEvent(sub, *next)
+	}
+	return nil
+}
+
+// flush sends every event already queued for the subscriber without blocking
+func (c *clientConn) flush(sub *outputSubscriber) {
+	for {
+		select {
+		case event, ok := <-sub.C:
+			if !ok {
+				return
+			}
+			if err := c.writeEvent(sub, event); err != nil {
+				return
+			}
+		default:
+			return
+		}
+	}
+}
+
+// hangUp sends a close frame to the client and closes the connection
+func (c *clientConn) hangUp(code int, reason string) {
+	c.writeLock.Lock()
+	c.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason),
+		time.Now().Add(time.Second))
+	c.writeLock.Unlock()
+
+	c.conn.Close()
 }
 
 // handleTerminalConnection manages a WebSocket connection for an existing terminal session
-func handleTerminalConnection(conn *websocket.Conn, session *TerminalSession, isNewSession bool) {
+// Input and control messages are only honored if the participant's role allows them
+func handleTerminalConnection(client *clientConn, session *TerminalSession, sub *outputSubscriber, participant Participant) {
+	conn := client.conn
+
 	// Wait group for connection handling goroutines
 	var wg sync.WaitGroup
 	wg.Add(2)
@@ -133,48 +324,32 @@ func handleTerminalConnection(conn *websocket.Conn, session *TerminalSession, is
 	// Channel to signal when this connection is closed
 	connClosed := make(chan struct{})
 
-	// Forward terminal output to the WebSocket
+	// Forward terminal output to the WebSocket as soon as it is published
 	go func() {
 		defer wg.Done()
 
-		// Initialize lastSize based on session type
-		// For new sessions, we want to show all output from the beginning (lastSize = 0)
-		// For reconnections, we've already sent the buffer, so we start from current size
-		session.Lock.Lock()
-		var lastSize int
-		if isNewSession {
-			lastSize = 0
-		} else {
-			lastSize = session.OutputBuffer.Len()
-		}
-		session.Lock.Unlock()
-
-		ticker := time.NewTicker(100 * time.Millisecond)
-		defer ticker.Stop()
-
 		for {
 			select {
 			case <-connClosed:
 				return
 			case <-session.Done:
+				// Deliver whatever was queued before the session ended, then hang up
+				client.flush(sub)
+				client.hangUp(websocket.CloseNormalClosure, "Session ended")
 				return
-			case <-ticker.C:
-				// Check if there's new output to send
-				session.Lock.Lock()
-				currentSize := session.OutputBuffer.Len()
-				if currentSize > lastSize {
-					// Only send the new part since last check
-					bufferContents := session.OutputBuffer.Bytes()[lastSize:currentSize]
-					session.Lock.Unlock()
-
-					if err := conn.WriteMessage(websocket.TextMessage, bufferContents); err != nil {
-						log.Println("Error writing to WebSocket:", err)
-						return
-					}
+			case event, ok := <-sub.C:
+				if !ok {
+					// The subscriber fell too far behind or the session was handed over to
+					// its holder. Close the connection so the client reconnects and replays the buffer.
+					log.Printf("Closing dropped connection for session %s", session.ID)
+					conn.Close()
+					return
+				}
 
-					lastSize = currentSize
-				} else {
-					session.Lock.Unlock()
+				if err := client.writeEvent(sub, event); err != nil {
+					log.Println("Error writing to WebSocket:", err)
+					conn.Close()
+					return
 				}
 			}
 		}
@@ -186,19 +361,62 @@ func handleTerminalConnection(conn *websocket.Conn, session *TerminalSession, is
 		defer close(connClosed)
 
 		for {
-			_, message, err := conn.ReadMessage()
+			jsonMsg, message, err := client.readMessage()
+			if errors.Is(err, errInvalidFrame) {
+				log.Printf("Ignoring message from %s: %v", participant.Name, err)
+				continue
+			}
 			if err != nil {
 				log.Printf("WebSocket connection closed: %v", err)
 				break
 			}
 
-			// Check if message is JSON (might be a control message)
-			var jsonMsg Message
-			if err := json.Unmarshal(message, &jsonMsg); err == nil {
+			if jsonMsg != nil {
 				// Handle control messages
 				if jsonMsg.Type == "resize" && jsonMsg.Rows > 0 && jsonMsg.Cols > 0 {
-					// Resize the terminal
-					ResizeTerminal(session.PTY, jsonMsg.Rows, jsonMsg.Cols)
+					// Resize the terminal; read-only viewers follow the writers' size
+					if participant.Role.canWrite() {
+						session.Resize(jsonMsg.Rows, jsonMsg.Cols)
+					}
+					continue
+				}
+
+				// Only the owner may terminate or share the session
+				if (jsonMsg.Type == "terminate" || jsonMsg.Type == "share") && participant.Role != RoleOwner {
+					client.writeControl(Response{
+						Type:      jsonMsg.Type + "_response",
+						Success:   false,
+						Message:   "Permission denied",
+						SessionID: session.ID,
+					})
+					continue
+				}
+
+				// Handle share link request, the requested role is passed in data
+				if jsonMsg.Type == "share" {
+					resp := Response{Type: "share_response", SessionID: session.ID}
+					share, err := session.manager.CreateShare(session.ID, ParticipantRole(jsonMsg.Data), 0)
+					if err != nil {
+						resp.Message = err.Error()
+					} else {
+						resp.Success = true
+						resp.Share = share
+					}
+					client.writeControl(resp)
+					continue
+				}
+
+				// Handle break request, which only writers may send
+				if jsonMsg.Type == "break" {
+					resp := Response{Type: "break_response", SessionID: session.ID}
+					if !participant.Role.canWrite() {
+						resp.Message = "Permission denied"
+					} else if err := session.SendBreak(); err != nil {
+						resp.Message = err.Error()
+					} else {
+						resp.Success = true
+					}
+					client.writeControl(resp)
 					continue
 				}
 
@@ -211,29 +429,30 @@ func handleTerminalConnection(conn *websocket.Conn, session *TerminalSession, is
 						Message:   "Session terminated",
 						SessionID: session.ID,
 					}
-					respBytes, _ := json.Marshal(resp)
-					conn.WriteMessage(websocket.TextMessage, respBytes)
+					client.writeControl(resp)
+
+					log.Printf("Session %s terminated by %s", session.ID, participant.Name)
 
 					// Schedule termination (do it after response is sent)
 					go func() {
 						time.Sleep(100 * time.Millisecond) // Brief delay to allow response to be sent
-						terminateSession(session.ID)
+						session.manager.TerminateBy(session.ID, participant.Name)
 					}()
 					continue
 				}
 
 				// Handle other control messages
-				log.Println("Received JSON control message:", string(message))
+				log.Println("Received unknown control message:", jsonMsg.Type)
 				continue
 			}
 
-			// For normal input, write to PTY
-			session.Lock.Lock()
-			session.LastActive = time.Now()
-			_, err = session.PTY.Write(message)
-			session.Lock.Unlock()
+			// Input from read-only viewers is dropped
+			if !participant.Role.canWrite() {
+				continue
+			}
 
-			if err != nil {
+			// For normal input, write to PTY
+			if err := session.writeInput(message); err != nil {
 				log.Println("Error writing to PTY:", err)
 				break
 			}
@@ -243,14 +462,11 @@ func handleTerminalConnection(conn *websocket.Conn, session *TerminalSession, is
 	// Wait for connection handling to complete
 	wg.Wait()
 
-	// Decrement connection count when this connection ends
-	session.Lock.Lock()
-	session.Connections--
-	session.LastActive = time.Now()
-	session.Lock.Unlock()
+	// Decrement connection count when this connection ends and tell the others
+	remaining := session.leave(participant, sub)
 
-	log.Printf("WebSocket connection closed for session %s, remaining connections: %d",
-		session.ID, session.Connections)
+	log.Printf("WebSocket connection of %s closed for session %s, remaining connections: %d",
+		participant.Name, session.ID, remaining)
 
 	// Note: We don't automatically close the session here to allow reconnection
 }
@@ -0,0 +1,287 @@
+document.addEventListener('DOMContentLoaded', () => {
+    const terminalElement = document.getElementById('terminal');
+    const statusDisplay = document.getElementById('status');
+    const recordingSelect = document.getElementById('recordingSelect');
+    const playBtn = document.getElementById('playBtn');
+    const seekBar = document.getElementById('seekBar');
+    const timeDisplay = document.getElementById('timeDisplay');
+    const speedSelect = document.getElementById('speedSelect');
+
+    // Playback state
+    let header = null;      // Parsed asciicast header
+    let events = [];        // Parsed events as {time, code, data}
+    let duration = 0;       // Time of the last event in seconds
+    let position = 0;       // Current playback position in seconds
+    let nextEvent = 0;      // Index of the next event to apply
+    let playing = false;
+    let lastTick = 0;       // Timestamp of the previous animation frame
+    let animationFrame = null;
+    let speed = 1;
+
+    // Initialize xterm.js with the same theme as the live terminal
+    const term = new Terminal({
+        cursorBlink: false,
+        disableStdin: true,
+        theme: {
+            background: '#2b2b2b',
+            foreground: '#f0f0f0',
+            cursor: '#4CAF50',
+            cursorAccent: '#2b2b2b',
+            selection: 'rgba(76, 175, 80, 0.3)'
+        },
+        fontFamily: 'Menlo, Monaco, "Courier New", monospace',
+        fontSize: 14,
+        scrollback: 1000
+    });
+    term.open(terminalElement);
+
+    // Format seconds as m:ss
+    function formatTime(seconds) {
+        const total = Math.floor(seconds);
+        const minutes = Math.floor(total / 60);
+        const secs = total % 60;
+        return `${minutes}:${secs.toString().padStart(2, '0')}`;
+    }
+
+    // Update the seek bar and time display to match the current position
+    function updateProgress() {
+        seekBar.value = position;
+        timeDisplay.textContent = `${formatTime(position)} / ${formatTime(duration)}`;
+    }
+
+    // Update the play button icon
+    function updatePlayButton() {
+        playBtn.innerHTML = playing ? '<i class="fas fa-pause"></i>' : '<i class="fas fa-play"></i>';
+    }
+
+    // Apply every event up to the given time, batching output into as few writes as possible
+    function applyEventsUntil(time) {
+        let output = '';
+        while (nextEvent < events.length && events[nextEvent].time <= time) {
+            const event = events[nextEvent];
+            if (event.code === 'o') {
+                output += event.data;
+            } else if (event.code === 'r') {
+                // Flush output written at the old size before resizing
+                if (output) {
+                    term.write(output);
+                    output = '';
+                }
+                const [cols, rows] = event.data.split('x').map(Number);
+                if (cols > 0 && rows > 0) {
+                    term.resize(cols, rows);
+                }
+            }
+            // Input events ("i") are part of the recording but not displayed
+            nextEvent++;
+        }
+        if (output) {
+            term.write(output);
+        }
+    }
+
+    // Restore the terminal to its state at the start of the recording
+    function resetTerminal() {
+        term.reset();
+        if (header && header.width > 0 && header.height > 0) {
+            term.resize(header.width, header.height);
+        }
+        nextEvent = 0;
+        position = 0;
+    }
+
+    // Animation loop advancing the playback position
+    function tick(now) {
+        if (!playing) {
+            return;
+        }
+
+        position = Math.min(duration, position + ((now - lastTick) / 1000) * speed);
+        lastTick = now;
+        applyEventsUntil(position);
+        updateProgress();
+
+        if (position >= duration) {
+            pause();
+            statusDisplay.textContent = 'Playback finished';
+            statusDisplay.style.color = '#e0e0e0';
+            return;
+        }
+
+        animationFrame = requestAnimationFrame(tick);
+    }
+
+    function play() {
+        if (events.length === 0) {
+            return;
+        }
+        // Restart from the beginning when playback had finished
+        if (position >= duration) {
+            resetTerminal();
+        }
+        playing = true;
+        lastTick = performance.now();
+        animationFrame = requestAnimationFrame(tick);
+        updatePlayButton();
+        statusDisplay.textContent = 'Playing';
+        statusDisplay.style.color = 'green';
+    }
+
+    function pause() {
+        playing = false;
+        if (animationFrame) {
+            cancelAnimationFrame(animationFrame);
+            animationFrame = null;
+        }
+        updatePlayButton();
+        statusDisplay.textContent = 'Paused';
+        statusDisplay.style.color = 'orange';
+    }
+
+    // Jump to the given time, replaying from the start when seeking backwards
+    function seek(time) {
+        if (time < position) {
+            resetTerminal();
+        }
+        position = time;
+        applyEventsUntil(position);
+        updateProgress();
+    }
+
+    // Parse an asciicast v2 file into its header and events
+    function parseRecording(text) {
+        const lines = text.split('\n').filter(line => line.trim() !== '');
+        if (lines.length === 0) {
+            throw new Error('Recording is empty');
+        }
+
+        const parsedHeader = JSON.parse(lines[0]);
+        if (parsedHeader.version !== 2) {
+            throw new Error(`Unsupported asciicast version: ${parsedHeader.version}`);
+        }
+
+        const parsedEvents = [];
+        for (let i = 1; i < lines.length; i++) {
+            try {
+                const [time, code, data] = JSON.parse(lines[i]);
+                parsedEvents.push({ time, code, data });
+            } catch (e) {
+                // A recording of a session that is still running may end with a partial line
+                console.warn('Skipping malformed event on line', i + 1);
+            }
+        }
+
+        return { header: parsedHeader, events: parsedEvents };
+    }
+
+    // Download and load a recording
+    async function loadRecording(id) {
+        pause();
+        events = [];
+        duration = 0;
+        playBtn.disabled = true;
+        seekBar.disabled = true;
+        statusDisplay.textContent = 'Loading recording...';
+        statusDisplay.style.color = 'orange';
+
+        try {
+            const response = await fetch(`/recordings/${encodeURIComponent(id)}`, {
+                credentials: 'same-origin',
+                headers: { 'X-Requested-With': 'XMLHttpRequest' }
+            });
+            if (!response.ok) {
+                throw new Error(`Server returned ${response.status}`);
+            }
+
+            const recording = parseRecording(await response.text());
+            header = recording.header;
+            events = recording.events;
+            duration = events.length > 0 ? events[events.length - 1].time : 0;
+
+            resetTerminal();
+            seekBar.max = duration;
+            updateProgress();
+
+            playBtn.disabled = false;
+            seekBar.disabled = false;
+            statusDisplay.textContent = 'Ready';
+            statusDisplay.style.color = '#e0e0e0';
+        } catch (error) {
+            console.error('Failed to load recording:', error);
+            statusDisplay.textContent = 'Failed to load recording: ' + error.message;
+            statusDisplay.style.color = 'red';
+        }
+    }
+
+    // Fetch the list of recordings and fill the selector
+    async function loadRecordingList() {
+        try {
+            const response = await fetch('/recordings/', {
+                credentials: 'same-origin',
+                headers: { 'X-Requested-With': 'XMLHttpRequest' }
+            });
+            if (response.status === 401) {
+                window.location.href = '/login.html';
+                return;
+            }
+            if (!response.ok) {
+                throw new Error(`Server returned ${response.status}`);
+            }
+
+            const recordings = await response.json();
+            recordingSelect.innerHTML = '';
+
+            const placeholder = document.createElement('option');
+            placeholder.value = '';
+            placeholder.textContent = recordings.length > 0 ? 'Select a recording...' : 'No recordings available';
+            recordingSelect.appendChild(placeholder);
+
+            for (const recording of recordings) {
+                const option = document.createElement('option');
+                option.value = recording.id;
+                const started = new Date(recording.started_at).toLocaleString();
+                const sizeKB = Math.max(1, Math.round(recording.size / 1024));
+                option.textContent = `${started} - ${recording.id.substring(0, 8)}... (${sizeKB} KB)`;
+                recordingSelect.appendChild(option);
+            }
+        } catch (error) {
+            console.error('Failed to list recordings:', error);
+            recordingSelect.innerHTML = '<option value="">Recordings unavailable</option>';
+            statusDisplay.textContent = 'Failed to list recordings: ' + error.message;
+            statusDisplay.style.color = 'red';
+        }
+    }
+
+    recordingSelect.addEventListener('change', () => {
+        if (recordingSelect.value) {
+            loadRecording(recordingSelect.value);
+        }
+    });
+
+    playBtn.addEventListener('click', () => {
+        if (playing) {
+            pause();
+        } else {
+            play();
+        }
+    });
+
+    seekBar.addEventListener('input', () => {
+        seek(parseFloat(seekBar.value));
+    });
+
+    speedSelect.addEventListener('change', () => {
+        speed = parseFloat(speedSelect.value) || 1;
+    });
+
+    // Space toggles playback
+    document.addEventListener('keydown', (e) => {
+        if (e.code === 'Space' && e.target.tagName !== 'SELECT' && !playBtn.disabled) {
+            e.preventDefault();
+            playBtn.click();
+        }
+    });
+
+    updateProgress();
+    loadRecordingList();
+});
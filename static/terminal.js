@@ -4,8 +4,30 @@ document.addEventListener('DOMContentLoaded', () => {
     const sessionInfo = document.getElementById('sessionInfo');
     const connectionIndicator = document.getElementById('connectionIndicator');
     const newSessionBtn = document.getElementById('newSessionBtn');
+    const profileSelect = document.getElementById('profileSelect');
+    const targetSelect = document.getElementById('targetSelect');
+    const containerSelect = document.getElementById('containerSelect');
+    const serialSelect = document.getElementById('serialSelect');
     const terminateBtn = document.getElementById('terminateBtn');
+    const breakBtn = document.getElementById('breakBtn');
     const fullscreenBtn = document.getElementById('fullscreenBtn');
+    const participantsDisplay = document.getElementById('participants');
+    const shareRoleSelect = document.getElementById('shareRole');
+    const shareBtn = document.getElementById('shareBtn');
+    const shareLink = document.getElementById('shareLink');
+    const shareLinkInput = document.getElementById('shareLinkInput');
+    const copyShareBtn = document.getElementById('copyShareBtn');
+    
+    // Share link token when this page was opened from a share link (guest view)
+    const shareToken = new URLSearchParams(window.location.search).get('share');
+    let role = null; // Role granted by the server: owner, read-write or read-only
+    
+    // WebSocket protocol v2: every message is a binary frame prefixed with a one-byte opcode
+    const PROTOCOL_V2 = 'go-remote-term.v2';
+    const FRAME_DATA = 0x00;    // Terminal input or output
+    const FRAME_CONTROL = 0x01; // JSON control message
+    const textEncoder = new TextEncoder();
+    const textDecoder = new TextDecoder();
     
     let socket = null;
     let sessionId = null; // Store the session ID for reconnection
@@ -113,7 +135,12 @@ document.addEventListener('DOMContentLoaded', () => {
     }
     
     // Store session ID in localStorage
+    // Guests never touch it, so joining a shared session doesn't replace your own
     function saveSession(id) {
+        if (shareToken) {
+            updateSessionInfo(id);
+            return;
+        }
         if (id) {
             localStorage.setItem('terminal_session_id', id);
             // Update session info display
@@ -132,7 +159,9 @@ document.addEventListener('DOMContentLoaded', () => {
     
     // Clear saved session
     function clearSavedSession() {
-        localStorage.removeItem('terminal_session_id');
+        if (!shareToken) {
+            localStorage.removeItem('terminal_session_id');
+        }
         updateSessionInfo(null);
     }
     
@@ -148,6 +177,40 @@ document.addEventListener('DOMContentLoaded', () => {
         }
     }
     
+    // Whether the current socket negotiated protocol v2 (older servers only speak the legacy protocol)
+    function usesProtocolV2() {
+        return socket && socket.protocol === PROTOCOL_V2;
+    }
+    
+    // Build a protocol v2 frame from an opcode and its payload
+    function encodeFrame(opcode, payload) {
+        const frame = new Uint8Array(payload.length + 1);
+        frame[0] = opcode;
+        frame.set(payload, 1);
+        return frame;
+    }
+    
+    // Send a control message to the server
+    function sendControl(message) {
+        const json = JSON.stringify(message);
+        socket.send(usesProtocolV2() ? encodeFrame(FRAME_CONTROL, textEncoder.encode(json)) : json);
+    }
+    
+    // Send terminal input to the server
+    function sendInput(data) {
+        socket.send(usesProtocolV2() ? encodeFrame(FRAME_DATA, textEncoder.encode(data)) : data);
+    }
+    
+    // Show who is attached to the session
+    function updateParticipants(participants) {
+        if (!participants || participants.length === 0) {
+            participantsDisplay.textContent = '';
+            return;
+        }
+        const names = participants.map(p => `${p.name} (${p.role})`);
+        participantsDisplay.textContent = `Attached: ${names.join(', ')}`;
+    }
+    
     // Update connection indicator
     function updateConnectionIndicator(status) {
         // Remove all classes first
@@ -218,7 +281,7 @@ document.addEventListener('DOMContentLoaded', () => {
         function createConnection(sessionId) {
             // Get authentication token
             const token = getAuthToken();
-            if (!token) {
+            if (!token && !shareToken) {
                 statusDisplay.textContent = 'Authentication token missing';
                 statusDisplay.style.color = 'red';
                 updateConnectionIndicator('disconnected');
@@ -241,7 +304,8 @@ document.addEventListener('DOMContentLoaded', () => {
             }
             
             try {
-                socket = new WebSocket(wsUrl);
+                socket = new WebSocket(wsUrl, [PROTOCOL_V2]);
+                socket.binaryType = 'arraybuffer';
                 
                 // Reset any socket flags used for state tracking
                 socket._forceClosing = false;
@@ -250,16 +314,28 @@ document.addEventListener('DOMContentLoaded', () => {
                 socket.onopen = () => {
                     // Send authentication token as first message after connection
                     // Include session ID if we're reconnecting to an existing session
-                    const authMessage = {
-                        type: 'auth',
-                        token: token
-                    };
+                    // Guests authenticate with the share link instead of the server token
+                    const authMessage = shareToken ?
+                        { type: 'auth', share: shareToken } :
+                        { type: 'auth', token: token };
                     
                     if (sessionId) {  // Use the passed sessionId parameter
                         authMessage.session_id = sessionId;
+                    } else if (serialSelect.value) {
+                        // New sessions attach to the chosen serial port, or join its session
+                        authMessage.serial_port = serialSelect.value;
+                    } else if (containerSelect.value) {
+                        // New sessions open a shell in the chosen container
+                        authMessage.container = containerSelect.value;
+                    } else if (targetSelect.value) {
+                        // New sessions open a shell on the chosen SSH host
+                        authMessage.target = targetSelect.value;
+                    } else if (profileSelect.value) {
+                        // New sessions run the chosen profile instead of the shell
+                        authMessage.profile = profileSelect.value;
                     }
                     
-                    socket.send(JSON.stringify(authMessage));
+                    sendControl(authMessage);
                 };
                 
                 socket.onclose = (event) => {
@@ -268,6 +344,9 @@ document.addEventListener('DOMContentLoaded', () => {
                     
                     // Update button states
                     terminateBtn.disabled = true;
+                    shareBtn.disabled = true;
+                    breakBtn.disabled = true;
+                    updateParticipants(null);
                     
                     // Clean up event handlers on socket close to prevent duplicates on reconnection
                     cleanupTerminalState();
@@ -304,70 +383,8 @@ document.addEventListener('DOMContentLoaded', () => {
                     updateConnectionIndicator('disconnected');
                 };
                 
-                socket.onmessage = (event) => {
-                try {
-                    // First check if this is a JSON message from our PTY output buffer hack
-                    // which will be wrapped in <JSON>...</JSON> tags
-                    const jsonMatch = event.data.match(/<JSON>(.*?)<\/JSON>/s);
-                    if (jsonMatch && jsonMatch[1]) {
-                        // Extract and parse the JSON
-                        const jsonData = JSON.parse(jsonMatch[1]);
-                        
-                        // Handle the message based on its type
-                        if (jsonData.type === 'session_ended') {
-                            console.log("Shell process exited for session:", jsonData.sessionID);
-                            
-                            // Show message to user
-                            term.write('\r\n\x1b[31mShell process has exited. Session terminated.\x1b[0m\r\n');
-                            statusDisplay.textContent = 'Shell exited';
-                            statusDisplay.style.color = 'orange';
-                            
-                            // Clean up event handlers when shell process exits
-                            if (inputHandler) {
-                                inputHandler.dispose();
-                                inputHandler = null;
-                            }
-                            if (resizeHandler) {
-                                resizeHandler.dispose();
-                                resizeHandler = null;
-                            }
-                            
-                            // Clear saved session
-                            clearSavedSession();
-                            sessionId = null;
-                            
-                            // Update UI state
-                            newSessionBtn.disabled = false;
-                            terminateBtn.disabled = true;
-                            updateConnectionIndicator('disconnected');
-                            
-                            // Close socket connection
-                            setTimeout(() => {
-                                if (socket && socket.readyState === WebSocket.OPEN) {
-                                    socket.close(1000, 'Shell process exited');
-                                }
-                            }, 100);
-                            
-                            // Remove the JSON wrapper from the terminal output
-                            const cleanedData = event.data.replace(/<JSON>.*?<\/JSON>/s, '');
-                            if (cleanedData.trim()) {
-                                term.write(cleanedData);
-                            }
-                            return;
-                        }
-                        
-                        // If we reach here, we didn't handle the wrapped JSON specifically
-                        // Remove the JSON wrapper and continue processing as normal text
-                        const cleanedData = event.data.replace(/<JSON>.*?<\/JSON>/s, '');
-                        if (cleanedData.trim()) {
-                            term.write(cleanedData);
-                        }
-                        return;
-                    }
-                    
-                    // Next, check if the entire message is JSON
-                    const data = JSON.parse(event.data);
-                    
+                // Handle a control message from the server, returns false for unknown messages
+                function handleControlMessage(data) {
                     if (data.type === 'auth_response') {
                         if (!data.success) {
                             statusDisplay.textContent = 'Authentication failed: ' + data.message;
@@ -377,26 +394,36 @@ document.addEventListener('DOMContentLoaded', () => {
                             sessionId = null;
                             socket.close();
                             updateConnectionIndicator('disconnected');
-                            return;
+                            return true;
                         }
                         
                         // Authentication succeeded
                         // Store the session ID for reconnection
                         sessionId = data.session_id;
+                        role = data.role || 'owner';
                         saveSession(sessionId);
                         
                         // Reset reconnect attempts on successful connection
                         reconnectAttempts = 0;
                         clearTimeout(reconnectTimer);
                         
-                        statusDisplay.textContent = sessionId ? 'Reconnected' : 'Connected';
+                        if (role === 'owner') {
+                            statusDisplay.textContent = sessionId ? 'Reconnected' : 'Connected';
+                        } else {
+                            statusDisplay.textContent = `Joined shared session (${role})`;
+                        }
                         statusDisplay.style.color = 'green';
                         updateConnectionIndicator('connected');
                         
+                        // Read-only viewers can watch but not type
+                        term.options.disableStdin = role === 'read-only';
+                        
                         newSessionBtn.disabled = false;
-                        terminateBtn.disabled = false;
+                        terminateBtn.disabled = role !== 'owner';
+                        shareBtn.disabled = role !== 'owner';
+                        breakBtn.disabled = role === 'read-only';
                         term.focus();
-                        return; // Don't process auth responses as terminal output
+                        return true; // Don't process auth responses as terminal output
                     } 
                     // Add handling for terminate_response
                     else if (data.type === 'terminate_response') {
@@ -429,15 +456,20 @@ document.addEventListener('DOMContentLoaded', () => {
                                 }
                             }, 200);
                         }
-                        return; // Don't process as terminal output
+                        return true; // Don't process as terminal output
                     }
-                    // Add handling for session_ended (when shell exits naturally)
+                    // Add handling for session_ended (the shell exited or the session was ended)
                     else if (data.type === 'session_ended') {
-                        console.log("Shell process exited for session:", data.sessionID);
+                        console.log("Session ended:", data.session_id, data.end);
+                        
+                        // Terminate and shutdown responses already cleared the session and told the user
+                        if (!sessionId) {
+                            return true;
+                        }
                         
                         // Show message to user
-                        term.write('\r\n\x1b[31mShell process has exited. Session terminated.\x1b[0m\r\n');
-                        statusDisplay.textContent = 'Shell exited';
+                        term.write(`\r\n\x1b[31m${data.message}\x1b[0m\r\n`);
+                        statusDisplay.textContent = data.end && data.end.reason === 'exited' ? 'Shell exited' : 'Session ended';
                         statusDisplay.style.color = 'orange';
                         
                         // Clean up event handlers when shell process exits
@@ -457,6 +489,7 @@ document.addEventListener('DOMContentLoaded', () => {
                         // Update UI state
                         newSessionBtn.disabled = false;
                         terminateBtn.disabled = true;
+                        breakBtn.disabled = true;
                         updateConnectionIndicator('disconnected');
                         
                         // Close socket connection
@@ -466,7 +499,85 @@ document.addEventListener('DOMContentLoaded', () => {
                             }
                         }, 100);
                         
-                        return; // Don't process as terminal output
+                        return true; // Don't process as terminal output
+                    }
+                    // Add handling for presence (someone attached to or detached from the session)
+                    else if (data.type === 'presence') {
+                        updateParticipants(data.participants);
+                        return true; // Don't process as terminal output
+                    }
+                    // Add handling for share_response (a share link was created)
+                    else if (data.type === 'share_response') {
+                        shareBtn.disabled = false;
+                        if (!data.success) {
+                            statusDisplay.textContent = 'Failed to share session: ' + data.message;
+                            statusDisplay.style.color = 'red';
+                            return true;
+                        }
+                        const url = `${window.location.origin}/?share=${encodeURIComponent(data.share.token)}`;
+                        shareLinkInput.value = url;
+                        shareLink.hidden = false;
+                        shareLinkInput.select();
+                        return true; // Don't process as terminal output
+                    }
+                    // Add handling for break_response (a break was sent on the serial line)
+                    else if (data.type === 'break_response') {
+                        if (!data.success) {
+                            statusDisplay.textContent = 'Failed to send break: ' + data.message;
+                            statusDisplay.style.color = 'red';
+                        }
+                        return true; // Don't process as terminal output
+                    }
+                    // Handle server_restart (the shell lives on and the session resumes on reconnect)
+                    else if (data.type === 'server_restart') {
+                        console.log("Server is restarting, session:", data.session_id);
+                        
+                        term.write('\r\n\x1b[33mServer is restarting. Reconnecting...\x1b[0m\r\n');
+                        statusDisplay.textContent = 'Server restarting';
+                        statusDisplay.style.color = 'orange';
+                        updateConnectionIndicator('reconnecting');
+                        return true; // Don't process as terminal output
+                    }
+                    // Add handling for server_shutdown (server is stopping and hangs up all shells)
+                    else if (data.type === 'server_shutdown') {
+                        console.log("Server is shutting down, session:", data.session_id);
+                        
+                        term.write('\r\n\x1b[33mServer is shutting down. Session terminated.\x1b[0m\r\n');
+                        statusDisplay.textContent = 'Server shut down';
+                        statusDisplay.style.color = 'red';
+                        
+                        // The shell is gone, so there is nothing to reconnect to
+                        cleanupTerminalState();
+                        clearSavedSession();
+                        sessionId = null;
+                        
+                        newSessionBtn.disabled = false;
+                        terminateBtn.disabled = true;
+                        updateConnectionIndicator('disconnected');
+                        return true; // Don't process as terminal output
+                    }
+                    return false;
+                }
+                
+                socket.onmessage = (event) => {
+                // Protocol v2: terminal data and control messages are told apart by the opcode
+                if (event.data instanceof ArrayBuffer) {
+                    const frame = new Uint8Array(event.data);
+                    if (frame[0] === FRAME_DATA) {
+                        term.write(frame.subarray(1));
+                    } else if (frame[0] === FRAME_CONTROL) {
+                        handleControlMessage(JSON.parse(textDecoder.decode(frame.subarray(1))));
+                    }
+                    return;
+                }
+                
+                // Legacy protocol
+                try {
+                    // Check if the entire message is a JSON control message
+                    const data = JSON.parse(event.data);
+                    if (!handleControlMessage(data)) {
+                        // Output that merely looks like JSON
+                        term.write(event.data);
                     }
                 } catch (e) {
                     // Not JSON, treat as normal terminal output
@@ -488,19 +599,19 @@ document.addEventListener('DOMContentLoaded', () => {
             // Create new handlers and store their disposables
             // Note: term.onData and term.onResize return disposable objects
             inputHandler = term.onData(data => {
-                if (socket && socket.readyState === WebSocket.OPEN) {
-                    socket.send(data);
+                if (socket && socket.readyState === WebSocket.OPEN && role !== 'read-only') {
+                    sendInput(data);
                 }
             });
             
             // Create new resize handler
             resizeHandler = term.onResize(size => {
-                if (socket && socket.readyState === WebSocket.OPEN && sessionId) {
-                    socket.send(JSON.stringify({
+                if (socket && socket.readyState === WebSocket.OPEN && sessionId && role !== 'read-only') {
+                    sendControl({
                         type: 'resize',
                         rows: size.rows,
                         cols: size.cols
-                    }));
+                    });
                 }
             });
             
@@ -624,10 +735,10 @@ document.addEventListener('DOMContentLoaded', () => {
             
             // Send termination request
             console.log("Sending termination request for session:", sessionId);
-            socket.send(JSON.stringify({
+            sendControl({
                 type: 'terminate',
-                sessionID: sessionId
-            }));
+                session_id: sessionId
+            });
             
             // Set a timeout to close the socket if the server doesn't respond
             setTimeout(() => {
@@ -669,6 +780,35 @@ document.addEventListener('DOMContentLoaded', () => {
         }
     });
     
+    // Handle share link creation
+    shareBtn.addEventListener('click', () => {
+        if (socket && socket.readyState === WebSocket.OPEN && sessionId) {
+            shareBtn.disabled = true;
+            sendControl({
+                type: 'share',
+                data: shareRoleSelect.value
+            });
+        }
+    });
+    
+    // Send a break, e.g. to stop a board's boot loader
+    breakBtn.addEventListener('click', () => {
+        if (socket && socket.readyState === WebSocket.OPEN && sessionId) {
+            sendControl({ type: 'break' });
+            term.focus();
+        }
+    });
+    
+    // Copy the share link to the clipboard
+    copyShareBtn.addEventListener('click', () => {
+        shareLinkInput.select();
+        if (navigator.clipboard) {
+            navigator.clipboard.writeText(shareLinkInput.value);
+        } else {
+            document.execCommand('copy');
+        }
+    });
+    
     // Handle new session creation
     newSessionBtn.addEventListener('click', () => {
         console.log("New session button clicked");
@@ -677,6 +817,10 @@ document.addEventListener('DOMContentLoaded', () => {
         clearSavedSession();
         sessionId = null;
         
+        // Links to the old session are useless now
+        shareLink.hidden = true;
+        shareLinkInput.value = '';
+        
         // Reset connection state completely
         resetConnectionState();
         
@@ -727,14 +871,111 @@ document.addEventListener('DOMContentLoaded', () => {
         }
     });
     
+    // Offer the server's command profiles next to the New Session button
+    function loadProfiles() {
+        fetch('/profiles')
+            .then(response => response.ok ? response.json() : [])
+            .then(profiles => {
+                profiles.forEach(profile => {
+                    const option = document.createElement('option');
+                    option.value = profile.name;
+                    option.textContent = profile.name;
+                    option.title = profile.description || '';
+                    profileSelect.appendChild(option);
+                });
+                profileSelect.hidden = profiles.length === 0;
+            })
+            .catch(error => console.error('Failed to load profiles:', error));
+    }
+    
+    // Offer the server's SSH targets next to the New Session button
+    function loadTargets() {
+        fetch('/targets')
+            .then(response => response.ok ? response.json() : [])
+            .then(targets => {
+                targets.forEach(target => {
+                    const option = document.createElement('option');
+                    option.value = target.name;
+                    option.textContent = target.name;
+                    option.title = target.description || '';
+                    targetSelect.appendChild(option);
+                });
+                targetSelect.hidden = targets.length === 0;
+            })
+            .catch(error => console.error('Failed to load targets:', error));
+    }
+    
+    // Offer the running containers the server allows next to the New Session button.
+    // Containers come and go, so the list is refreshed whenever it is opened.
+    function loadContainers() {
+        fetch('/containers')
+            .then(response => response.ok ? response.json() : [])
+            .then(containers => {
+                const selected = containerSelect.value;
+                containerSelect.length = 1;
+                containers.forEach(container => {
+                    const option = document.createElement('option');
+                    option.value = container.id;
+                    option.textContent = container.name;
+                    option.title = container.image;
+                    containerSelect.appendChild(option);
+                });
+                containerSelect.value = containers.some(c => c.id === selected) ? selected : '';
+                containerSelect.hidden = containers.length === 0;
+                updateSessionChoices();
+            })
+            .catch(error => console.error('Failed to load containers:', error));
+    }
+    
+    // Offer the server's serial ports next to the New Session button, with a button
+    // sending breaks to them
+    function loadSerialPorts() {
+        fetch('/serial-ports')
+            .then(response => response.ok ? response.json() : [])
+            .then(ports => {
+                ports.forEach(port => {
+                    const option = document.createElement('option');
+                    option.value = port.name;
+                    option.textContent = port.exclusive ? `${port.name} (exclusive)` : port.name;
+                    option.title = port.description || '';
+                    serialSelect.appendChild(option);
+                });
+                serialSelect.hidden = ports.length === 0;
+                breakBtn.hidden = ports.length === 0;
+            })
+            .catch(error => console.error('Failed to load serial ports:', error));
+    }
+    
+    // Profiles run locally, so they do not apply to sessions on a target, in a container
+    // or on a serial port, and serial ports take precedence over containers over targets
+    function updateSessionChoices() {
+        containerSelect.disabled = serialSelect.value !== '';
+        targetSelect.disabled = containerSelect.value !== '' || serialSelect.value !== '';
+        profileSelect.disabled = targetSelect.value !== '' || containerSelect.value !== '' || serialSelect.value !== '';
+    }
+    serialSelect.addEventListener('change', updateSessionChoices);
+    targetSelect.addEventListener('change', updateSessionChoices);
+    containerSelect.addEventListener('change', updateSessionChoices);
+    containerSelect.addEventListener('focus', loadContainers);
+    
     // Initialize connection indicator
     updateConnectionIndicator('disconnected');
     
     // Auto-connect if we have a token
-    if (getAuthToken()) {
-        // Try to connect with saved session if available
-        const savedSession = getSavedSession();
-        connectToTerminal(savedSession);
+    if (shareToken) {
+        // Guests only ever see the shared session
+        document.body.classList.add('shared-view');
+        connectToTerminal(null);
+    } else if (getAuthToken()) {
+        loadProfiles();
+        loadTargets();
+        loadContainers();
+        loadSerialPorts();
+        
+        // Attach to the session named in the URL (viewers can only watch existing
+        // sessions), otherwise try to connect with saved session if available
+        const requestedSession = new URLSearchParams(window.location.search).get('session');
+        connectToTerminal(requestedSession || getSavedSession());
     } else {
         // Redirect to login page if no token is found
         window.location.href = '/login.html';